@@ -1,14 +1,10 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
 	"github.com/miku/cali/internal/api"
 	"github.com/miku/cali/internal/config"
@@ -21,47 +17,51 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	slog.SetDefault(newLogger(cfg))
 
 	// Initialize database
-	database, err := db.New(cfg.Database.Path)
+	opts := db.Options{
+		Driver:             cfg.Database.Driver,
+		MaxOpenConns:       cfg.Database.MaxOpenConns,
+		MaxIdleConns:       cfg.Database.MaxIdleConns,
+		ConnMaxLifetime:    cfg.Database.ConnMaxLifetime,
+		BusyTimeout:        cfg.Database.BusyTimeout,
+		OverlapTolerance:   cfg.Calendar.OverlapTolerance,
+		ExpansionCacheSize: cfg.Database.ExpansionCacheSize,
+		ExpansionCacheTTL:  cfg.Database.ExpansionCacheTTL,
+	}
+	database, err := db.Connect(cfg.Database.DSN, opts, cfg.Database.ConnectAttempts, cfg.Database.ConnectTimeout)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer database.Close()
+
+	if err := database.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := database.SetUniqueAppointments(cfg.Database.UniqueAppointments); err != nil {
+		log.Fatalf("Failed to configure unique appointments: %v", err)
+	}
 
 	// Initialize API server
 	server := api.NewServer(database, cfg)
 
-	// Create HTTP server
+	// Serve until interrupted, then shut down gracefully
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	srv := &http.Server{
-		Addr:         addr,
-		Handler:      server.Router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	if err := server.Run(addr); err != nil {
+		log.Fatalf("Server error: %v", err)
 	}
+}
 
-	// Start server in a goroutine
-	go func() {
-		log.Printf("Starting server on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
-		}
-	}()
-
-	// Set up graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// newLogger returns a logger writing to stderr in the configured format and
+// level. Set as default, it also receives the output of the log package.
+func newLogger(cfg *config.Config) *slog.Logger {
+	// Validated when the configuration is loaded.
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.Log.Level))
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Log.Format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stderr, opts))
 	}
-
-	log.Println("Server exited properly")
+	return slog.New(slog.NewJSONHandler(os.Stderr, opts))
 }
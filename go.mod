@@ -1,8 +1,9 @@
 module github.com/miku/cali
 
-go 1.23.5
+go 1.24
 
 require (
+	github.com/go-pdf/fpdf v0.9.0
 	github.com/gorilla/mux v1.8.1
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/spf13/viper v1.19.0
@@ -1,47 +1,202 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/miku/cali/internal/config"
 	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
 	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/notify"
+	"github.com/miku/cali/internal/pb"
+	"github.com/miku/cali/internal/ratelimit"
+	"github.com/miku/cali/internal/recurrence"
+	"github.com/miku/cali/internal/reminder"
+	"github.com/miku/cali/internal/schedule"
+	"github.com/miku/cali/internal/webhook"
 )
 
 type Server struct {
-	Router *mux.Router
-	db     *db.Database
-	config *config.Config
+	Router         *mux.Router
+	db             db.Store
+	config         *config.Config
+	trustedProxies []netip.Prefix
+	limiter        *ratelimit.Limiter
+	secret         []byte
+	changes        *events.Bus
+	webhooks       *webhook.Dispatcher
+	mailer         *notify.Mailer
+	metrics        *serverMetrics
+	reminders      *reminder.Scheduler
+	templates      *template.Template
+	booking        *schedule.BookingHours
+	// imports holds a token for each import running, if their number is
+	// limited.
+	imports chan struct{}
+	// desktop is created on first use, as creating it probes for the
+	// notification tool.
+	desktop     *notify.Desktop
+	desktopOnce sync.Once
+	// davLogins remembers passwords checked by authenticateDAV, by a hash
+	// of the username and password.
+	davMu     sync.Mutex
+	davLogins map[[sha256.Size]byte]davLogin
 }
 
-func NewServer(db *db.Database, cfg *config.Config) *Server {
+func NewServer(db db.Store, cfg *config.Config) *Server {
 	s := &Server{
-		Router: mux.NewRouter(),
-		db:     db,
-		config: cfg,
+		Router:         mux.NewRouter(),
+		db:             db,
+		config:         cfg,
+		trustedProxies: parseTrustedProxies(cfg.Server.TrustedProxies),
+		secret:         []byte(cfg.Auth.Secret),
+		changes:        events.NewBus(changeHistory),
+		webhooks:       webhook.NewDispatcher(cfg.Webhooks.URLs, cfg.Webhooks.Secret, cfg.Webhooks.Timeout),
+		davLogins:      make(map[[sha256.Size]byte]davLogin),
+		booking:        newBookingHours(cfg),
+	}
+	if len(s.secret) == 0 {
+		log.Println("No auth.secret configured, using a random secret; tokens will not survive a restart")
+		s.secret = make([]byte, 32)
+		if _, err := rand.Read(s.secret); err != nil {
+			log.Fatalf("Failed to generate secret: %v", err)
+		}
+	}
+	templates, err := parseTemplates(cfg.Web.TemplatesDir)
+	if err != nil {
+		log.Fatalf("Failed to parse templates: %v", err)
+	}
+	s.templates = templates
+	smtp := cfg.SMTP
+	s.mailer, err = notify.NewMailer(smtp.Host, smtp.Port, smtp.Username, smtp.Password, smtp.From, smtp.Timeout)
+	if err != nil {
+		log.Fatalf("Failed to set up email: %v", err)
+	}
+	s.reminders = reminder.NewScheduler(db, reminder.Options{
+		Interval:     cfg.Reminders.Interval,
+		Tolerance:    cfg.Reminders.Tolerance,
+		DeferSnoozed: cfg.Reminders.Snoozed == config.SnoozedDefer,
+	}, s.sendReminder)
+	if rl := cfg.Server.RateLimit; rl.RPS > 0 {
+		burst := rl.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(rl.RPS))
+		}
+		s.limiter = ratelimit.New(rl.RPS, burst)
+	}
+	if n := cfg.Server.MaxConcurrentImports; n > 0 {
+		s.imports = make(chan struct{}, n)
+	}
+	if cfg.Metrics.Enabled {
+		s.metrics = newServerMetrics(db)
 	}
 	s.routes()
 	return s
 }
 
+// Handler returns the handler serving all routes, wrapped in middleware
+// that must run before routing.
+func (s *Server) Handler() http.Handler {
+	return s.recoverPanics(s.requestID(s.logRequests(s.cors(s.Router))))
+}
+
 func (s *Server) routes() {
-	// API routes
+	s.Router.Use(s.clientIPMiddleware)
+	if s.metrics != nil {
+		s.Router.Use(s.instrument)
+		s.Router.Handle("/metrics", s.metrics.registry).Methods("GET")
+	}
+	s.Router.Use(s.rateLimit)
+
+	// Login, logout, issuing tokens and creating users are the only API
+	// routes not requiring a token
+	s.Router.HandleFunc("/api/login", s.handleLogin).Methods("POST")
+	s.Router.HandleFunc("/api/auth/token", s.handleToken).Methods("POST")
+	s.Router.HandleFunc("/api/logout", s.handleLogout).Methods("POST")
+	s.Router.HandleFunc("/api/users", s.handleCreateUser).Methods("POST")
+
+	// API routes, each bounded by the timeout of its category
+	t := s.config.Timeouts
 	api := s.Router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/appointments", s.handleListAppointments).Methods("GET")
-	api.HandleFunc("/appointments", s.handleCreateAppointment).Methods("POST")
-	api.HandleFunc("/appointments/{id}", s.handleGetAppointment).Methods("GET")
-	api.HandleFunc("/appointments/{id}", s.handleUpdateAppointment).Methods("PUT")
-	api.HandleFunc("/appointments/{id}", s.handleDeleteAppointment).Methods("DELETE")
+	api.Use(s.authenticate, s.resolveTimezone)
+	api.Handle("/appointments", withTimeout(t.Read, s.handleListAppointments)).Methods("GET")
+	api.Handle("/appointments", withTimeout(t.Write, s.handleCreateAppointment)).Methods("POST")
+	api.Handle("/appointments/batch", s.limitImports(withTimeout(t.Import, s.handleCreateAppointments))).Methods("POST")
+	api.Handle("/appointments/count", withTimeout(t.Read, s.handleCountAppointments)).Methods("GET")
+	api.Handle("/appointments/changes", withTimeout(t.Poll, s.handleChanges)).Methods("GET")
+	api.HandleFunc("/stream", s.handleStream).Methods("GET")
+	api.Handle("/appointments.pdf", withTimeout(t.Export, s.handleExportPDF)).Methods("GET")
+	api.Handle("/appointments.ics", withTimeout(t.Export, s.handleExportICS)).Methods("GET")
+	api.Handle("/appointments/import", s.limitImports(withTimeout(t.Import, s.handleImportICS))).Methods("POST")
+	api.Handle("/appointments/merge", withTimeout(t.Write, s.handleMergeAppointments)).Methods("POST")
+	api.Handle("/appointments/reorder", withTimeout(t.Write, s.handleReorderAppointments)).Methods("POST")
+	api.Handle("/appointments/search", withTimeout(t.Read, s.handleSearchAppointments)).Methods("GET")
+	api.Handle("/appointments/upcoming", withTimeout(t.Read, s.handleUpcomingAppointments)).Methods("GET")
+	api.Handle("/appointments/{id}", withTimeout(t.Read, s.handleGetAppointment)).Methods("GET")
+	api.Handle("/appointments/slug/{slug}", withTimeout(t.Read, s.handleGetAppointmentBySlug)).Methods("GET")
+	api.Handle("/appointments/{id}", withTimeout(t.Write, s.handleUpdateAppointment)).Methods("PUT")
+	api.Handle("/appointments/{id}", withTimeout(t.Write, s.handlePatchAppointment)).Methods("PATCH")
+	api.Handle("/appointments/{id}", withTimeout(t.Write, s.handleDeleteAppointment)).Methods("DELETE")
+	api.Handle("/appointments/{id}/move", withTimeout(t.Write, s.handleMoveAppointment)).Methods("POST")
+	api.Handle("/appointments/{id}/restore", withTimeout(t.Write, s.handleRestoreAppointment)).Methods("POST")
+	api.Handle("/appointments/{id}/checkin", withTimeout(t.Write, s.handleCheckIn)).Methods("POST")
+	api.Handle("/appointments/{id}/checkout", withTimeout(t.Write, s.handleCheckOut)).Methods("POST")
+	api.Handle("/appointments/{id}/reminders", withTimeout(t.Read, s.handleListReminders)).Methods("GET")
+	api.Handle("/appointments/{id}/reminders", withTimeout(t.Write, s.handleCreateReminder)).Methods("POST")
+	api.Handle("/appointments/{id}/reminders/{reminderID}", withTimeout(t.Read, s.handleGetReminder)).Methods("GET")
+	api.Handle("/appointments/{id}/reminders/{reminderID}", withTimeout(t.Write, s.handleUpdateReminder)).Methods("PUT")
+	api.Handle("/appointments/{id}/reminders/{reminderID}", withTimeout(t.Write, s.handleDeleteReminder)).Methods("DELETE")
+	api.Handle("/reminders/snooze", withTimeout(t.Write, s.handleSnoozeReminders)).Methods("POST")
+	api.Handle("/appointments/{id}/attendees", withTimeout(t.Write, s.handleInviteAttendee)).Methods("POST")
+	api.Handle("/invitations", withTimeout(t.Read, s.handleListInvitations)).Methods("GET")
+	api.Handle("/invitations/{id}/respond", withTimeout(t.Write, s.handleRespondToInvitation)).Methods("POST")
+	api.Handle("/webhooks", withTimeout(t.Read, s.handleListWebhooks)).Methods("GET")
+	api.Handle("/webhooks", withTimeout(t.Write, s.handleCreateWebhook)).Methods("POST")
+	api.Handle("/webhooks/{id}", withTimeout(t.Write, s.handleDeleteWebhook)).Methods("DELETE")
+	api.Handle("/users/{id}", withTimeout(t.Read, s.handleGetUser)).Methods("GET")
+	api.Handle("/users/{id}", withTimeout(t.Write, s.handleUpdateUser)).Methods("PATCH")
+	api.Handle("/users/{id}/verify-email", withTimeout(t.Write, s.handleVerifyEmail)).Methods("POST")
+	api.Handle("/me/export", withTimeout(t.Export, s.handleExportArchive)).Methods("GET")
+	api.Handle("/me/import", s.limitImports(withTimeout(t.Import, s.handleImportArchive))).Methods("POST")
+	api.Handle("/export", withTimeout(t.Export, s.handleExportBackup)).Methods("GET")
+	api.Handle("/import", s.limitImports(withTimeout(t.Import, s.handleImportBackup))).Methods("POST")
+	api.Handle("/categories", withTimeout(t.Read, s.handleListCategories)).Methods("GET")
+	api.Handle("/availability", withTimeout(t.Read, s.handleAvailability)).Methods("GET")
+	api.Handle("/availability/slots", withTimeout(t.Read, s.handleSlots)).Methods("GET")
+	api.Handle("/gaps", withTimeout(t.Read, s.handleGaps)).Methods("GET")
+	api.Handle("/calendar", withTimeout(t.Read, s.handleCalendar)).Methods("GET")
+	api.Handle("/stats/daily-hours", withTimeout(t.Read, s.handleDailyHours)).Methods("GET")
+	api.Handle("/series/{id}/count", withTimeout(t.Read, s.handleSeriesCount)).Methods("GET")
+	api.Handle("/recurrence/check-working-hours", withTimeout(t.Read, s.handleCheckWorkingHours)).Methods("POST")
 
 	// Web interface routes
 	s.Router.PathPrefix("/static/").Handler(
 		http.StripPrefix("/static/",
 			http.FileServer(http.Dir(s.config.Web.StaticDir))))
-	s.Router.HandleFunc("/", s.handleIndex).Methods("GET")
+	s.Router.HandleFunc("/login", s.handleLoginPage).Methods("GET")
+	s.Router.HandleFunc("/login", s.handleLoginForm).Methods("POST")
+	s.Router.HandleFunc("/logout", s.handleLogoutForm).Methods("POST")
+	s.Router.Handle("/", s.authenticatePage(s.resolveTimezone(withTimeout(t.Read, s.handleIndex)))).Methods("GET")
+
+	s.Router.HandleFunc("/.well-known/caldav", s.handleWellKnownCalDAV)
+	s.Router.PathPrefix(davPrefix).Handler(s.authenticateDAV(s.resolveTimezone(withTimeout(t.Export, s.handleDAV))))
 }
 
 func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -56,44 +211,440 @@ func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}
 }
 
 func (s *Server) respondError(w http.ResponseWriter, status int, message string) {
-	s.respondJSON(w, status, map[string]string{"error": message})
+	body := map[string]string{"error": message}
+	// Set by the request ID middleware.
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	s.respondJSON(w, status, body)
+}
+
+// respondInternalError responds with 500 Internal Server Error. The error is
+// logged with an incident ID, which is included in the response so reports
+// can be matched with the log. Only in the dev environment does the
+// response reveal the error itself and a stack trace.
+func (s *Server) respondInternalError(w http.ResponseWriter, message string, err error) {
+	incident := newIncidentID()
+	requestID := w.Header().Get(requestIDHeader)
+	slog.Error(message, "error", err, "incident_id", incident, "request_id", requestID)
+
+	body := map[string]string{"error": message, "incident_id": incident}
+	if requestID != "" {
+		body["request_id"] = requestID
+	}
+	if s.config.Env == config.EnvDev {
+		body["detail"] = err.Error()
+		body["stack"] = string(debug.Stack())
+	}
+	s.respondJSON(w, http.StatusInternalServerError, body)
+}
+
+// newIncidentID returns a random identifier for an internal error.
+func newIncidentID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseTimeParam parses an RFC3339 query parameter, returning def when the
+// parameter is absent.
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, v)
 }
 
 // Request and response structures
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// listResponse is a page of appointments along with the total number of
+// appointments in the requested range.
+type listResponse struct {
+	Appointments []*models.Appointment `json:"appointments"`
+	Total        int                   `json:"total"`
+	Limit        int                   `json:"limit"`
+	Offset       int                   `json:"offset"`
+}
+
+// describedAppointment adds a derived, human-readable description of the
+// recurrence rule to an appointment.
+type describedAppointment struct {
+	*models.Appointment
+	RecurrenceDescription string `json:"recurrence_description"`
+}
+
 type createAppointmentRequest struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
+	Title         string            `json:"title"`
+	Slug          string            `json:"slug"`
+	Description   string            `json:"description"`
+	Location      string            `json:"location"`
+	ConferenceURL string            `json:"conference_url"`
+	Category      string            `json:"category"`
+	Color         string            `json:"color"`
+	StartTime     requestTime       `json:"start_time"`
+	EndTime       requestTime       `json:"end_time"`
+	AllDay        bool              `json:"all_day"`
+	Timezone      string            `json:"timezone"`
+	Recurrence    string            `json:"recurrence"`
+	Status        string            `json:"status"`
+	Attendees     []models.Attendee `json:"attendees"`
+	// ReminderChannels override how reminders of the appointment are
+	// sent, see models.Appointment.
+	ReminderChannels []string `json:"reminder_channels"`
+	// UpdatedAt makes an update conditional on the appointment not having
+	// been modified since, like an If-Match header.
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// attendees returns the requested attendees, with response statuses
+// defaulting to needs-action.
+func (req *createAppointmentRequest) attendees() []models.Attendee {
+	for i := range req.Attendees {
+		if req.Attendees[i].ResponseStatus == "" {
+			req.Attendees[i].ResponseStatus = models.ResponseNeedsAction
+		}
+		// Users are only invited with handleInviteAttendee.
+		req.Attendees[i].UserID = 0
+	}
+	return req.Attendees
+}
+
+// timezone returns the requested timezone, defaulting to def.
+func (req *createAppointmentRequest) timezone(def string) string {
+	if req.Timezone == "" {
+		return def
+	}
+	return req.Timezone
+}
+
+// status returns the requested status, defaulting to confirmed.
+func (req *createAppointmentRequest) status() string {
+	if req.Status == "" {
+		return models.StatusConfirmed
+	}
+	return req.Status
+}
+
+// setTimes sets the start and end time of appt from the request. Times of
+// all-day appointments are truncated to midnight in the timezone of appt;
+// other appointments require a date-time.
+func (req *createAppointmentRequest) setTimes(appt *models.Appointment) error {
+	appt.AllDay = req.AllDay
+	if req.AllDay {
+		loc := appt.Location()
+		appt.StartTime, appt.EndTime = req.StartTime.date(loc), req.EndTime.date(loc)
+		return nil
+	}
+	if req.StartTime.DateOnly || req.EndTime.DateOnly {
+		return errors.New("start and end time must include a time unless all_day is set")
+	}
+	appt.StartTime, appt.EndTime = req.StartTime.Time, req.EndTime.Time
+	return nil
+}
+
+// maxListRange bounds the range appointments are listed, counted or
+// streamed for, as recurring appointments are expanded over it.
+const maxListRange = 366 * 24 * time.Hour
+
+// parseRange reads the start and end query parameters (RFC3339) of a list
+// request. The range parameter ("day", "week" or "month") selects the period
+// covered, defaulting to the configured view. Without start, the range
+// begins with the current period in the request's timezone (see
+// resolveTimezone); without end, it spans one period from start. The range
+// must not exceed maxListRange. On invalid input an error response is
+// written and ok is false.
+func (s *Server) parseRange(w http.ResponseWriter, r *http.Request) (start, end time.Time, ok bool) {
+	q := r.URL.Query()
+
+	loc := requestLocation(r.Context())
+
+	name := q.Get("range")
+	if name == "" {
+		name = s.config.Calendar.DefaultView
+	}
+	view, err := schedule.ParseView(name)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid range")
+		return start, end, false
+	}
+
+	// Validated when the configuration is loaded.
+	firstDay, _ := schedule.ParseWeekday(s.config.Calendar.FirstDayOfWeek)
+	start, err = parseTimeParam(r, "start", view.Start(time.Now().In(loc), firstDay))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid start time")
+		return start, end, false
+	}
+	end, err = parseTimeParam(r, "end", view.Next(start))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid end time")
+		return start, end, false
+	}
+	if end.Before(start) {
+		s.respondError(w, http.StatusBadRequest, "End time before start time")
+		return start, end, false
+	}
+	if end.Sub(start) > maxListRange {
+		s.respondError(w, http.StatusBadRequest, "Range must not exceed 366 days")
+		return start, end, false
+	}
+	return start, end, true
 }
 
 func (s *Server) handleListAppointments(w http.ResponseWriter, r *http.Request) {
-	// For now, just return empty list
-	s.respondJSON(w, http.StatusOK, []models.Appointment{})
+	start, end, ok := s.parseRange(w, r)
+	if !ok {
+		return
+	}
+	filter := db.Filter{
+		Status:   r.URL.Query().Get("status"),
+		Category: r.URL.Query().Get("category"),
+	}
+	if filter.Status != "" && !models.ValidStatus(filter.Status) {
+		s.respondError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		s.streamAppointments(w, r, start, end, filter)
+		return
+	}
+
+	limit, offset := defaultPageLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.respondError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+		offset = n
+	}
+
+	appointments, total, err := s.db.ListAppointments(r.Context(), UserID(r.Context()), start, end, filter, limit, offset)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	if wantsProtobuf(r) {
+		s.respondProtobuf(w, http.StatusOK, pb.MarshalAppointmentList(appointments, total, limit, offset))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, listResponse{
+		Appointments: localize(r, appointments),
+		Total:        total,
+		Limit:        limit,
+		Offset:       offset,
+	})
+}
+
+// streamAppointments writes appointments as newline delimited JSON, one
+// object per line, directly as rows are read from the database. Headers are
+// already sent once streaming begins, so a failure midway is signaled by a
+// trailing {"error": ...} object.
+func (s *Server) streamAppointments(w http.ResponseWriter, r *http.Request, start, end time.Time, filter db.Filter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	err := s.db.StreamAppointments(r.Context(), UserID(r.Context()), start, end, filter, func(a *models.Appointment) error {
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		enc.Encode(map[string]string{"error": "Failed to list appointments"})
+	}
 }
 
 func (s *Server) handleCreateAppointment(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key != "" && !validIdempotencyKey(key) {
+		s.respondError(w, http.StatusBadRequest, "Invalid Idempotency-Key header")
+		return
+	}
+	force, ok := s.parseForce(w, r)
+	if !ok {
+		return
+	}
 	var req createAppointmentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	var hash string
+	if key != "" {
+		var err error
+		if hash, err = requestHash(req); err != nil {
+			s.respondInternalError(w, "Failed to hash request", err)
+			return
+		}
+		// Replay before checking for conflicts, which the original
+		// appointment would be.
+		if s.replayIdempotent(w, r, key, hash) {
+			return
+		}
+	}
+
+	tz, err := s.defaultTimezone(r.Context())
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
 		return
 	}
 
-	// For now, hardcode user_id as 1
 	appt := &models.Appointment{
-		UserID:      1,
-		Title:       req.Title,
-		Description: req.Description,
-		StartTime:   req.StartTime,
-		EndTime:     req.EndTime,
+		UserID:           UserID(r.Context()),
+		Title:            req.Title,
+		Slug:             req.Slug,
+		Description:      req.Description,
+		Place:            req.Location,
+		ConferenceURL:    req.ConferenceURL,
+		Category:         req.Category,
+		Color:            req.Color,
+		Timezone:         req.timezone(tz),
+		Recurrence:       req.Recurrence,
+		Status:           req.status(),
+		Attendees:        req.attendees(),
+		ReminderChannels: req.ReminderChannels,
+	}
+	if err := req.setTimes(appt); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.normalizeTitle(appt)
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.checkReminderChannels(w, appt) || !s.checkBooking(w, appt) {
+		return
+	}
+
+	if key != "" {
+		err = s.db.CreateAppointmentWithKey(r.Context(), appt, key, hash, s.idempotencyWindow(), force)
+	} else {
+		err = s.db.CreateAppointment(r.Context(), appt, force)
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrIdempotencyKeyExists) && s.replayIdempotent(w, r, key, hash) {
+			return
+		}
+		var conflict *db.ConflictError
+		if errors.As(err, &conflict) {
+			s.respondConflict(w, conflict, 0)
+			return
+		}
+		if s.respondDuplicate(w, err) {
+			return
+		}
+		s.respondInternalError(w, "Failed to create appointment", err)
+		return
 	}
+	s.notify(events.Created, appt)
+
+	s.respondJSON(w, http.StatusCreated, localizeOne(r, appt))
+}
 
-	if err := s.db.CreateAppointment(appt); err != nil {
-		s.respondError(w, http.StatusInternalServerError, "Failed to create appointment")
+// normalizeTitle tidies up the title of appt, if configured.
+func (s *Server) normalizeTitle(appt *models.Appointment) {
+	if !s.config.Titles.Normalize {
 		return
 	}
+	normalized := models.NormalizeTitle(appt.Title, s.config.Titles.TitleCase)
+	if s.config.Titles.KeepOriginal && normalized != appt.Title {
+		appt.OriginalTitle = appt.Title
+	}
+	appt.Title = normalized
+}
+
+// parseForce reads the force parameter, which lets a write store an
+// appointment overlapping others. On an invalid value an error response is
+// written and ok is false.
+func (s *Server) parseForce(w http.ResponseWriter, r *http.Request) (force, ok bool) {
+	v := r.URL.Query().Get("force")
+	if v == "" {
+		return false, true
+	}
+	force, err := strconv.ParseBool(v)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid force")
+		return false, false
+	}
+	return force, true
+}
 
-	s.respondJSON(w, http.StatusCreated, appt)
+// respondConflict responds with 409 Conflict to a write rejected for
+// overlapping other appointments, listing their IDs; occurrences of a
+// series are listed by the ID of the series. A position other than zero
+// names the appointment within a batch, starting at 1.
+func (s *Server) respondConflict(w http.ResponseWriter, conflict *db.ConflictError, position int) {
+	ids := []int64{}
+	seen := make(map[int64]bool)
+	for _, a := range conflict.Conflicts {
+		if !seen[a.ID] {
+			seen[a.ID] = true
+			ids = append(ids, a.ID)
+		}
+	}
+	message := fmt.Sprintf("Conflicts with appointment %q", conflict.Conflicts[0].Title)
+	if position != 0 {
+		message = fmt.Sprintf("Appointment %d: conflicts with appointment %q", position, conflict.Conflicts[0].Title)
+	}
+	body := map[string]interface{}{
+		"error":     message,
+		"conflicts": ids,
+	}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	s.respondJSON(w, http.StatusConflict, body)
+}
+
+// respondDuplicate responds with 409 Conflict if err is a violation of
+// one of the unique constraints on appointments and their attendees,
+// naming the constraint, and reports whether it did.
+func (s *Server) respondDuplicate(w http.ResponseWriter, err error) bool {
+	var message string
+	switch {
+	case errors.Is(err, db.ErrDuplicateAppointment):
+		message = "Duplicate appointment: same title and start time already exists"
+	case errors.Is(err, db.ErrSlugExists):
+		message = "Duplicate slug: already used by another appointment"
+	case errors.Is(err, db.ErrUIDExists):
+		message = "Duplicate UID: already used by another appointment"
+	case errors.Is(err, db.ErrAttendeeExists):
+		message = "Duplicate attendee: already invited"
+	default:
+		return false
+	}
+	s.respondError(w, http.StatusConflict, message)
+	return true
+}
+
+// conflictRange returns the range another appointment must overlap to
+// conflict with appt, narrowed by the overlap tolerance, see
+// models.Appointment.ConflictRange.
+func (s *Server) conflictRange(appt *models.Appointment) (start, end time.Time) {
+	return appt.ConflictRange(s.config.Calendar.OverlapTolerance)
 }
 
 func (s *Server) handleGetAppointment(w http.ResponseWriter, r *http.Request) {
@@ -104,9 +655,51 @@ func (s *Server) handleGetAppointment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appt, err := s.db.GetAppointment(id)
+	// Deleted appointments are only returned on request, so they can be
+	// inspected before restoring them.
+	get := s.db.GetAppointment
+	if r.URL.Query().Get("include_deleted") == "true" {
+		get = s.db.GetAppointmentIncludingDeleted
+	}
+	appt, err := get(r.Context(), id)
 	if err != nil {
-		s.respondError(w, http.StatusInternalServerError, "Failed to get appointment")
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+
+	w.Header().Set("ETag", etag(appt))
+	// The recurrence description is only available in JSON.
+	if wantsProtobuf(r) {
+		s.respondProtobuf(w, http.StatusOK, pb.MarshalAppointment(appt))
+		return
+	}
+	if r.URL.Query().Get("describe") == "true" && appt.Recurrence != "" {
+		description, err := recurrence.Describe(appt.Recurrence)
+		if err != nil {
+			s.respondInternalError(w, "Failed to describe recurrence", err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, describedAppointment{localizeOne(r, appt), description})
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
+}
+
+func (s *Server) handleGetAppointmentBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !models.ValidSlug(vars["slug"]) {
+		s.respondError(w, http.StatusBadRequest, "Invalid slug")
+		return
+	}
+
+	appt, err := s.db.GetAppointmentBySlug(r.Context(), UserID(r.Context()), vars["slug"])
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
 		return
 	}
 	if appt == nil {
@@ -114,7 +707,12 @@ func (s *Server) handleGetAppointment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, appt)
+	w.Header().Set("ETag", etag(appt))
+	if wantsProtobuf(r) {
+		s.respondProtobuf(w, http.StatusOK, pb.MarshalAppointment(appt))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
 }
 
 func (s *Server) handleUpdateAppointment(w http.ResponseWriter, r *http.Request) {
@@ -124,28 +722,83 @@ func (s *Server) handleUpdateAppointment(w http.ResponseWriter, r *http.Request)
 		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
 		return
 	}
+	force, ok := s.parseForce(w, r)
+	if !ok {
+		return
+	}
 
 	var req createAppointmentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+
+	tz, err := s.defaultTimezone(r.Context())
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
 		return
 	}
 
 	appt := &models.Appointment{
-		ID:          id,
-		UserID:      1, // Hardcoded for now
-		Title:       req.Title,
-		Description: req.Description,
-		StartTime:   req.StartTime,
-		EndTime:     req.EndTime,
+		ID:               id,
+		UserID:           UserID(r.Context()),
+		Title:            req.Title,
+		Slug:             req.Slug,
+		Description:      req.Description,
+		Place:            req.Location,
+		ConferenceURL:    req.ConferenceURL,
+		Category:         req.Category,
+		Color:            req.Color,
+		Timezone:         req.timezone(tz),
+		Recurrence:       req.Recurrence,
+		Status:           req.status(),
+		Attendees:        req.attendees(),
+		ReminderChannels: req.ReminderChannels,
+	}
+	if err := req.setTimes(appt); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.normalizeTitle(appt)
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.checkReminderChannels(w, appt) || !s.checkBooking(w, appt) {
+		return
 	}
 
-	if err := s.db.UpdateAppointment(appt); err != nil {
-		s.respondError(w, http.StatusInternalServerError, "Failed to update appointment")
+	// The update is conditional on the version the client last saw, if
+	// given as entity tag or update time.
+	version, ok := ifMatch(r)
+	if !ok {
+		s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
 		return
 	}
+	appt.Version = version
+	if r.Header.Get("If-Match") == "" && req.UpdatedAt != nil {
+		appt.UpdatedAt = *req.UpdatedAt
+	}
+
+	if err := s.db.UpdateAppointment(r.Context(), appt, force); err != nil {
+		var conflict *db.ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			s.respondConflict(w, conflict, 0)
+		case errors.Is(err, db.ErrStaleAppointment):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to update appointment", err)
+			}
+		}
+		return
+	}
+	s.notify(events.Updated, appt)
+	w.Header().Set("ETag", etag(appt))
 
-	s.respondJSON(w, http.StatusOK, appt)
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
 }
 
 func (s *Server) handleDeleteAppointment(w http.ResponseWriter, r *http.Request) {
@@ -156,16 +809,75 @@ func (s *Server) handleDeleteAppointment(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.db.DeleteAppointment(id, 1); err != nil { // Hardcoded user_id
-		s.respondError(w, http.StatusInternalServerError, "Failed to delete appointment")
+	// The deletion is conditional on the version the client last saw, if
+	// given.
+	version, ok := ifMatch(r)
+	if !ok {
+		s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
 		return
 	}
 
+	// Keep the appointment to tell webhook receivers what was deleted.
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "", scopeAll:
+	case scopeSingle, scopeFollowing:
+		if appt == nil || appt.UserID != UserID(r.Context()) {
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+			return
+		}
+		if version != 0 {
+			appt.Version = version
+		}
+		if s.deleteOccurrences(w, r, appt, scope) {
+			return
+		}
+	default:
+		s.respondError(w, http.StatusBadRequest, "Invalid scope, must be single, following or all")
+		return
+	}
+	if err := s.db.DeleteAppointment(r.Context(), id, UserID(r.Context()), version); err != nil {
+		switch {
+		case errors.Is(err, db.ErrStaleAppointment):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		default:
+			s.respondInternalError(w, "Failed to delete appointment", err)
+		}
+		return
+	}
+	s.notify(events.Deleted, appt)
+
 	s.respondJSON(w, http.StatusNoContent, nil)
 }
 
-func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	// For now, just return a simple message
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("Welcome to Cali Appointment Scheduler"))
+// handleRestoreAppointment undoes the deletion of an appointment.
+func (s *Server) handleRestoreAppointment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+
+	appt, err := s.db.RestoreAppointment(r.Context(), id, UserID(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Deleted appointment not found")
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to restore appointment", err)
+			}
+		}
+		return
+	}
+	s.notify(events.Created, appt)
+
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
 }
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miku/cali/internal/auth"
+	"github.com/miku/cali/internal/config"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/webhook"
+)
+
+// newTestServer returns a server backed by a fresh database with a single
+// user, and an access token of that user.
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	// The default configuration expects the web directory in the
+	// working directory.
+	t.Chdir("../..")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Database.Path = filepath.Join(t.TempDir(), "cali.db")
+
+	d, err := db.New(cfg.Database.Path, db.Options{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice"}
+	if err := d.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(d, cfg)
+	token, err := auth.Sign(s.secret, user.ID, user.Username, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, token
+}
+
+// serve sends a request with the token to the server and returns the
+// response.
+func serve(s *Server, token, method, path string) *httptest.ResponseRecorder {
+	return send(s, token, httptest.NewRequest(method, path, nil))
+}
+
+// send sends req with the token to the server and returns the response.
+func send(s *Server, token string, req *http.Request) *httptest.ResponseRecorder {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEmptyLists(t *testing.T) {
+	s, token := newTestServer(t)
+	var cases = []struct {
+		path string
+		// field holds the list within an object, if it is not the body
+		// itself.
+		field string
+	}{
+		{"/api/appointments", "appointments"},
+		{"/api/appointments?start=2030-01-01T00:00:00Z&end=2030-02-01T00:00:00Z", "appointments"},
+		{"/api/appointments/search?q=standup", ""},
+		{"/api/appointments/upcoming", ""},
+		{"/api/invitations", ""},
+		{"/api/webhooks", ""},
+	}
+	for _, c := range cases {
+		rec := serve(s, token, http.MethodGet, c.path)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: got status %d, want %d: %s", c.path, rec.Code, http.StatusOK, rec.Body)
+			continue
+		}
+		list := json.RawMessage(rec.Body.Bytes())
+		if c.field != "" {
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Errorf("GET %s: %v", c.path, err)
+				continue
+			}
+			list = body[c.field]
+		}
+		if got := strings.TrimSpace(string(list)); got != "[]" {
+			t.Errorf("GET %s: got %s, want []", c.path, got)
+		}
+	}
+}
+
+func TestDailyHoursRange(t *testing.T) {
+	s, token := newTestServer(t)
+	var cases = []struct {
+		start, end string
+		want       int
+	}{
+		{"2026-01-01T00:00:00Z", "2026-01-02T00:00:00Z", http.StatusOK},
+		{"2026-01-01T00:00:00Z", "2027-01-02T00:00:00Z", http.StatusOK},
+		{"2026-01-01T00:00:00Z", "2027-01-02T00:00:01Z", http.StatusBadRequest},
+		{"2000-01-01T00:00:00Z", "2100-01-01T00:00:00Z", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		path := "/api/stats/daily-hours?start=" + c.start + "&end=" + c.end
+		if rec := serve(s, token, http.MethodGet, path); rec.Code != c.want {
+			t.Errorf("GET %s: got status %d, want %d: %s", path, rec.Code, c.want, rec.Body)
+		}
+	}
+}
+
+func TestConditionalChanges(t *testing.T) {
+	s, token := newTestServer(t)
+	ctx := context.Background()
+	user, err := s.db.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	appt := &models.Appointment{
+		UserID:    user.ID,
+		Title:     "Standup",
+		StartTime: start,
+		EndTime:   start.Add(15 * time.Minute),
+		Status:    models.StatusConfirmed,
+	}
+	if err := s.db.CreateAppointment(ctx, appt, false); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/appointments/" + strconv.FormatInt(appt.ID, 10)
+
+	var cases = []struct {
+		method, ifMatch string
+		status          int
+		etag            string
+	}{
+		{http.MethodPatch, `"2"`, http.StatusPreconditionFailed, ""},
+		{http.MethodPatch, `"1"`, http.StatusOK, `"2"`},
+		// The version the client saw is gone after a change.
+		{http.MethodPatch, `"1"`, http.StatusPreconditionFailed, ""},
+		{http.MethodPatch, `W/"2"`, http.StatusOK, `"3"`},
+		{http.MethodPatch, "", http.StatusOK, `"4"`},
+		{http.MethodDelete, `"3"`, http.StatusPreconditionFailed, ""},
+		{http.MethodDelete, "standup", http.StatusPreconditionFailed, ""},
+		{http.MethodDelete, `"4"`, http.StatusNoContent, ""},
+		{http.MethodDelete, `"4"`, http.StatusNotFound, ""},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.method, path, strings.NewReader(`{"title": "Daily standup"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if c.ifMatch != "" {
+			req.Header.Set("If-Match", c.ifMatch)
+		}
+		rec := send(s, token, req)
+		if rec.Code != c.status {
+			t.Errorf("%d: %s with If-Match %s: got status %d, want %d: %s",
+				i, c.method, c.ifMatch, rec.Code, c.status, rec.Body)
+		}
+		if got := rec.Header().Get("ETag"); c.etag != "" && got != c.etag {
+			t.Errorf("%d: %s with If-Match %s: got ETag %s, want %s", i, c.method, c.ifMatch, got, c.etag)
+		}
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	var cases = []struct {
+		name   string
+		open   bool
+		admins []string
+		// withToken sends alice's token.
+		withToken bool
+		want      int
+	}{
+		{"closed without token", false, nil, false, http.StatusForbidden},
+		{"closed by non-admin", false, []string{"bob"}, true, http.StatusForbidden},
+		{"closed by admin", false, []string{"alice"}, true, http.StatusCreated},
+		{"open without token", true, nil, false, http.StatusCreated},
+		{"open by anyone", true, nil, true, http.StatusCreated},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, token := newTestServer(t)
+			s.config.Auth.OpenRegistration = c.open
+			s.config.Auth.Admins = c.admins
+			if !c.withToken {
+				token = ""
+			}
+			req := httptest.NewRequest("POST", "/api/users", strings.NewReader(`{"username": "carol", "password": "correct horse"}`))
+			if rec := send(s, token, req); rec.Code != c.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, c.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestCreateFirstUser(t *testing.T) {
+	s, _ := newTestServer(t)
+	d, err := db.New(filepath.Join(t.TempDir(), "empty.db"), db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	s = NewServer(d, s.config)
+
+	for _, want := range []int{http.StatusCreated, http.StatusForbidden} {
+		req := httptest.NewRequest("POST", "/api/users", strings.NewReader(`{"username": "carol", "password": "correct horse"}`))
+		if rec := send(s, "", req); rec.Code != want {
+			t.Errorf("got %d, want %d: %s", rec.Code, want, rec.Body)
+		}
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s, token := newTestServer(t)
+	ctx := context.Background()
+	user, err := s.db.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Email = "alice@example.com"
+	user.EmailNotifications = []string{"created"}
+	if err := s.db.UpdateUserSettings(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/users/" + strconv.FormatInt(user.ID, 10) + "/verify-email"
+	code := auth.EmailCode(s.secret, user.ID, user.Email)
+
+	var cases = []struct {
+		name, code string
+		want       int
+		verified   bool
+	}{
+		{"wrong code", "nope", http.StatusBadRequest, false},
+		{"code of another address", auth.EmailCode(s.secret, user.ID, "bob@example.com"), http.StatusBadRequest, false},
+		{"right code", code, http.StatusOK, true},
+		{"again", code, http.StatusOK, true},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"code": "`+c.code+`"}`))
+		if rec := send(s, token, req); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d: %s", c.name, rec.Code, c.want, rec.Body)
+		}
+		got, err := s.db.GetUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.EmailVerified != c.verified || got.WantsEmail("created") != c.verified {
+			t.Errorf("%s: got verified %v, want %v", c.name, got.EmailVerified, c.verified)
+		}
+	}
+
+	// Changing the address makes it unverified.
+	req := httptest.NewRequest("PATCH", "/api/users/"+strconv.FormatInt(user.ID, 10), strings.NewReader(`{"email": "alice@example.org"}`))
+	rec := send(s, token, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	var updated models.User
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.EmailVerified {
+		t.Error("changed address is still verified")
+	}
+	req = httptest.NewRequest("POST", path, strings.NewReader(`{"code": "`+code+`"}`))
+	if rec := send(s, token, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("code of the old address: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	s, token := newTestServer(t)
+	s.Router.HandleFunc("/api/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	var cases = []struct {
+		name, requestID string
+	}{
+		{"with request ID", "req-42"},
+		{"generated request ID", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/panic", nil)
+		if c.requestID != "" {
+			req.Header.Set(requestIDHeader, c.requestID)
+		}
+		rec := send(s, token, req)
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, http.StatusInternalServerError)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("%s: got content type %q", c.name, got)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		want := rec.Header().Get(requestIDHeader)
+		if c.requestID != "" && want != c.requestID {
+			t.Errorf("%s: got request ID header %q, want %q", c.name, want, c.requestID)
+		}
+		if body["error"] == "" || body["request_id"] == "" || body["request_id"] != want {
+			t.Errorf("%s: got body %v, want an error and request ID %q", c.name, body, want)
+		}
+	}
+}
+
+func TestIsHTTPS(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.trustedProxies = parseTrustedProxies([]string{"10.0.0.0/8"})
+	var cases = []struct {
+		name, remoteAddr, proto string
+		tls                     bool
+		want                    bool
+	}{
+		{"plain", "192.0.2.1:1234", "", false, false},
+		{"tls", "192.0.2.1:1234", "", true, true},
+		{"trusted proxy", "10.0.0.1:1234", "https", false, true},
+		{"trusted proxy, several hops", "10.0.0.1:1234", "HTTPS, http", false, true},
+		{"trusted proxy over http", "10.0.0.1:1234", "http", false, false},
+		{"untrusted peer", "192.0.2.1:1234", "https", false, false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/login", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", c.proto)
+		}
+		if c.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if got := s.isHTTPS(req); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSlotsStep(t *testing.T) {
+	s, token := newTestServer(t)
+	var cases = []struct {
+		step string
+		want int
+	}{
+		{"1ns", http.StatusBadRequest},
+		{"59s", http.StatusBadRequest},
+		{"-15m", http.StatusBadRequest},
+		{"1m", http.StatusOK},
+		{"30m", http.StatusOK},
+	}
+	for _, c := range cases {
+		path := "/api/availability/slots?duration=30m&start=2030-01-07T00:00:00Z&end=2030-02-07T00:00:00Z&step=" + c.step
+		if rec := serve(s, token, "GET", path); rec.Code != c.want {
+			t.Errorf("step %s: got %d, want %d: %s", c.step, rec.Code, c.want, rec.Body)
+		}
+	}
+}
+
+func TestListRange(t *testing.T) {
+	s, token := newTestServer(t)
+	var cases = []struct {
+		path, start, end string
+		want             int
+	}{
+		{"/api/appointments", "2030-01-01T00:00:00Z", "2031-01-01T00:00:00Z", http.StatusOK},
+		{"/api/appointments", "2030-01-01T00:00:00Z", "2031-01-03T00:00:00Z", http.StatusBadRequest},
+		{"/api/appointments", "0001-01-01T00:00:00Z", "9999-12-31T00:00:00Z", http.StatusBadRequest},
+		{"/api/appointments/count", "2030-01-01T00:00:00Z", "2031-01-01T00:00:00Z", http.StatusOK},
+		{"/api/appointments/count", "2030-01-01T00:00:00Z", "2040-01-01T00:00:00Z", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		path := c.path + "?start=" + c.start + "&end=" + c.end
+		if rec := serve(s, token, "GET", path); rec.Code != c.want {
+			t.Errorf("%s: got %d, want %d: %s", path, rec.Code, c.want, rec.Body)
+		}
+	}
+}
+
+func TestReminderChannels(t *testing.T) {
+	s, token := newTestServer(t)
+	ctx := context.Background()
+	user, err := s.db.GetUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	appt := &models.Appointment{
+		UserID:    user.ID,
+		Title:     "Review",
+		StartTime: start,
+		EndTime:   start.Add(time.Hour),
+		Status:    models.StatusConfirmed,
+	}
+	if err := s.db.CreateAppointment(ctx, appt, false); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/appointments/" + strconv.FormatInt(appt.ID, 10)
+
+	var cases = []struct {
+		body   string
+		status int
+		want   []string
+	}{
+		{`{"reminder_channels": ["pager"]}`, http.StatusBadRequest, nil},
+		{`{"reminder_channels": ["webhook", "webhook"]}`, http.StatusBadRequest, nil},
+		// No SMTP server is configured.
+		{`{"reminder_channels": ["email"]}`, http.StatusUnprocessableEntity, nil},
+		{`{"reminder_channels": ["webhook", "log"]}`, http.StatusOK, []string{"webhook", "log"}},
+		{`{"title": "Design review"}`, http.StatusOK, []string{"webhook", "log"}},
+		{`{"reminder_channels": []}`, http.StatusOK, nil},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := send(s, token, req)
+		if rec.Code != c.status {
+			t.Errorf("%s: got status %d, want %d: %s", c.body, rec.Code, c.status, rec.Body)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		stored, err := s.db.GetAppointment(ctx, appt.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(stored.ReminderChannels, c.want) {
+			t.Errorf("%s: got channels %q, want %q", c.body, stored.ReminderChannels, c.want)
+		}
+	}
+}
+
+func TestSendReminder(t *testing.T) {
+	s, _ := newTestServer(t)
+	var cases = []struct {
+		method   string
+		channels []string
+		posts    int
+	}{
+		{models.ReminderLog, nil, 0},
+		{models.ReminderWebhook, nil, 1},
+		// The appointment's channels replace the reminder's method.
+		{models.ReminderLog, []string{models.ReminderWebhook}, 1},
+		{models.ReminderWebhook, []string{models.ReminderLog}, 0},
+	}
+	for _, c := range cases {
+		var posts atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			posts.Add(1)
+		}))
+		s.webhooks = webhook.NewDispatcher([]string{srv.URL}, "", time.Second)
+		start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+		s.sendReminder(db.DueReminder{
+			Reminder: models.Reminder{Method: c.method},
+			Appointment: &models.Appointment{
+				ID:               1,
+				Title:            "Review",
+				StartTime:        start,
+				EndTime:          start.Add(time.Hour),
+				ReminderChannels: c.channels,
+			},
+			At: start,
+		})
+		if err := s.webhooks.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		if got := int(posts.Load()); got != c.posts {
+			t.Errorf("method %s, channels %q: got %d posts, want %d", c.method, c.channels, got, c.posts)
+		}
+	}
+}
+
+func TestDAVBasicAuth(t *testing.T) {
+	s, _ := newTestServer(t)
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := &models.User{Username: "bob", PasswordHash: hash}
+	if err := s.db.CreateUser(context.Background(), bob); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.Sign(s.secret, bob.ID, bob.Username, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := auth.Sign(s.secret, bob.ID, bob.Username, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		username, password string
+		want               int
+	}{
+		{"bob", "correct horse", http.StatusMultiStatus},
+		// The second request is answered from the remembered check.
+		{"bob", "correct horse", http.StatusMultiStatus},
+		{"bob", "wrong horse", http.StatusUnauthorized},
+		{"bob", token, http.StatusMultiStatus},
+		{"bob", expired, http.StatusUnauthorized},
+		{"alice", token, http.StatusUnauthorized},
+		{"alice", "", http.StatusUnauthorized},
+		{"carol", "correct horse", http.StatusUnauthorized},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		req.Header.Set("Depth", "0")
+		req.SetBasicAuth(c.username, c.password)
+		if rec := send(s, "", req); rec.Code != c.want {
+			t.Errorf("%d: %s: got %d, want %d: %s", i, c.username, rec.Code, c.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// archiveVersion is the version of the data archive format, increased on
+// incompatible changes.
+const archiveVersion = 1
+
+// archive holds all data of a user, for moving an account to another
+// instance. Appointments include their attendees.
+type archive struct {
+	Version      int                   `json:"version"`
+	ExportedAt   time.Time             `json:"exported_at"`
+	Appointments []*models.Appointment `json:"appointments"`
+}
+
+// handleExportArchive serves all data of the user as a JSON archive, which
+// can be restored with handleImportArchive. Appointments are written as they
+// are read from the database. A failure midway leaves the archive
+// truncated, which the import rejects as invalid JSON.
+func (s *Server) handleExportArchive(w http.ResponseWriter, r *http.Request) {
+	exportedAt := time.Now().UTC()
+	header, err := json.Marshal(struct {
+		Version    int       `json:"version"`
+		ExportedAt time.Time `json:"exported_at"`
+	}{archiveVersion, exportedAt})
+	if err != nil {
+		s.respondInternalError(w, "Failed to export data", err)
+		return
+	}
+
+	filename := fmt.Sprintf("cali-export-%s.json", exportedAt.Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// Splice the appointments array into the header object.
+	w.Write(header[:len(header)-1])
+	w.Write([]byte(`,"appointments":[`))
+	first := true
+	err = s.db.WalkAppointments(r.Context(), UserID(r.Context()), func(a *models.Appointment) error {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if !first {
+			b = append([]byte{','}, b...)
+		}
+		first = false
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to export data of user %d: %v", UserID(r.Context()), err)
+		return
+	}
+	w.Write([]byte("]}\n"))
+}
+
+// handleImportArchive restores a data archive created by
+// handleExportArchive, possibly on another instance, for the user. The
+// archive may be compressed with gzip or deflate. Appointments get new IDs
+// but keep their slugs; the on_conflict parameter decides what happens to
+// an appointment whose slug is already taken: "skip" (the default) leaves
+// the existing one alone and reports the archived one as skipped, "fail"
+// aborts the import. Nothing is stored if the import fails. With
+// dry_run=true, nothing is stored either and the response previews the
+// import.
+func (s *Server) handleImportArchive(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := s.parseDryRun(w, r)
+	if !ok {
+		return
+	}
+	skipExisting := true
+	switch r.URL.Query().Get("on_conflict") {
+	case "", "skip":
+	case "fail":
+		skipExisting = false
+	default:
+		s.respondError(w, http.StatusBadRequest, "Invalid on_conflict, must be skip or fail")
+		return
+	}
+
+	var ar archive
+	body, err := requestBody(w, r, maxImportSize)
+	if err == nil {
+		err = json.NewDecoder(body).Decode(&ar)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge), errors.Is(err, errBodyTooLarge):
+			s.respondError(w, http.StatusRequestEntityTooLarge, "Archive too large")
+		case errors.Is(err, errUnsupportedEncoding):
+			s.respondError(w, http.StatusUnsupportedMediaType, "Unsupported content encoding")
+		default:
+			s.respondError(w, http.StatusBadRequest, "Invalid archive: "+err.Error())
+		}
+		return
+	}
+	if ar.Version != archiveVersion {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported archive version %d", ar.Version))
+		return
+	}
+
+	for i, a := range ar.Appointments {
+		if a == nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: missing", i+1))
+			return
+		}
+		a.ID = 0
+		a.UserID = UserID(r.Context())
+		if a.Status == "" {
+			a.Status = models.StatusConfirmed
+		}
+		for j := range a.Attendees {
+			if a.Attendees[j].ResponseStatus == "" {
+				a.Attendees[j].ResponseStatus = models.ResponseNeedsAction
+			}
+		}
+		if err := a.Validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: %v", i+1, err))
+			return
+		}
+	}
+
+	restored, err := s.db.RestoreAppointments(r.Context(), ar.Appointments, skipExisting, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrSlugExists):
+			s.respondError(w, http.StatusConflict, "Conflicting appointment: "+err.Error())
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to import data", err)
+			}
+		}
+		return
+	}
+	if !dryRun {
+		s.notify(events.Created, restored...)
+	}
+
+	result := importResult{Imported: len(restored), Errors: []string{}, DryRun: dryRun}
+	var labels []string
+	for i, a := range ar.Appointments {
+		label := fmt.Sprintf("appointment %d (%s)", i+1, a.Slug)
+		if len(labels) < len(restored) && restored[len(labels)] == a {
+			labels = append(labels, label)
+			continue
+		}
+		result.Skipped++
+		result.Errors = append(result.Errors, label+": slug already exists")
+	}
+	if dryRun {
+		conflicts, err := s.importConflicts(r.Context(), restored, labels)
+		if err != nil {
+			s.respondInternalError(w, "Failed to check for conflicts", err)
+			return
+		}
+		result.Appointments = restored
+		result.Conflicts = conflicts
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
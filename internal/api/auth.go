@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/auth"
+	"github.com/miku/cali/internal/models"
+)
+
+// sessionCookie is the name of the cookie carrying the session token of the
+// web interface.
+const sessionCookie = "cali_session"
+
+// errInvalidLogin is returned for an unknown user or a wrong password,
+// which are not told apart, so logins cannot probe for usernames.
+var errInvalidLogin = errors.New("invalid username or password")
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleLogin checks the password of a user and issues an access token,
+// like handleToken. A session cookie is set as well, for browsers.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	s.issueToken(w, r, true)
+}
+
+// handleToken checks the password of a user and issues an access token,
+// without starting a session. Clients send the token as
+// "Authorization: Bearer <token>" on all other API requests.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.issueToken(w, r, false)
+}
+
+// issueToken responds to a login request with an access token, starting a
+// session if requested.
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request, session bool) {
+	var req loginRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Username == "" {
+		s.respondError(w, http.StatusBadRequest, "Missing username")
+		return
+	}
+
+	user, err := s.checkLogin(r.Context(), req.Username, req.Password)
+	if errors.Is(err, errInvalidLogin) {
+		s.respondError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+
+	ttl := s.config.Auth.TokenTTL
+	token, err := auth.Sign(s.secret, user.ID, user.Username, ttl)
+	if err != nil {
+		s.respondInternalError(w, "Failed to issue token", err)
+		return
+	}
+	if session {
+		if err := s.startSession(w, r, user); err != nil {
+			s.respondInternalError(w, "Failed to create session", err)
+			return
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, loginResponse{
+		Token:     token,
+		ExpiresAt: time.Now().Add(ttl).UTC().Truncate(time.Second),
+	})
+}
+
+// handleLogout ends the session of the session cookie, if any, and clears
+// the cookie. Access tokens stay valid until they expire.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.endSession(w, r); err != nil {
+		s.respondInternalError(w, "Failed to end session", err)
+		return
+	}
+	s.respondJSON(w, http.StatusNoContent, nil)
+}
+
+// checkLogin returns the user with the username if the password is theirs,
+// and errInvalidLogin otherwise.
+func (s *Server) checkLogin(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := s.db.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !auth.CheckPassword(user.PasswordHash, password) {
+		return nil, errInvalidLogin
+	}
+	return user, nil
+}
+
+// tokenUser verifies an access token and returns its user. Tokens of users
+// that no longer exist, or were renamed, are invalid.
+func (s *Server) tokenUser(ctx context.Context, token string) (*models.User, error) {
+	claims, err := auth.Verify(s.secret, token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.db.GetUser(ctx, claims.UserID())
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Username != claims.Username {
+		return nil, auth.ErrInvalidToken
+	}
+	return user, nil
+}
+
+// startSession creates a session for the user and sets its cookie.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	token, id, err := auth.NewSession()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(s.config.Auth.SessionTTL)
+	if err := s.db.CreateSession(r.Context(), id, user.ID, expires); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.isHTTPS(r),
+		// Lax keeps other sites from sending requests on behalf of the
+		// user, while links to the calendar still work.
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// endSession ends the session of the request's cookie, if any, and clears
+// the cookie.
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) error {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := s.db.DeleteSession(r.Context(), auth.SessionID(c.Value)); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionUser returns the ID of the user of the request's session cookie,
+// or zero if there is no valid session.
+func (s *Server) sessionUser(r *http.Request) (int64, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return 0, nil
+	}
+	return s.db.GetSessionUser(r.Context(), auth.SessionID(c.Value))
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// handleAvailability returns the free intervals on a given date between from
+// and to that last at least duration. The window defaults to the configured
+// working hours. The date and times are interpreted in the request's
+// timezone (see resolveTimezone). Appointments reaching beyond the window
+// are clipped.
+func (s *Server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	duration, err := time.ParseDuration(q.Get("duration"))
+	if err != nil || duration <= 0 {
+		s.respondError(w, http.StatusBadRequest, "Invalid duration")
+		return
+	}
+	s.respondFree(w, r, q.Get("from"), q.Get("to"), duration)
+}
+
+// handleGaps returns the free intervals on a given date within the
+// configured working hours, including the gaps before the first and after
+// the last appointment. With min_duration, shorter gaps are left out.
+func (s *Server) handleGaps(w http.ResponseWriter, r *http.Request) {
+	var minDuration time.Duration
+	if v := r.URL.Query().Get("min_duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			s.respondError(w, http.StatusBadRequest, "Invalid min_duration")
+			return
+		}
+		minDuration = d
+	}
+	s.respondFree(w, r, "", "", minDuration)
+}
+
+// respondFree writes the free intervals of at least minDuration between the
+// clock times from and to on the date of the request. Empty times default
+// to the configured working hours.
+func (s *Server) respondFree(w http.ResponseWriter, r *http.Request, fromValue, toValue string, minDuration time.Duration) {
+	q := r.URL.Query()
+
+	loc := requestLocation(r.Context())
+
+	date, err := time.ParseInLocation("2006-01-02", q.Get("date"), loc)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid date")
+		return
+	}
+	from, ok := parseClock(date, fromValue, s.config.WorkingHours.Start)
+	if !ok {
+		s.respondError(w, http.StatusBadRequest, "Invalid from time")
+		return
+	}
+	to, ok := parseClock(date, toValue, s.config.WorkingHours.End)
+	if !ok {
+		s.respondError(w, http.StatusBadRequest, "Invalid to time")
+		return
+	}
+	if !to.After(from) {
+		s.respondError(w, http.StatusBadRequest, "To time must be after from time")
+		return
+	}
+
+	appointments, err := s.db.ListOverlappingAppointments(r.Context(), UserID(r.Context()), from, to)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	var busy []schedule.Interval
+	for _, a := range appointments {
+		// All-day and cancelled appointments do not block time.
+		if !a.AllDay && a.Status != models.StatusCancelled {
+			busy = append(busy, schedule.Interval{Start: a.StartTime, End: a.EndTime})
+		}
+	}
+	window := schedule.Interval{Start: from, End: to}
+	s.respondJSON(w, http.StatusOK, schedule.Free(window, busy, minDuration))
+}
+
+// parseClock returns the time of day given as "15:04" on date, using def if
+// value is empty.
+func parseClock(date time.Time, value, def string) (time.Time, bool) {
+	if value == "" {
+		value = def
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(),
+		t.Hour(), t.Minute(), 0, 0, date.Location()), true
+}
+
+const (
+	// defaultSlotDays is how many days slots are searched in without an
+	// end, and maxSlotRange bounds the range searched.
+	defaultSlotDays = 7
+	maxSlotRange    = 31 * 24 * time.Hour
+
+	defaultSlotLimit = 10
+	maxSlotLimit     = 100
+)
+
+// handleSlots suggests times for an appointment of the given duration
+// between start and end (RFC 3339), by default the next seven days. Slots
+// lie within the working hours of each working day in the request's
+// timezone (see resolveTimezone) and the booking hours, if configured, keep
+// a buffer to other appointments, and start at multiples of the slot step.
+// The buffer and step default to the configuration and may be given as
+// parameters. The best limit slots are returned, ranked by schedule.Slots.
+// Times in the past are never suggested.
+func (s *Server) handleSlots(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	duration, err := time.ParseDuration(q.Get("duration"))
+	if err != nil || duration <= 0 {
+		s.respondError(w, http.StatusBadRequest, "Invalid duration")
+		return
+	}
+	buffer := s.config.WorkingHours.Buffer
+	if v := q.Get("buffer"); v != "" {
+		buffer, err = time.ParseDuration(v)
+		if err != nil || buffer < 0 {
+			s.respondError(w, http.StatusBadRequest, "Invalid buffer")
+			return
+		}
+	}
+	step := s.config.WorkingHours.SlotStep
+	if v := q.Get("step"); v != "" {
+		step, err = time.ParseDuration(v)
+		if err != nil || step < schedule.MinSlotStep {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid step, must be at least %v", schedule.MinSlotStep))
+			return
+		}
+	}
+	limit := defaultSlotLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSlotLimit {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit, must be 1 to %d", maxSlotLimit))
+			return
+		}
+	}
+
+	loc := requestLocation(r.Context())
+	now := time.Now().In(loc)
+	start, err := parseTimeParam(r, "start", now)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid start time")
+		return
+	}
+	end, err := parseTimeParam(r, "end", start.AddDate(0, 0, defaultSlotDays))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid end time")
+		return
+	}
+	if !end.After(start) {
+		s.respondError(w, http.StatusBadRequest, "End time must be after start time")
+		return
+	}
+	if end.Sub(start) > maxSlotRange {
+		s.respondError(w, http.StatusBadRequest, "Range must not exceed 31 days")
+		return
+	}
+	if start.Before(now) {
+		start = now
+	}
+
+	appointments, err := s.db.ListOverlappingAppointments(r.Context(), UserID(r.Context()), start.Add(-buffer), end.Add(buffer))
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+	var busy []schedule.Interval
+	for _, a := range appointments {
+		// All-day and cancelled appointments do not block time.
+		if !a.AllDay && a.Status != models.StatusCancelled {
+			// In loc, as slots are aligned to its midnight.
+			busy = append(busy, schedule.Interval{Start: a.StartTime.Add(-buffer).In(loc), End: a.EndTime.Add(buffer).In(loc)})
+		}
+	}
+
+	hours := workingHours(s.config, loc)
+	var free []schedule.Interval
+	y, m, d := start.In(loc).Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+		if hours.CheckDates(day, day) != nil {
+			continue
+		}
+		// Validated when the configuration is loaded.
+		from, _ := parseClock(day, "", s.config.WorkingHours.Start)
+		to, _ := parseClock(day, "", s.config.WorkingHours.End)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			free = append(free, schedule.Free(schedule.Interval{Start: from, End: to}, busy, duration)...)
+		}
+	}
+
+	slots := []schedule.Interval{}
+	for _, slot := range schedule.Slots(free, duration, step) {
+		if len(slots) == limit {
+			break
+		}
+		if s.booking != nil && s.booking.Check(slot.Start, slot.End) != nil {
+			continue
+		}
+		slots = append(slots, slot)
+	}
+	s.respondJSON(w, http.StatusOK, slots)
+}
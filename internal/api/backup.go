@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// backupVersion is the version of the backup format, increased on
+// incompatible changes.
+const backupVersion = 1
+
+// backup holds all appointments of a user as stored, including deleted
+// ones, for restoring them on the same instance. Unlike an archive, IDs are
+// kept. An incremental backup holds only the appointments updated since
+// UpdatedSince.
+type backup struct {
+	Version      int                   `json:"version"`
+	ExportedAt   time.Time             `json:"exported_at"`
+	UpdatedSince *time.Time            `json:"updated_since,omitempty"`
+	Appointments []*models.Appointment `json:"appointments"`
+}
+
+// restoreResult is the response to a restored backup.
+type restoreResult struct {
+	Inserted int `json:"inserted"`
+	Replaced int `json:"replaced"`
+}
+
+// handleExportBackup streams all appointments of the user, including
+// deleted ones, as a backup document, which handleImportBackup restores.
+// Appointments are ordered by the time of their last update. With
+// ?updated_since=, only appointments updated at or after that time are
+// included, so passing the exported_at of the previous backup fetches the
+// changes since, deletions included. Appointments removed by merging are
+// gone for good and missing from incremental backups.
+// A failure midway leaves the document truncated, which the import rejects
+// as invalid JSON.
+func (s *Server) handleExportBackup(w http.ResponseWriter, r *http.Request) {
+	since, err := parseTimeParam(r, "updated_since", time.Time{})
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid updated_since time")
+		return
+	}
+	var updatedSince *time.Time
+	if !since.IsZero() {
+		updatedSince = &since
+	}
+	// Taken before reading, so appointments updated while exporting are
+	// included in the next incremental backup.
+	exportedAt := time.Now().UTC()
+	header, err := json.Marshal(struct {
+		Version      int        `json:"version"`
+		ExportedAt   time.Time  `json:"exported_at"`
+		UpdatedSince *time.Time `json:"updated_since,omitempty"`
+	}{backupVersion, exportedAt, updatedSince})
+	if err != nil {
+		s.respondInternalError(w, "Failed to export appointments", err)
+		return
+	}
+
+	filename := fmt.Sprintf("cali-backup-%s.json", exportedAt.Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	// Splice the appointments array into the header object.
+	w.Write(header[:len(header)-1])
+	w.Write([]byte(`,"appointments":[`))
+	enc := json.NewEncoder(w)
+	first := true
+	err = s.db.WalkChangedAppointments(r.Context(), UserID(r.Context()), since, func(a *models.Appointment) error {
+		if !first {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(a)
+	})
+	if err != nil {
+		log.Printf("Failed to export appointments of user %d: %v", UserID(r.Context()), err)
+		return
+	}
+	w.Write([]byte("]}\n"))
+}
+
+// handleImportBackup restores a backup created by handleExportBackup in a
+// single transaction. The body may be compressed with gzip or deflate.
+// Appointments keep their IDs, slugs and timestamps: existing ones are
+// replaced, others inserted. Nothing is stored if an appointment is
+// invalid, or its ID or slug is taken by another appointment.
+func (s *Server) handleImportBackup(w http.ResponseWriter, r *http.Request) {
+	var b backup
+	body, err := requestBody(w, r, maxImportSize)
+	if err == nil {
+		err = json.NewDecoder(body).Decode(&b)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge), errors.Is(err, errBodyTooLarge):
+			s.respondError(w, http.StatusRequestEntityTooLarge, "Backup too large")
+		case errors.Is(err, errUnsupportedEncoding):
+			s.respondError(w, http.StatusUnsupportedMediaType, "Unsupported content encoding")
+		default:
+			s.respondError(w, http.StatusBadRequest, "Invalid backup: "+err.Error())
+		}
+		return
+	}
+	if b.Version != backupVersion {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported backup version %d", b.Version))
+		return
+	}
+
+	for i, a := range b.Appointments {
+		if a == nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: missing", i+1))
+			return
+		}
+		if a.Status == "" {
+			a.Status = models.StatusConfirmed
+		}
+		if err := a.Validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: %v", i+1, err))
+			return
+		}
+	}
+
+	inserted, replaced, err := s.db.RestoreBackup(r.Context(), UserID(r.Context()), b.Appointments)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrForeignAppointment), errors.Is(err, db.ErrSlugExists):
+			s.respondError(w, http.StatusConflict, "Conflicting appointment: "+err.Error())
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to restore backup", err)
+			}
+		}
+		return
+	}
+	var created []*models.Appointment
+	for _, a := range inserted {
+		if a.DeletedAt == nil {
+			created = append(created, a)
+		}
+	}
+	s.notify(events.Created, created...)
+	s.notify(events.Updated, replaced...)
+
+	s.respondJSON(w, http.StatusOK, restoreResult{Inserted: len(inserted), Replaced: len(replaced)})
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// maxBatchSize caps the number of appointments created in one request.
+const maxBatchSize = 500
+
+// handleCreateAppointments creates a JSON array of appointments at once. The
+// batch is all or nothing: if any appointment is invalid, conflicts with an
+// existing appointment or one earlier in the batch, nothing is stored and the
+// error names the offending appointment by its position, starting at 1. With
+// force=true, conflicts are allowed. The created appointments are returned
+// in request order.
+func (s *Server) handleCreateAppointments(w http.ResponseWriter, r *http.Request) {
+	force, ok := s.parseForce(w, r)
+	if !ok {
+		return
+	}
+	var reqs []createAppointmentRequest
+	if !s.decodeJSON(w, r, &reqs) {
+		return
+	}
+	if len(reqs) == 0 {
+		s.respondError(w, http.StatusBadRequest, "No appointments given")
+		return
+	}
+	if len(reqs) > maxBatchSize {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d appointments can be created at once", maxBatchSize))
+		return
+	}
+
+	tz, err := s.defaultTimezone(r.Context())
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+
+	appointments := make([]*models.Appointment, len(reqs))
+	for i := range reqs {
+		req := &reqs[i]
+		appt := &models.Appointment{
+			UserID:           UserID(r.Context()),
+			Title:            req.Title,
+			Slug:             req.Slug,
+			Description:      req.Description,
+			Place:            req.Location,
+			ConferenceURL:    req.ConferenceURL,
+			Category:         req.Category,
+			Color:            req.Color,
+			Timezone:         req.timezone(tz),
+			Recurrence:       req.Recurrence,
+			Status:           req.status(),
+			Attendees:        req.attendees(),
+			ReminderChannels: req.ReminderChannels,
+		}
+		if err := req.setTimes(appt); err != nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: %v", i+1, err))
+			return
+		}
+		s.normalizeTitle(appt)
+		if err := appt.Validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Appointment %d: %v", i+1, err))
+			return
+		}
+		if err := s.reminderChannelError(appt); err != nil {
+			s.respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Appointment %d: %v", i+1, err))
+			return
+		}
+		if err := s.bookingError(appt); err != nil {
+			s.respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Appointment %d: outside booking hours: %v", i+1, err))
+			return
+		}
+		appointments[i] = appt
+	}
+
+	// Existing appointments are checked when storing the batch; earlier
+	// ones in the batch block time like them, unless they are cancelled.
+	for i, appt := range appointments {
+		if force || appt.AllDay || appt.Status == models.StatusCancelled {
+			continue
+		}
+		start, end := s.conflictRange(appt)
+		for _, other := range appointments[:i] {
+			if !other.AllDay && other.Status != models.StatusCancelled &&
+				start.Before(other.EndTime) && other.StartTime.Before(end) {
+				s.respondError(w, http.StatusConflict, fmt.Sprintf("Appointment %d: conflicts with appointment %q", i+1, other.Title))
+				return
+			}
+		}
+	}
+
+	if err := s.db.CreateAppointments(r.Context(), appointments, force); err != nil {
+		var conflict *db.ConflictError
+		if errors.As(err, &conflict) {
+			for i, appt := range appointments {
+				if appt == conflict.Appointment {
+					s.respondConflict(w, conflict, i+1)
+					return
+				}
+			}
+		}
+		if s.respondDuplicate(w, err) {
+			return
+		}
+		s.respondInternalError(w, "Failed to create appointments", err)
+		return
+	}
+	s.notify(events.Created, appointments...)
+
+	s.respondJSON(w, http.StatusCreated, localize(r, appointments))
+}
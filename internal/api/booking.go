@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/config"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// maxBookingChecks caps the number of occurrences of a recurring
+// appointment checked against the booking hours.
+const maxBookingChecks = 500
+
+// newBookingHours returns the booking hours configured, or nil if bookings
+// are not restricted. The configuration has been validated.
+func newBookingHours(cfg *config.Config) *schedule.BookingHours {
+	b := cfg.Booking
+	if b.Open == "" && len(b.DaysOfWeek) == 0 {
+		return nil
+	}
+	loc, _ := time.LoadLocation(b.Timezone)
+	hours := &schedule.BookingHours{Location: loc}
+	if b.Open != "" {
+		open, _ := time.Parse("15:04", b.Open)
+		closing, _ := time.Parse("15:04", b.Close)
+		hours.Open = open.Hour()*60 + open.Minute()
+		hours.Close = closing.Hour()*60 + closing.Minute()
+	}
+	for _, d := range b.DaysOfWeek {
+		wd, _ := schedule.ParseWeekday(d)
+		hours.Days = append(hours.Days, wd)
+	}
+	return hours
+}
+
+// checkBooking responds with 422 Unprocessable Entity and returns false if
+// appt lies outside the booking hours.
+func (s *Server) checkBooking(w http.ResponseWriter, appt *models.Appointment) bool {
+	if err := s.bookingError(appt); err != nil {
+		s.respondError(w, http.StatusUnprocessableEntity, "Outside booking hours: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// bookingError describes why appt lies outside the booking hours, or
+// returns nil. Of a recurring appointment, the first maxBookingChecks
+// occurrences are checked.
+func (s *Server) bookingError(appt *models.Appointment) error {
+	if s.booking == nil {
+		return nil
+	}
+	occurrences, err := models.NextOccurrences(appt, appt.StartTime, maxBookingChecks)
+	if err != nil {
+		return err
+	}
+	for _, o := range occurrences {
+		if o.AllDay {
+			loc := o.Location()
+			err = s.booking.CheckDates(o.StartTime.In(loc), o.EndTime.In(loc))
+		} else {
+			err = s.booking.Check(o.StartTime, o.EndTime)
+		}
+		if err != nil && appt.Recurrence != "" {
+			return fmt.Errorf("occurrence on %s %w", o.StartTime.In(s.booking.Location).Format("Jan 2, 2006"), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,632 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miku/cali/internal/auth"
+	"github.com/miku/cali/internal/caldav"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/ical"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/recurrence"
+)
+
+// The CalDAV tree is
+//
+//	/dav/                                    root
+//	/dav/principals/{user}/                  principal of the user
+//	/dav/calendars/{user}/                   calendar home of the user
+//	/dav/calendars/{user}/default/           the user's calendar
+//	/dav/calendars/{user}/default/{uid}.ics  an appointment
+//
+// where {uid} is the iCalendar UID of the appointment.
+const (
+	davPrefix       = "/dav/"
+	davCalendarName = "default"
+	// davMethods are the methods supported on the CalDAV tree.
+	davMethods = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+)
+
+// Properties served on the CalDAV tree.
+var (
+	propResourceType         = xml.Name{Space: caldav.NSDAV, Local: "resourcetype"}
+	propDisplayName          = xml.Name{Space: caldav.NSDAV, Local: "displayname"}
+	propCurrentUserPrincipal = xml.Name{Space: caldav.NSDAV, Local: "current-user-principal"}
+	propPrincipalURL         = xml.Name{Space: caldav.NSDAV, Local: "principal-URL"}
+	propOwner                = xml.Name{Space: caldav.NSDAV, Local: "owner"}
+	propPrivilegeSet         = xml.Name{Space: caldav.NSDAV, Local: "current-user-privilege-set"}
+	propSupportedReportSet   = xml.Name{Space: caldav.NSDAV, Local: "supported-report-set"}
+	propGetETag              = xml.Name{Space: caldav.NSDAV, Local: "getetag"}
+	propGetContentType       = xml.Name{Space: caldav.NSDAV, Local: "getcontenttype"}
+	propCalendarHomeSet      = xml.Name{Space: caldav.NSCalDAV, Local: "calendar-home-set"}
+	propComponentSet         = xml.Name{Space: caldav.NSCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData         = xml.Name{Space: caldav.NSCalDAV, Local: "calendar-data"}
+	propGetCTag              = xml.Name{Space: caldav.NSCalendarServer, Local: "getctag"}
+)
+
+// calendarContentType is the media type of calendar resources.
+const calendarContentType = "text/calendar; charset=utf-8; component=VEVENT"
+
+// davLoginTTL is how long a password checked by authenticateDAV is
+// remembered, so clients syncing with it do not pay for the password hash
+// on every request.
+const davLoginTTL = 5 * time.Minute
+
+// davLogin is a remembered password check. Hash is the user's password
+// hash at the time, so changing the password forgets the check.
+type davLogin struct {
+	hash    string
+	expires time.Time
+}
+
+// authenticateDAV is like authenticate, but also accepts HTTP Basic
+// authentication, which is what native calendar clients support. The
+// password is the user's account password, which clients can store, or an
+// API token issued by /api/auth/token to the user.
+func (s *Server) authenticateDAV(next http.Handler) http.Handler {
+	bearer := s.authenticate(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " "); strings.EqualFold(scheme, "Bearer") {
+			bearer.ServeHTTP(w, r)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cali"`)
+			s.respondError(w, http.StatusUnauthorized, "Missing credentials")
+			return
+		}
+		user, err := s.davUser(r.Context(), username, password)
+		if errors.Is(err, errInvalidLogin) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cali"`)
+			s.respondError(w, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+		if err != nil {
+			s.respondInternalError(w, "Failed to get user", err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, user.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// davUser returns the user with the username if password is an access
+// token of theirs or their password, and errInvalidLogin otherwise.
+func (s *Server) davUser(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := s.tokenUser(ctx, password)
+	switch {
+	case err == nil && user.Username == username:
+		return user, nil
+	case err != nil && !errors.Is(err, auth.ErrInvalidToken) && !errors.Is(err, auth.ErrExpiredToken):
+		return nil, err
+	}
+
+	user, err = s.db.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.PasswordHash == "" {
+		return nil, errInvalidLogin
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	now := time.Now()
+	s.davMu.Lock()
+	login, ok := s.davLogins[key]
+	s.davMu.Unlock()
+	if ok && login.hash == user.PasswordHash && now.Before(login.expires) {
+		return user, nil
+	}
+	if !auth.CheckPassword(user.PasswordHash, password) {
+		return nil, errInvalidLogin
+	}
+
+	s.davMu.Lock()
+	defer s.davMu.Unlock()
+	for k, l := range s.davLogins {
+		if !now.Before(l.expires) {
+			delete(s.davLogins, k)
+		}
+	}
+	s.davLogins[key] = davLogin{hash: user.PasswordHash, expires: now.Add(davLoginTTL)}
+	return user, nil
+}
+
+// handleWellKnownCalDAV points clients discovering the CalDAV service
+// (RFC 6764) to its root.
+func (s *Server) handleWellKnownCalDAV(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, davPrefix, http.StatusMovedPermanently)
+}
+
+// davPaths are the paths of the CalDAV resources of a user.
+type davPaths struct {
+	principal, home, calendar string
+}
+
+func newDAVPaths(username string) davPaths {
+	user := url.PathEscape(username)
+	home := davPrefix + "calendars/" + user + "/"
+	return davPaths{
+		principal: davPrefix + "principals/" + user + "/",
+		home:      home,
+		calendar:  home + davCalendarName + "/",
+	}
+}
+
+// object returns the path of an appointment.
+func (p davPaths) object(a *models.Appointment) string {
+	return p.calendar + url.PathEscape(a.ICalUID()) + ".ics"
+}
+
+// handleDAV serves the CalDAV tree of the authenticated user, so native
+// clients can sync appointments both ways. Appointments are created,
+// replaced and deleted with PUT and DELETE of iCalendar resources, with the
+// same checks as in the JSON API.
+func (s *Server) handleDAV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, calendar-access")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", davMethods)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, err := s.db.GetUser(r.Context(), UserID(r.Context()))
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+	if user == nil {
+		s.respondError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	paths := newDAVPaths(user.Username)
+
+	var segments []string
+	for _, seg := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), davPrefix), "/"), "/") {
+		v, err := url.PathUnescape(seg)
+		if err != nil {
+			s.respondError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		segments = append(segments, v)
+	}
+	// Users only see their own resources.
+	if len(segments) >= 2 && segments[1] != user.Username {
+		s.respondError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && segments[0] == "":
+		s.davCollection(w, r, davRoot(paths), nil)
+	case len(segments) == 2 && segments[0] == "principals":
+		s.davCollection(w, r, davPrincipal(paths, user), nil)
+	case len(segments) == 2 && segments[0] == "calendars":
+		s.davCollection(w, r, davHome(paths, user), func() ([]caldavResource, error) {
+			cal, err := s.davCalendar(r.Context(), paths, user)
+			return []caldavResource{cal}, err
+		})
+	case len(segments) == 3 && segments[0] == "calendars" && segments[2] == davCalendarName:
+		if r.Method == "REPORT" {
+			s.davReport(w, r, paths)
+			return
+		}
+		cal, err := s.davCalendar(r.Context(), paths, user)
+		if err != nil {
+			s.respondInternalError(w, "Failed to get calendar", err)
+			return
+		}
+		s.davCollection(w, r, cal, func() ([]caldavResource, error) {
+			var objects []caldavResource
+			err := s.db.WalkAppointments(r.Context(), user.ID, func(a *models.Appointment) error {
+				objects = append(objects, davObject(paths, a))
+				return nil
+			})
+			return objects, err
+		})
+	case len(segments) == 4 && segments[0] == "calendars" && segments[2] == davCalendarName &&
+		strings.HasSuffix(segments[3], ".ics"):
+		s.davResource(w, r, paths, strings.TrimSuffix(segments[3], ".ics"))
+	default:
+		s.respondError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// caldavResource is a resource with its properties. Getting calendar data
+// is deferred, as it is only needed if asked for.
+type caldavResource struct {
+	href  string
+	props map[xml.Name]string
+	appt  *models.Appointment
+}
+
+func davRoot(p davPaths) caldavResource {
+	return caldavResource{href: davPrefix, props: map[xml.Name]string{
+		propResourceType:         "<d:collection/>",
+		propCurrentUserPrincipal: caldav.Href(p.principal),
+	}}
+}
+
+func davPrincipal(p davPaths, user *models.User) caldavResource {
+	return caldavResource{href: p.principal, props: map[xml.Name]string{
+		propResourceType:         "<d:principal/>",
+		propDisplayName:          caldav.Text(user.Username),
+		propCurrentUserPrincipal: caldav.Href(p.principal),
+		propPrincipalURL:         caldav.Href(p.principal),
+		propCalendarHomeSet:      caldav.Href(p.home),
+	}}
+}
+
+func davHome(p davPaths, user *models.User) caldavResource {
+	return caldavResource{href: p.home, props: map[xml.Name]string{
+		propResourceType:         "<d:collection/>",
+		propDisplayName:          caldav.Text(user.Username),
+		propCurrentUserPrincipal: caldav.Href(p.principal),
+		propOwner:                caldav.Href(p.principal),
+	}}
+}
+
+// davCalendar returns the calendar collection, whose tag changes with
+// every change to the user's appointments.
+func (s *Server) davCalendar(ctx context.Context, p davPaths, user *models.User) (caldavResource, error) {
+	tag, err := s.db.CollectionTag(ctx, user.ID)
+	if err != nil {
+		return caldavResource{}, err
+	}
+	return caldavResource{href: p.calendar, props: map[xml.Name]string{
+		propResourceType:         "<d:collection/><c:calendar/>",
+		propDisplayName:          "Appointments",
+		propCurrentUserPrincipal: caldav.Href(p.principal),
+		propOwner:                caldav.Href(p.principal),
+		propPrivilegeSet: "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>",
+		propSupportedReportSet: "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>",
+		propComponentSet: `<c:comp name="VEVENT"/>`,
+		propGetCTag:      caldav.Text(`"` + tag + `"`),
+	}}, nil
+}
+
+func davObject(p davPaths, a *models.Appointment) caldavResource {
+	return caldavResource{href: p.object(a), appt: a, props: map[xml.Name]string{
+		propResourceType:   "",
+		propGetETag:        caldav.Text(etag(a)),
+		propGetContentType: calendarContentType,
+	}}
+}
+
+// response returns the requested properties of res. Calendar data is not
+// among all properties, as required by RFC 4791.
+func (res caldavResource) response(req *caldav.Request) (caldav.Response, error) {
+	resp := caldav.Response{Href: res.href}
+	if req.AllProp {
+		names := make([]xml.Name, 0, len(res.props))
+		for name := range res.props {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i].Local < names[j].Local })
+		for _, name := range names {
+			resp.Props = append(resp.Props, caldav.Prop{Name: name, Value: res.props[name]})
+		}
+		return resp, nil
+	}
+	for _, name := range req.Props {
+		if value, ok := res.props[name]; ok {
+			resp.Props = append(resp.Props, caldav.Prop{Name: name, Value: value})
+			continue
+		}
+		if name == propCalendarData && res.appt != nil {
+			data, err := calendarData(res.appt)
+			if err != nil {
+				return resp, err
+			}
+			resp.Props = append(resp.Props, caldav.Prop{Name: name, Value: caldav.Text(string(data))})
+			continue
+		}
+		resp.NotFound = append(resp.NotFound, name)
+	}
+	return resp, nil
+}
+
+// calendarData returns an appointment as iCalendar resource.
+func calendarData(a *models.Appointment) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := ical.NewEncoder(&buf)
+	enc.Method = ""
+	if err := enc.Encode(a); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// davCollection answers a PROPFIND of a collection. With depth 1, the
+// members returned by children are included.
+func (s *Server) davCollection(w http.ResponseWriter, r *http.Request, res caldavResource, children func() ([]caldavResource, error)) {
+	if r.Method != "PROPFIND" {
+		w.Header().Set("Allow", davMethods)
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	req, err := caldav.ParseRequest(http.MaxBytesReader(w, r.Body, s.config.Server.MaxBodySize))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resources := []caldavResource{res}
+	// Depth infinity, the default, is treated as 1.
+	if r.Header.Get("Depth") != "0" && children != nil {
+		members, err := children()
+		if err != nil {
+			s.respondInternalError(w, "Failed to list resources", err)
+			return
+		}
+		resources = append(resources, members...)
+	}
+	s.respondMultistatus(w, req, resources)
+}
+
+// respondMultistatus responds with the requested properties of resources.
+func (s *Server) respondMultistatus(w http.ResponseWriter, req *caldav.Request, resources []caldavResource) {
+	responses := make([]caldav.Response, 0, len(resources))
+	for _, res := range resources {
+		resp, err := res.response(req)
+		if err != nil {
+			s.respondInternalError(w, "Failed to encode appointment", err)
+			return
+		}
+		responses = append(responses, resp)
+	}
+	caldav.WriteMultistatus(w, responses)
+}
+
+// davReport answers a calendar-query, optionally filtered by time range,
+// or a calendar-multiget of the calendar.
+func (s *Server) davReport(w http.ResponseWriter, r *http.Request, paths davPaths) {
+	req, err := caldav.ParseRequest(http.MaxBytesReader(w, r.Body, s.config.Server.MaxBodySize))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID := UserID(r.Context())
+
+	switch req.Root {
+	case xml.Name{Space: caldav.NSCalDAV, Local: "calendar-query"}:
+		var resources []caldavResource
+		err := s.db.WalkAppointments(r.Context(), userID, func(a *models.Appointment) error {
+			ok, err := occursBetween(a, req.Start, req.End)
+			if ok {
+				resources = append(resources, davObject(paths, a))
+			}
+			return err
+		})
+		if err != nil {
+			s.respondInternalError(w, "Failed to list appointments", err)
+			return
+		}
+		s.respondMultistatus(w, req, resources)
+	case xml.Name{Space: caldav.NSCalDAV, Local: "calendar-multiget"}:
+		var (
+			resources []caldavResource
+			missing   []caldav.Response
+		)
+		for _, href := range req.Hrefs {
+			var appt *models.Appointment
+			if u, err := url.Parse(href); err == nil && strings.HasPrefix(u.EscapedPath(), paths.calendar) {
+				name := strings.TrimPrefix(u.EscapedPath(), paths.calendar)
+				if uid, err := url.PathUnescape(strings.TrimSuffix(name, ".ics")); err == nil && strings.HasSuffix(name, ".ics") {
+					if appt, err = s.db.GetAppointmentByUID(r.Context(), userID, uid); err != nil {
+						s.respondInternalError(w, "Failed to get appointment", err)
+						return
+					}
+				}
+			}
+			if appt == nil {
+				missing = append(missing, caldav.Response{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			resources = append(resources, davObject(paths, appt))
+		}
+		responses := make([]caldav.Response, 0, len(resources)+len(missing))
+		for _, res := range resources {
+			resp, err := res.response(req)
+			if err != nil {
+				s.respondInternalError(w, "Failed to encode appointment", err)
+				return
+			}
+			responses = append(responses, resp)
+		}
+		caldav.WriteMultistatus(w, append(responses, missing...))
+	default:
+		s.respondError(w, http.StatusForbidden, "Unsupported report")
+	}
+}
+
+// occursBetween reports whether an occurrence of a overlaps [start, end).
+// Zero bounds are open.
+func occursBetween(a *models.Appointment, start, end time.Time) (bool, error) {
+	if start.IsZero() && end.IsZero() {
+		return true, nil
+	}
+	if end.IsZero() {
+		end = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	// ExpandRecurrences yields occurrences lying entirely within the
+	// range, so widen it by the duration to find overlapping ones.
+	duration := a.EndTime.Sub(a.StartTime)
+	occurrences, err := models.ExpandRecurrences(a, start.Add(-duration), end.Add(duration))
+	if err != nil {
+		return false, err
+	}
+	for _, o := range occurrences {
+		if o.StartTime.Before(end) && (o.EndTime.After(start) || o.EndTime.Equal(o.StartTime) && !o.StartTime.Before(start)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// davResource serves a single appointment as iCalendar resource.
+func (s *Server) davResource(w http.ResponseWriter, r *http.Request, paths davPaths, uid string) {
+	userID := UserID(r.Context())
+	appt, err := s.db.GetAppointmentByUID(r.Context(), userID, uid)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+
+	// Preconditions of writing requests, against the entity tag of the
+	// appointment.
+	version, ok := ifMatch(r)
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		switch {
+		case r.Header.Get("If-None-Match") == "*" && appt != nil,
+			r.Header.Get("If-Match") == "*" && appt == nil,
+			!ok,
+			version != 0 && (appt == nil || version != appt.Version):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if appt == nil {
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+			return
+		}
+		data, err := calendarData(appt)
+		if err != nil {
+			s.respondInternalError(w, "Failed to encode appointment", err)
+			return
+		}
+		w.Header().Set("Content-Type", calendarContentType)
+		w.Header().Set("ETag", etag(appt))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case "PROPFIND":
+		if appt == nil {
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+			return
+		}
+		s.davCollection(w, r, davObject(paths, appt), nil)
+	case http.MethodPut:
+		s.davPut(w, r, uid, appt)
+	case http.MethodDelete:
+		if appt == nil {
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+			return
+		}
+		if err := s.db.DeleteAppointment(r.Context(), appt.ID, userID, version); err != nil {
+			switch {
+			case errors.Is(err, db.ErrStaleAppointment):
+				s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+			case errors.Is(err, db.ErrAppointmentNotFound):
+				s.respondError(w, http.StatusNotFound, "Appointment not found")
+			default:
+				s.respondInternalError(w, "Failed to delete appointment", err)
+			}
+			return
+		}
+		s.notify(events.Deleted, appt)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", davMethods)
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// davPut creates or replaces the appointment with the UID from the
+// iCalendar resource in the request body. Existing is the stored
+// appointment, if any. Modified occurrences of a series, which are sent as
+// further events with the UID, are not supported.
+func (s *Server) davPut(w http.ResponseWriter, r *http.Request, uid string, existing *models.Appointment) {
+	body, err := requestBody(w, r, maxImportSize)
+	if err != nil {
+		s.respondImportError(w, err)
+		return
+	}
+	vevents, err := ical.Decode(body, requestLocation(r.Context()))
+	if err != nil {
+		s.respondImportError(w, err)
+		return
+	}
+	switch {
+	case len(vevents) == 0:
+		s.respondError(w, http.StatusBadRequest, "Invalid calendar: no VEVENT found")
+		return
+	case len(vevents) > 1 || vevents[0].Override:
+		s.respondError(w, http.StatusBadRequest, "Modified occurrences of recurring events are not supported")
+		return
+	case vevents[0].Err != nil:
+		s.respondError(w, http.StatusBadRequest, "Invalid calendar: "+vevents[0].Err.Error())
+		return
+	case vevents[0].UID != uid:
+		s.respondError(w, http.StatusBadRequest, "UID of the event must match the resource name")
+		return
+	}
+
+	appt := importAppointment(UserID(r.Context()), vevents[0])
+	s.normalizeTitle(appt)
+	if existing == nil {
+		appt.UID = uid
+	} else {
+		// Keep what iCalendar cannot express.
+		appt.ID, appt.UID, appt.Color = existing.ID, existing.UID, existing.Color
+		appt.Version = existing.Version
+		appt.ReminderChannels = existing.ReminderChannels
+	}
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.checkBooking(w, appt) {
+		return
+	}
+
+	status, typ := http.StatusCreated, events.Created
+	if existing == nil {
+		err = s.db.CreateAppointment(r.Context(), appt, false)
+	} else {
+		status, typ = http.StatusNoContent, events.Updated
+		err = s.db.UpdateAppointment(r.Context(), appt, false)
+		if err == nil && recurrence.FormatDates(appt.ExDates) != recurrence.FormatDates(existing.ExDates) {
+			appt, err = s.db.UpdateSeries(r.Context(), appt)
+		}
+	}
+	if err != nil {
+		var conflict *db.ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			s.respondConflict(w, conflict, 0)
+		case errors.Is(err, db.ErrStaleAppointment):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to store appointment", err)
+			}
+		}
+		return
+	}
+	s.notify(typ, appt)
+	w.Header().Set("ETag", etag(appt))
+	w.WriteHeader(status)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// calendarDay is a cell of a month grid. InMonth is false for days of the
+// adjacent months that fill the first and last week.
+type calendarDay struct {
+	Date         string                `json:"date"`
+	InMonth      bool                  `json:"in_month"`
+	Appointments []*models.Appointment `json:"appointments"`
+}
+
+type calendarResponse struct {
+	Year  int             `json:"year"`
+	Month int             `json:"month"`
+	Weeks [][]calendarDay `json:"weeks"`
+}
+
+// handleCalendar returns the appointments of a month arranged as a grid of
+// weeks and days, ready to be rendered as a month view. Appointments are
+// placed on the day they start in the request's timezone (see
+// resolveTimezone). Without year and month, the current month is used.
+func (s *Server) handleCalendar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	loc := requestLocation(r.Context())
+
+	now := time.Now().In(loc)
+	year, month := now.Year(), now.Month()
+	if v := q.Get("year"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 9999 {
+			s.respondError(w, http.StatusBadRequest, "Invalid year")
+			return
+		}
+		year = n
+	}
+	if v := q.Get("month"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 12 {
+			s.respondError(w, http.StatusBadRequest, "Invalid month")
+			return
+		}
+		month = time.Month(n)
+	}
+
+	// Validated when the configuration is loaded.
+	firstDay, _ := schedule.ParseWeekday(s.config.Calendar.FirstDayOfWeek)
+	grid := schedule.MonthGrid(year, month, loc, firstDay)
+	start := grid[0][0]
+	end := grid[len(grid)-1][6].AddDate(0, 0, 1)
+
+	appointments, err := s.db.ListOverlappingAppointments(r.Context(), UserID(r.Context()), start, end)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	const layout = "2006-01-02"
+	days := make(map[string]*calendarDay)
+	resp := calendarResponse{Year: year, Month: int(month)}
+	for _, week := range grid {
+		row := make([]calendarDay, len(week))
+		for i, d := range week {
+			row[i] = calendarDay{
+				Date:         d.Format(layout),
+				InMonth:      d.Month() == month,
+				Appointments: []*models.Appointment{},
+			}
+		}
+		resp.Weeks = append(resp.Weeks, row)
+	}
+	for _, week := range resp.Weeks {
+		for i := range week {
+			days[week[i].Date] = &week[i]
+		}
+	}
+	for _, a := range localize(r, appointments) {
+		if d, ok := days[appointmentDay(a, loc).Format(layout)]; ok {
+			d.Appointments = append(d.Appointments, a)
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, resp)
+}
+
+// appointmentDay returns the start of a in loc, by which it is placed on a
+// day of a calendar. All-day appointments fall on their own date.
+func appointmentDay(a *models.Appointment, loc *time.Location) time.Time {
+	if a.AllDay {
+		return a.StartTime.In(a.Location())
+	}
+	return a.StartTime.In(loc)
+}
@@ -0,0 +1,14 @@
+package api
+
+import "net/http"
+
+// handleListCategories returns the distinct categories of the user's
+// appointments, sorted, for building a legend.
+func (s *Server) handleListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.db.ListCategories(r.Context(), UserID(r.Context()))
+	if err != nil {
+		s.respondInternalError(w, "Failed to list categories", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, categories)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// changeHistory is the number of recent changes kept for clients polling
+// for changes.
+const changeHistory = 1000
+
+// changesResponse lists changes along with the token to poll for
+// subsequent ones.
+type changesResponse struct {
+	Changes []events.Change `json:"changes"`
+	Token   string          `json:"token"`
+}
+
+// notify tells clients polling for changes or following the stream,
+// webhook receivers and, if they opted in, the owner by email about changed
+// appointments, which belong to the same user. Appointments updated to
+// cancelled are emailed about as cancellations.
+func (s *Server) notify(typ events.Type, appts ...*models.Appointment) {
+	if len(appts) == 0 {
+		return
+	}
+	s.sendWebhooks("appointment."+string(typ), appts...)
+	s.changes.PublishAppointments(appts[0].UserID, typ, appts...)
+
+	switch typ {
+	case events.Created:
+		s.emailUser(models.EmailCreated, appts...)
+	case events.Updated:
+		var updated, cancelled []*models.Appointment
+		for _, a := range appts {
+			if a.Status == models.StatusCancelled {
+				cancelled = append(cancelled, a)
+			} else {
+				updated = append(updated, a)
+			}
+		}
+		s.emailUser(models.EmailUpdated, updated...)
+		s.emailUser(models.EmailCancelled, cancelled...)
+	}
+}
+
+// handleChanges is a long-polling alternative to push notifications. It
+// returns the changes to the appointments of the user after the since
+// token, waiting up to the poll timeout for one to occur. Without since,
+// it waits for the next change. A timeout is answered with 304 Not
+// Modified, upon which the client polls again with the same token. A token
+// too old to be served is answered with 410 Gone; the client should then
+// reload its appointments and poll again without since.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	seq := s.changes.Seq()
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			s.respondError(w, http.StatusBadRequest, "Invalid since token")
+			return
+		}
+		seq = n
+	}
+
+	changes, err := s.changes.Wait(r.Context(), UserID(r.Context()), seq)
+	switch {
+	case errors.Is(err, events.ErrExpired):
+		s.respondError(w, http.StatusGone, "Change token expired")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, events.ErrClosed):
+		w.WriteHeader(http.StatusNotModified)
+	case err != nil:
+		// The client went away; there is no one to respond to.
+	default:
+		s.respondJSON(w, http.StatusOK, changesResponse{
+			Changes: changes,
+			Token:   strconv.FormatInt(changes[len(changes)-1].Seq, 10),
+		})
+	}
+}
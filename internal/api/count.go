@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+)
+
+// handleCountAppointments returns the number of appointments in the
+// requested range, see parseRange, as {"count": n}, without listing them.
+// With group_by=day it returns a map from date to count instead, with days
+// in the request's timezone (see resolveTimezone).
+func (s *Server) handleCountAppointments(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := s.parseRange(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.URL.Query().Get("group_by") {
+	case "":
+		count, err := s.db.CountAppointments(r.Context(), UserID(r.Context()), start, end)
+		if err != nil {
+			s.respondInternalError(w, "Failed to count appointments", err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, map[string]int{"count": count})
+	case "day":
+		loc := requestLocation(r.Context())
+		counts, err := s.db.CountAppointmentsByDay(r.Context(), UserID(r.Context()), start, end, loc)
+		if err != nil {
+			s.respondInternalError(w, "Failed to count appointments", err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, counts)
+	default:
+		s.respondError(w, http.StatusBadRequest, "Invalid group_by, expected day")
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/miku/cali/internal/auth"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/notify"
+	"github.com/miku/cali/internal/recurrence"
+)
+
+// emailTemplates renders email notifications. Each kind of notification
+// has a "<kind>.subject" and a "<kind>.body" template, executed with an
+// emailData.
+var emailTemplates = template.Must(template.New("email").Parse(`
+{{- define "created.subject"}}{{if .One}}New appointment: {{.First.Title}}{{else}}{{len .Appointments}} new appointments{{end}}{{end}}
+{{- define "created.body"}}Hello {{.User.Username}},
+
+{{if .One}}A new appointment was{{else}}New appointments were{{end}} added to your calendar:
+{{template "appointments" .}}{{end}}
+
+{{- define "updated.subject"}}{{if .One}}Updated appointment: {{.First.Title}}{{else}}{{len .Appointments}} appointments updated{{end}}{{end}}
+{{- define "updated.body"}}Hello {{.User.Username}},
+
+{{if .One}}An appointment in your calendar was{{else}}Appointments in your calendar were{{end}} updated:
+{{template "appointments" .}}{{end}}
+
+{{- define "cancelled.subject"}}{{if .One}}Cancelled: {{.First.Title}}{{else}}{{len .Appointments}} appointments cancelled{{end}}{{end}}
+{{- define "cancelled.body"}}Hello {{.User.Username}},
+
+{{if .One}}An appointment in your calendar was{{else}}Appointments in your calendar were{{end}} cancelled:
+{{template "appointments" .}}{{end}}
+
+{{- define "reminder.subject"}}Reminder: {{.First.Title}}{{end}}
+{{- define "reminder.body"}}Hello {{.User.Username}},
+
+This is a reminder of your appointment:
+{{template "appointments" .}}{{end}}
+
+{{- define "verify.subject"}}Verify your email address{{end}}
+{{- define "verify.body"}}Hello {{.User.Username}},
+
+To receive notifications at this address, verify it with the code
+
+  {{.Code}}
+
+If you did not ask for this, ignore this email.
+{{end}}
+
+{{- define "appointments"}}
+{{- range .Appointments}}
+{{.Title}}
+  When:    {{.When}}
+{{- with .Repeats}}
+  Repeats: {{.}}
+{{- end}}
+{{- with .Place}}
+  Where:   {{.}}
+{{- end}}
+{{- with .ConferenceURL}}
+  Join:    {{.}}
+{{- end}}
+{{- with .Description}}
+
+  {{.}}
+{{- end}}
+{{end}}
+{{- end}}
+`))
+
+// emailData is passed to the email templates.
+type emailData struct {
+	User         *models.User
+	Appointments []emailAppointment
+	// Code is the verification code of the user's address.
+	Code string
+}
+
+// One reports whether the notification is about a single appointment.
+func (d emailData) One() bool {
+	return len(d.Appointments) == 1
+}
+
+// First returns the first appointment of the notification.
+func (d emailData) First() emailAppointment {
+	return d.Appointments[0]
+}
+
+// emailAppointment adds preformatted fields to an appointment.
+type emailAppointment struct {
+	*models.Appointment
+	// When is the time of the appointment in the recipient's timezone.
+	When string
+	// Repeats describes the recurrence, if any.
+	Repeats string
+}
+
+// renderEmail renders a notification of the given kind to user about
+// appts.
+func renderEmail(kind string, user *models.User, appts []*models.Appointment) (notify.Message, error) {
+	loc := user.Location()
+	data := emailData{User: user}
+	for _, a := range appts {
+		ea := emailAppointment{Appointment: a, When: emailWhen(a, loc)}
+		if a.Recurrence != "" {
+			if desc, err := recurrence.Describe(a.Recurrence); err == nil {
+				ea.Repeats = desc
+			}
+		}
+		data.Appointments = append(data.Appointments, ea)
+	}
+	return executeEmail(kind, data)
+}
+
+// executeEmail renders the templates of the given kind with data, to the
+// address of data.User.
+func executeEmail(kind string, data emailData) (notify.Message, error) {
+	var subject, body strings.Builder
+	if err := emailTemplates.ExecuteTemplate(&subject, kind+".subject", data); err != nil {
+		return notify.Message{}, err
+	}
+	if err := emailTemplates.ExecuteTemplate(&body, kind+".body", data); err != nil {
+		return notify.Message{}, err
+	}
+	return notify.Message{To: data.User.Email, Subject: subject.String(), Body: body.String()}, nil
+}
+
+// emailWhen formats the time of an appointment in loc, or in the
+// appointment's own timezone if loc is nil. All-day appointments are shown
+// as dates only.
+func emailWhen(a *models.Appointment, loc *time.Location) string {
+	const day = "Mon, 2 Jan 2006"
+	if a.AllDay {
+		start, end := a.StartTime.In(a.Location()), a.EndTime.In(a.Location())
+		if start.Equal(end) {
+			return start.Format(day) + " (all day)"
+		}
+		return fmt.Sprintf("%s – %s (all day)", start.Format(day), end.Format(day))
+	}
+	if loc == nil {
+		loc = a.Location()
+	}
+	start, end := a.StartTime.In(loc), a.EndTime.In(loc)
+	if start.Format(day) == end.Format(day) {
+		return fmt.Sprintf("%s %s–%s %s", start.Format(day), start.Format("15:04"), end.Format("15:04"), loc)
+	}
+	return fmt.Sprintf("%s %s – %s %s %s", start.Format(day), start.Format("15:04"), end.Format(day), end.Format("15:04"), loc)
+}
+
+// emailUser queues an email notification of the given kind about appts,
+// which belong to the same user, if the user opted in to it.
+func (s *Server) emailUser(kind string, appts ...*models.Appointment) {
+	s.emailUserIf(kind, func(u *models.User) bool { return u.WantsEmail(kind) }, appts...)
+}
+
+// emailUserIf queues an email notification of the given kind about appts,
+// which belong to the same user, if send reports true for the user.
+func (s *Server) emailUserIf(kind string, send func(*models.User) bool, appts ...*models.Appointment) {
+	if !s.mailer.Enabled() || len(appts) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user, err := s.db.GetUser(ctx, appts[0].UserID)
+	if err != nil {
+		log.Printf("Email notification %s for user %d: %v", kind, appts[0].UserID, err)
+		return
+	}
+	if user == nil || !send(user) {
+		return
+	}
+	msg, err := renderEmail(kind, user, appts)
+	if err != nil {
+		log.Printf("Email notification %s for user %d: %v", kind, user.ID, err)
+		return
+	}
+	s.mailer.Send(msg)
+}
+
+// emailVerification queues an email with the code verifying the address of
+// user, unless it is verified already.
+func (s *Server) emailVerification(user *models.User) {
+	if !s.mailer.Enabled() || user.Email == "" || user.EmailVerified {
+		return
+	}
+	msg, err := executeEmail("verify", emailData{User: user, Code: auth.EmailCode(s.secret, user.ID, user.Email)})
+	if err != nil {
+		log.Printf("Email verification for user %d: %v", user.ID, err)
+		return
+	}
+	s.mailer.Send(msg)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// errUnsupportedEncoding is returned for a Content-Encoding other than
+	// gzip or deflate.
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	// errBodyTooLarge is returned when a decompressed body exceeds its
+	// limit.
+	errBodyTooLarge = errors.New("decompressed body too large")
+)
+
+// encodingError reports a body that does not decompress according to its
+// declared Content-Encoding.
+type encodingError struct {
+	encoding string
+	err      error
+}
+
+func (e *encodingError) Error() string {
+	return fmt.Sprintf("invalid %s body: %v", e.encoding, e.err)
+}
+
+func (e *encodingError) Unwrap() error { return e.err }
+
+// readJSON decodes the JSON body of r into v. The body is limited to the
+// configured maximum size, and fields not present in v are rejected, so a
+// misspelled field fails rather than going unnoticed. An empty body yields
+// io.EOF.
+func (s *Server) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.config.Server.MaxBodySize))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// respondBodyError responds to a request whose body readJSON failed to
+// decode, telling the client what is wrong with it.
+func (s *Server) respondBodyError(w http.ResponseWriter, err error) {
+	var (
+		tooLarge  *http.MaxBytesError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		timeErr   *timeError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		s.respondError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body too large, the limit is %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		s.respondError(w, http.StatusBadRequest, "Missing request body")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The encoding/json package has no error type for this.
+		s.respondError(w, http.StatusBadRequest, "Unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	case errors.As(err, &timeErr):
+		s.respondError(w, http.StatusBadRequest, "Invalid time "+strings.TrimPrefix(timeErr.Error(), "invalid time "))
+	case errors.As(err, &syntaxErr):
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for field %q", typeErr.Field))
+	default:
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+	}
+}
+
+// decodeJSON is like readJSON, but on failure writes an error response and
+// returns false.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := s.readJSON(w, r, v); err != nil {
+		s.respondBodyError(w, err)
+		return false
+	}
+	return true
+}
+
+// requestBody returns the body of r, transparently decompressed according
+// to its Content-Encoding header (gzip or deflate). Both the body as sent
+// and the decompressed body are limited to limit bytes; reading beyond
+// fails with a *http.MaxBytesError or errBodyTooLarge, respectively.
+// Decompression failures are reported as *encodingError.
+func requestBody(w http.ResponseWriter, r *http.Request, limit int64) (io.Reader, error) {
+	body := http.MaxBytesReader(w, r.Body, limit)
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	var (
+		zr  io.Reader
+		err error
+	)
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err = gzip.NewReader(body)
+	case "deflate":
+		// HTTP deflate is a zlib stream (RFC 9110, section 8.4.1.2).
+		zr, err = zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, &encodingError{encoding: encoding, err: err}
+	}
+	return &decompressedReader{encoding: encoding, r: zr, n: limit}, nil
+}
+
+// decompressedReader limits a decompressed stream to n bytes and tags
+// decompression errors.
+type decompressedReader struct {
+	encoding string
+	r        io.Reader
+	n        int64
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	if d.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Read one byte beyond the limit to detect exceeding it.
+	if int64(len(p)) > d.n+1 {
+		p = p[:d.n+1]
+	}
+	n, err := d.r.Read(p)
+	d.n -= int64(n)
+	if d.n < 0 {
+		return n, errBodyTooLarge
+	}
+	var tooLarge *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &tooLarge) {
+		err = &encodingError{encoding: d.encoding, err: err}
+	}
+	return n, err
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// etag returns the entity tag of an appointment, derived from its version.
+func etag(a *models.Appointment) string {
+	return `"` + strconv.FormatInt(a.Version, 10) + `"`
+}
+
+// parseETag returns the version encoded in an entity tag made by etag.
+// Weak tags are accepted.
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifMatch returns the version required by the If-Match header of r, or
+// zero if there is none or it is "*". It returns false if the header is no
+// entity tag made by etag, which no appointment can match.
+func ifMatch(r *http.Request) (int64, bool) {
+	tag := r.Header.Get("If-Match")
+	if tag == "" || tag == "*" {
+		return 0, true
+	}
+	return parseETag(tag)
+}
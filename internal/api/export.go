@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/export"
+	"github.com/miku/cali/internal/ical"
+	"github.com/miku/cali/internal/models"
+)
+
+// handleExportPDF renders the appointments in [start, end) as a printable
+// agenda. Without a range, the agenda covers the next seven days. Days are
+// grouped in the request's timezone (see resolveTimezone), and formatted
+// according to the locale parameter or the configured default locale.
+func (s *Server) handleExportPDF(w http.ResponseWriter, r *http.Request) {
+	loc := requestLocation(r.Context())
+
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = s.config.Export.Locale
+	}
+	lc := export.LookupLocale(locale)
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	start, err := parseTimeParam(r, "start", today)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid start time")
+		return
+	}
+	end, err := parseTimeParam(r, "end", start.AddDate(0, 0, 7))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid end time")
+		return
+	}
+	if end.Before(start) {
+		s.respondError(w, http.StatusBadRequest, "End time before start time")
+		return
+	}
+
+	appointments, _, err := s.db.ListAppointments(r.Context(), UserID(r.Context()), start, end, db.Filter{}, 0, 0)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	title := fmt.Sprintf("Agenda %s - %s",
+		lc.FormatDate(start.In(loc)), lc.FormatDate(end.In(loc)))
+
+	// Render into a buffer first, so a rendering error can still be
+	// reported with a proper status code.
+	var buf bytes.Buffer
+	if err := export.PDFAgenda(&buf, title, appointments, loc, lc); err != nil {
+		s.respondInternalError(w, "Failed to render agenda", err)
+		return
+	}
+
+	filename := fmt.Sprintf("agenda-%s.pdf", start.In(loc).Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", fmt.Sprint(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// handleExportICS serves all appointments of the user as an iCalendar feed,
+// suitable for subscribing from calendar clients.
+func (s *Server) handleExportICS(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	enc := ical.NewEncoder(&buf)
+	err := s.db.WalkAppointments(r.Context(), UserID(r.Context()), func(a *models.Appointment) error {
+		return enc.Encode(a)
+	})
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		s.respondInternalError(w, "Failed to export appointments", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="cali.ics"`)
+	w.Header().Set("Content-Length", fmt.Sprint(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
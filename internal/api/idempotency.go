@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// idempotencyKeyHeader carries a key chosen by the client to make retries of
+// a create request safe: a repeated request with the same key is answered
+// with the appointment created by the first one.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// validIdempotencyKey reports whether a key is at most 255 printable ASCII
+// characters.
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > 255 {
+		return false
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHash identifies a decoded request body, so formatting and field
+// order do not matter when comparing a retry to the original request.
+func requestHash(req interface{}) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyWindow returns the time before which idempotency keys have
+// expired.
+func (s *Server) idempotencyWindow() time.Time {
+	return time.Now().Add(-s.config.Idempotency.TTL)
+}
+
+// replayIdempotent answers a request whose idempotency key was recorded
+// before: with the appointment created then if the request is the same, or
+// with 422 if the key was used for another request. It reports whether a
+// response was written; if not, the key is unknown or expired.
+func (s *Server) replayIdempotent(w http.ResponseWriter, r *http.Request, key, hash string) bool {
+	k, err := s.db.GetIdempotencyKey(r.Context(), UserID(r.Context()), key, s.idempotencyWindow())
+	if err != nil {
+		s.respondInternalError(w, "Failed to get idempotency key", err)
+		return true
+	}
+	if k == nil {
+		return false
+	}
+	if k.RequestHash != hash {
+		s.respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return true
+	}
+	// The appointment may have been deleted since; the client still gets
+	// what the original request created.
+	appt, err := s.db.GetAppointmentIncludingDeleted(r.Context(), k.AppointmentID)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return true
+	}
+	if appt == nil {
+		return false
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
+	return true
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/ical"
+	"github.com/miku/cali/internal/models"
+)
+
+// maxImportSize caps the size of uploaded calendar files.
+const maxImportSize = 10 << 20
+
+// importFileField is the form field of a calendar file uploaded as
+// multipart/form-data.
+const importFileField = "file"
+
+// errMissingFile is returned for a multipart upload without a calendar
+// file.
+var errMissingFile = errors.New("missing file")
+
+// uploadedFile returns the calendar file of an import request. It is sent
+// either as the body itself, or as the "file" field of a multipart form, as
+// posted by an HTML file input.
+func uploadedFile(r *http.Request, body io.Reader) (io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return body, nil
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == importFileField {
+			return part, nil
+		}
+	}
+}
+
+// importResult summarizes an import. Events that fail to parse or validate
+// are skipped and reported in Errors. A dry run additionally lists the
+// appointments that would be created and their overlaps with existing
+// appointments or each other, which do not prevent an import.
+type importResult struct {
+	Imported     int                   `json:"imported"`
+	Skipped      int                   `json:"skipped"`
+	Errors       []string              `json:"errors"`
+	DryRun       bool                  `json:"dry_run,omitempty"`
+	Appointments []*models.Appointment `json:"appointments,omitempty"`
+	Conflicts    []string              `json:"conflicts,omitempty"`
+}
+
+// parseDryRun reads the dry_run parameter of an import. On invalid input an
+// error response is written and ok is false.
+func (s *Server) parseDryRun(w http.ResponseWriter, r *http.Request) (dryRun, ok bool) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid dry_run")
+		return false, false
+	}
+	return dryRun, true
+}
+
+// importConflicts describes the overlaps of appointments about to be
+// imported with existing appointments and with earlier ones in the list,
+// naming each appointment by its label. All-day and cancelled appointments
+// are left out, as they do not block time.
+func (s *Server) importConflicts(ctx context.Context, appointments []*models.Appointment, labels []string) ([]string, error) {
+	var conflicts []string
+	for i, appt := range appointments {
+		if appt.AllDay || appt.Status == models.StatusCancelled {
+			continue
+		}
+		start, end := s.conflictRange(appt)
+		conflict, err := s.db.FindConflict(ctx, appt.UserID, start, end, 0)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s: overlaps existing appointment %q", labels[i], conflict.Title))
+		}
+		for j, other := range appointments[:i] {
+			if !other.AllDay && other.Status != models.StatusCancelled &&
+				start.Before(other.EndTime) && other.StartTime.Before(end) {
+				conflicts = append(conflicts, fmt.Sprintf("%s: overlaps %s", labels[i], labels[j]))
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// handleImportICS creates appointments from the VEVENTs of an iCalendar file
+// sent as request body or multipart form upload, which may be compressed
+// with gzip or deflate. Floating times are interpreted in the request's
+// timezone (see resolveTimezone). All valid events are inserted in a single
+// transaction. With dry_run=true, nothing is stored and the response
+// previews the import.
+func (s *Server) handleImportICS(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := s.parseDryRun(w, r)
+	if !ok {
+		return
+	}
+	loc := requestLocation(r.Context())
+
+	body, err := requestBody(w, r, maxImportSize)
+	if err == nil {
+		body, err = uploadedFile(r, body)
+	}
+	if err != nil {
+		s.respondImportError(w, err)
+		return
+	}
+	vevents, err := ical.Decode(body, loc)
+	if err != nil {
+		s.respondImportError(w, err)
+		return
+	}
+
+	var (
+		result       = importResult{Errors: []string{}, DryRun: dryRun}
+		appointments []*models.Appointment
+		labels       []string
+	)
+	for i, ev := range vevents {
+		label := fmt.Sprintf("event %d", i+1)
+		if ev.UID != "" {
+			label += fmt.Sprintf(" (%s)", ev.UID)
+		}
+		if ev.Err != nil {
+			result.Skipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", label, ev.Err))
+			continue
+		}
+		appt := importAppointment(UserID(r.Context()), ev)
+		s.normalizeTitle(appt)
+		if err := appt.Validate(); err != nil {
+			result.Skipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", label, err))
+			continue
+		}
+		appointments = append(appointments, appt)
+		labels = append(labels, label)
+	}
+
+	if dryRun {
+		conflicts, err := s.importConflicts(r.Context(), appointments, labels)
+		if err != nil {
+			s.respondInternalError(w, "Failed to check for conflicts", err)
+			return
+		}
+		result.Conflicts = conflicts
+		result.Appointments = appointments
+	}
+
+	if len(appointments) > 0 {
+		create := s.db.CreateAppointments
+		if dryRun {
+			create = s.db.PreviewAppointments
+		}
+		// Overlaps are reported by dry runs, not rejected.
+		if err := create(r.Context(), appointments, true); err != nil {
+			if s.respondDuplicate(w, err) {
+				return
+			}
+			s.respondInternalError(w, "Failed to import appointments", err)
+			return
+		}
+		if !dryRun {
+			s.notify(events.Created, appointments...)
+		}
+	}
+	result.Imported = len(appointments)
+
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// importAppointment returns an appointment of the user for an event.
+func importAppointment(userID int64, ev *ical.Event) *models.Appointment {
+	appt := &models.Appointment{
+		UserID:        userID,
+		Title:         ev.Summary,
+		Description:   ev.Description,
+		Place:         ev.Location,
+		ConferenceURL: ev.Conference,
+		Category:      ev.Category,
+		StartTime:     ev.Start,
+		EndTime:       ev.End,
+		AllDay:        ev.AllDay,
+		Timezone:      ev.TZID,
+		Recurrence:    ev.RRule,
+		ExDates:       ev.ExDates,
+		Status:        importStatus(ev.Status),
+		Attendees:     importAttendees(ev.Attendees),
+	}
+	if appt.AllDay {
+		// Anchor dates in the zone of the appointment, like the API
+		// does. DTEND of all-day events is exclusive.
+		appt.StartTime = dateIn(ev.Start, appt.Location())
+		appt.EndTime = dateIn(ev.End.AddDate(0, 0, -1), appt.Location())
+		for i, t := range appt.ExDates {
+			appt.ExDates[i] = dateIn(t, appt.Location())
+		}
+	}
+	return appt
+}
+
+// importStatus maps an iCalendar STATUS to an appointment status. Absent
+// and unknown values, such as the to-do status NEEDS-ACTION, are treated as
+// confirmed.
+func importStatus(status string) string {
+	if s := strings.ToLower(status); models.ValidStatus(s) {
+		return s
+	}
+	return models.StatusConfirmed
+}
+
+// importAttendees maps iCalendar attendees to appointment attendees.
+// PARTSTAT values without a counterpart, like DELEGATED, are treated as
+// needs-action.
+func importAttendees(attendees []ical.Attendee) []models.Attendee {
+	var result []models.Attendee
+	for _, at := range attendees {
+		status := strings.ToLower(at.PartStat)
+		if !models.ValidResponseStatus(status) {
+			status = models.ResponseNeedsAction
+		}
+		result = append(result, models.Attendee{Email: at.Email, ResponseStatus: status})
+	}
+	return result
+}
+
+// respondImportError responds to a calendar upload that could not be read
+// or decoded.
+func (s *Server) respondImportError(w http.ResponseWriter, err error) {
+	var (
+		tooLarge    *http.MaxBytesError
+		encodingErr *encodingError
+	)
+	switch {
+	case errors.As(err, &tooLarge), errors.Is(err, errBodyTooLarge):
+		s.respondError(w, http.StatusRequestEntityTooLarge, "Calendar file too large")
+	case errors.Is(err, errUnsupportedEncoding):
+		s.respondError(w, http.StatusUnsupportedMediaType, "Unsupported content encoding")
+	case errors.As(err, &encodingErr):
+		s.respondError(w, http.StatusBadRequest, "Invalid request body: "+encodingErr.Error())
+	case errors.Is(err, errMissingFile):
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Missing calendar file in form field %q", importFileField))
+	default:
+		s.respondError(w, http.StatusBadRequest, "Invalid calendar: "+err.Error())
+	}
+}
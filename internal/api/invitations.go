@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// inviteRequest is the body of a request inviting an attendee, either a
+// user of this server by name or anyone by email.
+type inviteRequest struct {
+	User  string `json:"user"`
+	Email string `json:"email"`
+}
+
+// respondRequest is the body of a response to an invitation.
+type respondRequest struct {
+	ResponseStatus string `json:"response_status"`
+}
+
+// handleInviteAttendee adds an attendee to an appointment of the user and
+// returns the appointment. Users invited by name are invited with their
+// email address and can respond to the invitation themselves.
+func (s *Server) handleInviteAttendee(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+	var req inviteRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if (req.User == "") == (req.Email == "") {
+		s.respondError(w, http.StatusBadRequest, "Exactly one of user and email is required")
+		return
+	}
+
+	at := models.Attendee{Email: req.Email, ResponseStatus: models.ResponseNeedsAction}
+	if req.User != "" {
+		user, err := s.db.GetUserByUsername(r.Context(), req.User)
+		if err != nil {
+			s.respondInternalError(w, "Failed to get user", err)
+			return
+		}
+		if user == nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown user %q", req.User))
+			return
+		}
+		if user.ID == UserID(r.Context()) {
+			s.respondError(w, http.StatusBadRequest, "Cannot invite yourself")
+			return
+		}
+		if user.Email == "" {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("User %q has no email address", req.User))
+			return
+		}
+		at.Email, at.UserID = user.Email, user.ID
+	}
+	if err := at.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.db.InviteAttendee(r.Context(), id, UserID(r.Context()), &at); err != nil {
+		switch {
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		case errors.Is(err, db.ErrAttendeeExists):
+			s.respondError(w, http.StatusConflict, "Attendee already invited")
+		default:
+			s.respondInternalError(w, "Failed to invite attendee", err)
+		}
+		return
+	}
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+	s.notify(events.Updated, appt)
+	s.respondJSON(w, http.StatusCreated, localizeOne(r, appt))
+}
+
+// handleListInvitations returns the appointments of other users the user
+// is invited to, with the responses of all attendees.
+func (s *Server) handleListInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := s.db.ListInvitations(r.Context(), UserID(r.Context()))
+	if err != nil {
+		s.respondInternalError(w, "Failed to list invitations", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, localize(r, invitations))
+}
+
+// handleRespondToInvitation sets the user's response status to an
+// appointment they are invited to and returns the appointment. The owner
+// is notified of the change like of any other update.
+func (s *Server) handleRespondToInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+	var req respondRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if !models.ValidResponseStatus(req.ResponseStatus) {
+		s.respondError(w, http.StatusBadRequest, "response_status must be needs-action, accepted, declined or tentative")
+		return
+	}
+
+	userID := UserID(r.Context())
+	if err := s.db.RespondToInvitation(r.Context(), id, userID, req.ResponseStatus); err != nil {
+		if errors.Is(err, db.ErrInvitationNotFound) {
+			s.respondError(w, http.StatusNotFound, "Invitation not found")
+			return
+		}
+		s.respondInternalError(w, "Failed to respond to invitation", err)
+		return
+	}
+	appt, err := s.db.GetInvitation(r.Context(), id, userID)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil {
+		s.respondError(w, http.StatusNotFound, "Invitation not found")
+		return
+	}
+	s.notify(events.Updated, appt)
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// mergeRequest names the two appointments to merge. KeepTitleOf selects
+// whose title (and slug) the merged appointment gets and defaults to the
+// first id.
+type mergeRequest struct {
+	IDs         []int64 `json:"ids"`
+	KeepTitleOf int64   `json:"keep_title_of"`
+}
+
+// handleMergeAppointments combines two appointments, e.g. accidental
+// duplicates of the same meeting, into one covering both time ranges.
+func (s *Server) handleMergeAppointments(w http.ResponseWriter, r *http.Request) {
+	var req mergeRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.IDs) != 2 || req.IDs[0] == req.IDs[1] {
+		s.respondError(w, http.StatusBadRequest, "Exactly two distinct appointment IDs are required")
+		return
+	}
+	if req.KeepTitleOf == 0 {
+		req.KeepTitleOf = req.IDs[0]
+	}
+	if req.KeepTitleOf != req.IDs[0] && req.KeepTitleOf != req.IDs[1] {
+		s.respondError(w, http.StatusBadRequest, "keep_title_of must be one of the merged IDs")
+		return
+	}
+
+	userID := UserID(r.Context())
+	// Keep the originals to tell webhook receivers what was deleted.
+	var originals []*models.Appointment
+	for _, id := range req.IDs {
+		a, err := s.db.GetAppointment(r.Context(), id)
+		if err != nil {
+			s.respondInternalError(w, "Failed to get appointment", err)
+			return
+		}
+		originals = append(originals, a)
+	}
+	merged, err := s.db.MergeAppointments(r.Context(), userID, req.IDs[0], req.IDs[1], req.KeepTitleOf)
+	if errors.Is(err, db.ErrMergeRecurring) {
+		s.respondError(w, http.StatusUnprocessableEntity, "Recurring appointments cannot be merged")
+		return
+	}
+	if err != nil {
+		if s.respondDuplicate(w, err) {
+			return
+		}
+		s.respondInternalError(w, "Failed to merge appointments", err)
+		return
+	}
+	if merged == nil {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+
+	s.notify(events.Deleted, originals...)
+	s.notify(events.Created, merged)
+
+	log.Printf("user %d merged appointments %d and %d into %d", userID, req.IDs[0], req.IDs[1], merged.ID)
+	s.respondJSON(w, http.StatusOK, localizeOne(r, merged))
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/metrics"
+)
+
+// serverMetrics are the metrics exposed at /metrics.
+type serverMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+// newServerMetrics registers request metrics and gauges reporting the
+// connection pool of database.
+func newServerMetrics(database db.Store) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: r.NewCounterVec("http_requests_total",
+			"Number of HTTP requests.", "method", "path", "status"),
+		duration: r.NewHistogramVec("http_request_duration_seconds",
+			"Duration of HTTP requests in seconds.", metrics.DefaultBuckets, "method", "path"),
+	}
+	r.NewGaugeFunc("db_open_connections", "Number of established database connections.", func() float64 {
+		return float64(database.Stats().OpenConnections)
+	})
+	r.NewGaugeFunc("db_in_use_connections", "Number of database connections in use.", func() float64 {
+		return float64(database.Stats().InUse)
+	})
+	r.NewGaugeFunc("db_idle_connections", "Number of idle database connections.", func() float64 {
+		return float64(database.Stats().Idle)
+	})
+	r.NewGaugeFunc("recurrence_cache_hit_ratio", "Share of recurrence expansions answered from the cache.",
+		database.ExpansionCacheHitRatio)
+	return m
+}
+
+// instrument records the count and duration of requests. Requests are
+// labeled with the route template, like /api/appointments/{id}, rather
+// than the actual path, to keep the number of series bounded.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := "unknown"
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				path = tpl
+			}
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.metrics.requests.Inc(r.Method, path, strconv.Itoa(rec.status))
+		s.metrics.duration.Observe(time.Since(start).Seconds(), r.Method, path)
+	})
+}
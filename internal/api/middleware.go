@@ -0,0 +1,407 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miku/cali/internal/auth"
+)
+
+type contextKey int
+
+const (
+	clientIPKey contextKey = iota
+	userIDKey
+	requestIDKey
+	locationKey
+)
+
+// requestIDHeader carries the request ID in requests and responses.
+const requestIDHeader = "X-Request-ID"
+
+// RequestID returns the ID of the request stored by the request ID
+// middleware, or an empty string if none was stored.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// recoverPanics responds with 500 Internal Server Error when a handler or
+// middleware panics, logging the panic with its stack trace, rather than
+// letting net/http drop the connection. It is the outermost middleware.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Deliberate aborts are handled by net/http.
+				panic(v)
+			}
+			slog.Error("panic", "error", v, "method", r.Method, "path", r.URL.Path,
+				"request_id", w.Header().Get(requestIDHeader), "stack", string(debug.Stack()))
+			s.respondError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether an ID sent by a client is short and
+// printable enough to be logged and echoed as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID assigns each request an ID, taken from the X-Request-ID header
+// if the client sent a usable one. The ID is stored in the request context,
+// see RequestID, and set on the response before the handler runs, so error
+// responses and log lines can include it.
+func (s *Server) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the client address resolved by the client IP middleware,
+// or an empty string if none was stored.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// parseTrustedProxies turns a list of IP addresses and CIDR ranges into
+// prefixes. Invalid entries are skipped; they are rejected when the
+// configuration is loaded.
+func parseTrustedProxies(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, e := range entries {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		if a, err := netip.ParseAddr(e); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+		}
+	}
+	return prefixes
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP determines the address of the client. Forwarding headers
+// are only honored if the direct peer is a trusted proxy. X-Forwarded-For is
+// walked from right to left, skipping trusted proxies, so a client cannot
+// spoof its address by prepending entries to the header.
+func (s *Server) resolveClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !s.isTrustedProxy(peer) {
+		return host
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		var leftmost string
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Garbage in the chain, stop at the last hop we could verify.
+				break
+			}
+			if !s.isTrustedProxy(addr) {
+				return addr.Unmap().String()
+			}
+			leftmost = addr.Unmap().String()
+		}
+		if leftmost != "" {
+			return leftmost
+		}
+	}
+	if v := strings.TrimSpace(r.Header.Get("X-Real-IP")); v != "" {
+		if addr, err := netip.ParseAddr(v); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return host
+}
+
+// isHTTPS reports whether the client made the request over HTTPS, either
+// to the server itself or, as told by X-Forwarded-Proto, to a trusted
+// proxy terminating TLS.
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !s.isTrustedProxy(peer) {
+		return false
+	}
+	// Each proxy may append its own scheme; the first is the client's.
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// clientIPMiddleware stores the resolved client address in the request
+// context, see ClientIP.
+func (s *Server) clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey, s.resolveClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimit answers requests exceeding the per-client rate limit with 429
+// Too Many Requests. It relies on the client IP middleware having run, so it
+// can be attached to the router or any subrouter after it. Without a
+// configured limit, requests are passed on unchanged.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := s.limiter.Allow(ClientIP(r.Context())); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.respondError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// importRetryAfter is the wait suggested to clients whose import was
+// rejected because too many are running.
+const importRetryAfter = 5 * time.Second
+
+// limitImports answers requests with 429 Too Many Requests while the
+// configured number of imports is already running. Without a limit,
+// requests are passed on unchanged.
+func (s *Server) limitImports(next http.Handler) http.Handler {
+	if s.imports == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.imports <- struct{}{}:
+			defer func() { <-s.imports }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(importRetryAfter.Seconds())))
+			s.respondError(w, http.StatusTooManyRequests, "Too many imports running, try again later")
+		}
+	})
+}
+
+// statusRecorder remembers the status code and the size of the body
+// written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush keeps streaming responses working.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests logs each request with its outcome at info level. It wraps
+// the whole router, so requests not matching any route are logged too.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("size", rec.size),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", s.resolveClientIP(r)),
+			slog.String("request_id", RequestID(r.Context())),
+		)
+	})
+}
+
+// UserID returns the id of the authenticated user stored by the
+// authentication middleware, or zero.
+func UserID(ctx context.Context) int64 {
+	id, _ := ctx.Value(userIDKey).(int64)
+	return id
+}
+
+// authenticate requires a valid "Authorization: Bearer <token>" header or,
+// without one, a session cookie, and stores the id of the user in the
+// request context, see UserID.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			userID, err := s.sessionUser(r)
+			if err != nil {
+				s.respondInternalError(w, "Failed to get session", err)
+				return
+			}
+			if userID != 0 {
+				ctx := context.WithValue(r.Context(), userIDKey, userID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cali"`)
+			s.respondError(w, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+		user, err := s.tokenUser(r.Context(), strings.TrimSpace(token))
+		switch {
+		case errors.Is(err, auth.ErrExpiredToken):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cali", error="invalid_token"`)
+			s.respondError(w, http.StatusUnauthorized, "Token expired")
+			return
+		case errors.Is(err, auth.ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cali", error="invalid_token"`)
+			s.respondError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		case err != nil:
+			s.respondInternalError(w, "Failed to get user", err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, user.ID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// corsMethods and corsHeaders are allowed in cross-origin requests.
+const (
+	corsMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsHeaders = "Authorization, Content-Type, Content-Encoding, Accept, X-Request-ID, Idempotency-Key, If-Match"
+)
+
+// cors adds CORS headers for requests from the configured origins and
+// answers their preflight requests. Without configured origins, requests
+// are passed on unchanged. It wraps the whole router, so preflight requests
+// are answered before routing and authentication.
+func (s *Server) cors(next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range s.config.Web.CORS.AllowedOrigins {
+		allowed[o] = true
+	}
+	if len(allowed) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!allowed["*"] && !allowed[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		if allowed["*"] {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		// Let browser clients read the request ID to report errors, and
+		// the entity tag to make changes conditional on it.
+		h.Set("Access-Control-Expose-Headers", requestIDHeader+", ETag")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout bounds the context of requests handled by h to d, so that
+// work derived from the request context is cancelled once the deadline
+// passes.
+func withTimeout(d time.Duration, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+)
+
+// moveAppointmentRequest is the body of a request to move an appointment.
+type moveAppointmentRequest struct {
+	StartTime requestTime `json:"start_time"`
+	// UpdatedAt makes the move conditional on the appointment not having
+	// been modified since, like an If-Match header.
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// handleMoveAppointment moves an appointment to a new start time, shifting
+// its end by the same amount so the duration is kept. All-day appointments
+// move by whole days. Moving a series moves all of its occurrences.
+func (s *Server) handleMoveAppointment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+	force, ok := s.parseForce(w, r)
+	if !ok {
+		return
+	}
+
+	var req moveAppointmentRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.StartTime.IsZero() {
+		s.respondError(w, http.StatusBadRequest, "Missing start_time")
+		return
+	}
+
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+
+	start := req.StartTime.Time
+	if appt.AllDay {
+		start = req.StartTime.date(appt.Location())
+	} else if req.StartTime.DateOnly {
+		s.respondError(w, http.StatusBadRequest, "start_time must include a time for appointments that are not all-day")
+		return
+	}
+	appt.Move(start)
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.checkBooking(w, appt) {
+		return
+	}
+
+	// The move is conditional on the version the client last saw, if given,
+	// and otherwise on the one loaded above.
+	version, ok := ifMatch(r)
+	switch {
+	case !ok:
+		s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		return
+	case version != 0:
+		appt.Version = version
+	case r.Header.Get("If-Match") == "" && req.UpdatedAt != nil:
+		appt.Version, appt.UpdatedAt = 0, *req.UpdatedAt
+	}
+	if err := s.db.MoveAppointment(r.Context(), appt, force); err != nil {
+		var conflict *db.ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			s.respondConflict(w, conflict, 0)
+		case errors.Is(err, db.ErrStaleAppointment):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to move appointment", err)
+			}
+		}
+		return
+	}
+	s.notify(events.Updated, appt)
+	w.Header().Set("ETag", etag(appt))
+
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+)
+
+// patchAppointmentRequest carries the fields of a partial update. Absent
+// fields are left unchanged.
+type patchAppointmentRequest struct {
+	Title         *string      `json:"title"`
+	Slug          *string      `json:"slug"`
+	Description   *string      `json:"description"`
+	Location      *string      `json:"location"`
+	ConferenceURL *string      `json:"conference_url"`
+	Category      *string      `json:"category"`
+	Color         *string      `json:"color"`
+	StartTime     *requestTime `json:"start_time"`
+	EndTime       *requestTime `json:"end_time"`
+	AllDay        *bool        `json:"all_day"`
+	Timezone      *string      `json:"timezone"`
+	Recurrence    *string      `json:"recurrence"`
+	Status        *string      `json:"status"`
+	// ReminderChannels replaces the channels; an empty list restores
+	// the defaults.
+	ReminderChannels *[]string `json:"reminder_channels"`
+}
+
+// schedulingChanged reports whether the request changes when the
+// appointment takes place, or whether it blocks time at all.
+func (req *patchAppointmentRequest) schedulingChanged() bool {
+	return req.StartTime != nil || req.EndTime != nil || req.AllDay != nil ||
+		req.Timezone != nil || req.Recurrence != nil || req.Status != nil
+}
+
+// apply changes appt according to the request, except for the title, and
+// returns the changed columns.
+func (req *patchAppointmentRequest) apply(appt *models.Appointment) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if req.Slug != nil {
+		if *req.Slug == "" {
+			return nil, errors.New("slug cannot be empty")
+		}
+		appt.Slug = *req.Slug
+		fields["slug"] = appt.Slug
+	}
+	if req.Description != nil {
+		appt.Description = *req.Description
+		fields["description"] = appt.Description
+	}
+	if req.Location != nil {
+		appt.Place = *req.Location
+		fields["location"] = appt.Place
+	}
+	if req.ConferenceURL != nil {
+		appt.ConferenceURL = *req.ConferenceURL
+		fields["conference_url"] = appt.ConferenceURL
+	}
+	if req.Category != nil {
+		appt.Category = *req.Category
+		fields["category"] = appt.Category
+	}
+	if req.Color != nil {
+		appt.Color = *req.Color
+		fields["color"] = appt.Color
+	}
+	if req.Recurrence != nil {
+		appt.Recurrence = *req.Recurrence
+		fields["recurrence"] = appt.Recurrence
+	}
+	if req.Status != nil {
+		appt.Status = *req.Status
+		fields["status"] = appt.Status
+	}
+	if req.ReminderChannels != nil {
+		appt.ReminderChannels = *req.ReminderChannels
+		fields["reminder_channels"] = strings.Join(appt.ReminderChannels, ",")
+	}
+	if req.StartTime == nil && req.EndTime == nil && req.AllDay == nil && req.Timezone == nil {
+		return fields, nil
+	}
+
+	oldLoc := appt.Location()
+	if req.AllDay != nil {
+		appt.AllDay = *req.AllDay
+	}
+	if req.Timezone != nil {
+		appt.Timezone = *req.Timezone
+	}
+	loc := appt.Location()
+	resolve := func(t *requestTime, current *time.Time) error {
+		switch {
+		case t != nil && appt.AllDay:
+			*current = t.date(loc)
+		case t != nil && t.DateOnly:
+			return errors.New("start and end time must include a time unless all_day is set")
+		case t != nil:
+			*current = t.Time
+		case appt.AllDay:
+			// Keep the date of the stored time in its original zone.
+			*current = dateIn(current.In(oldLoc), loc)
+		}
+		return nil
+	}
+	if err := resolve(req.StartTime, &appt.StartTime); err != nil {
+		return nil, err
+	}
+	if err := resolve(req.EndTime, &appt.EndTime); err != nil {
+		return nil, err
+	}
+	fields["start_time"] = appt.StartTime
+	fields["end_time"] = appt.EndTime
+	fields["all_day"] = appt.AllDay
+	fields["timezone"] = appt.Timezone
+	return fields, nil
+}
+
+// handlePatchAppointment updates only the fields present in the request
+// body. The resulting appointment is validated and checked for conflicts
+// as a whole, like a full update.
+func (s *Server) handlePatchAppointment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+	force, ok := s.parseForce(w, r)
+	if !ok {
+		return
+	}
+
+	var req patchAppointmentRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	// The update is conditional on the version the client last saw, if
+	// given.
+	version, ok := ifMatch(r)
+	if !ok {
+		s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		return
+	}
+
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+
+	fields, err := req.apply(appt)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Title != nil {
+		appt.Title, appt.OriginalTitle = *req.Title, ""
+		s.normalizeTitle(appt)
+		fields["title"] = appt.Title
+		fields["original_title"] = appt.OriginalTitle
+	}
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ReminderChannels != nil && !s.checkReminderChannels(w, appt) {
+		return
+	}
+	if req.schedulingChanged() && !s.checkBooking(w, appt) {
+		return
+	}
+
+	// Other changes keep conflicts the appointment may already have.
+	force = force || !req.schedulingChanged()
+	patched, err := s.db.PatchAppointment(r.Context(), id, appt.UserID, version, fields, force)
+	if err != nil {
+		var conflict *db.ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			s.respondConflict(w, conflict, 0)
+		case errors.Is(err, db.ErrStaleAppointment):
+			s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		case errors.Is(err, db.ErrAppointmentNotFound):
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+		default:
+			if !s.respondDuplicate(w, err) {
+				s.respondInternalError(w, "Failed to update appointment", err)
+			}
+		}
+		return
+	}
+	s.notify(events.Updated, patched)
+	w.Header().Set("ETag", etag(patched))
+
+	s.respondJSON(w, http.StatusOK, localizeOne(r, patched))
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/miku/cali/internal/pb"
+)
+
+// wantsProtobuf reports whether the client asks for protocol buffers rather
+// than JSON. Errors are always reported as JSON.
+func wantsProtobuf(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, pb.ContentType) || strings.Contains(accept, "application/x-protobuf")
+}
+
+// respondProtobuf writes an encoded protocol buffer message.
+func (s *Server) respondProtobuf(w http.ResponseWriter, status int, msg []byte) {
+	w.Header().Set("Content-Type", pb.ContentType)
+	w.WriteHeader(status)
+	w.Write(msg)
+}
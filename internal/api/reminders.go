@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/notify"
+)
+
+// reminderRequest is the body of a request adding a reminder. The method
+// defaults to log.
+type reminderRequest struct {
+	MinutesBefore int    `json:"minutes_before"`
+	Method        string `json:"method"`
+}
+
+// reminderAppointment resolves the id route variable to an appointment of
+// the user. On failure an error response is written and nil returned.
+func (s *Server) reminderAppointment(w http.ResponseWriter, r *http.Request) *models.Appointment {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return nil
+	}
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return nil
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return nil
+	}
+	return appt
+}
+
+// reminderID parses the reminderID route variable. On failure an error
+// response is written and false returned.
+func (s *Server) reminderID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["reminderID"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid reminder ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) handleListReminders(w http.ResponseWriter, r *http.Request) {
+	appt := s.reminderAppointment(w, r)
+	if appt == nil {
+		return
+	}
+	reminders, err := s.db.ListReminders(r.Context(), appt.ID)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list reminders", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, reminders)
+}
+
+func (s *Server) handleCreateReminder(w http.ResponseWriter, r *http.Request) {
+	appt := s.reminderAppointment(w, r)
+	if appt == nil {
+		return
+	}
+	reminder := s.decodeReminder(w, r, appt)
+	if reminder == nil {
+		return
+	}
+	if err := s.db.CreateReminder(r.Context(), reminder); err != nil {
+		s.respondInternalError(w, "Failed to create reminder", err)
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, reminder)
+}
+
+// decodeReminder reads and validates a reminder of appt from the request
+// body. On failure an error response is written and nil returned.
+func (s *Server) decodeReminder(w http.ResponseWriter, r *http.Request, appt *models.Appointment) *models.Reminder {
+	var req reminderRequest
+	if !s.decodeJSON(w, r, &req) {
+		return nil
+	}
+	reminder := &models.Reminder{
+		AppointmentID: appt.ID,
+		MinutesBefore: req.MinutesBefore,
+		Method:        req.Method,
+	}
+	if reminder.Method == "" {
+		reminder.Method = models.ReminderLog
+	}
+	if err := reminder.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return nil
+	}
+	return reminder
+}
+
+func (s *Server) handleGetReminder(w http.ResponseWriter, r *http.Request) {
+	appt := s.reminderAppointment(w, r)
+	if appt == nil {
+		return
+	}
+	id, ok := s.reminderID(w, r)
+	if !ok {
+		return
+	}
+	reminder, err := s.db.GetReminder(r.Context(), id, appt.ID)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get reminder", err)
+		return
+	}
+	if reminder == nil {
+		s.respondError(w, http.StatusNotFound, "Reminder not found")
+		return
+	}
+	s.respondJSON(w, http.StatusOK, reminder)
+}
+
+// handleUpdateReminder replaces the offset and method of a reminder, which
+// is sent again at its new time even if it was sent before.
+func (s *Server) handleUpdateReminder(w http.ResponseWriter, r *http.Request) {
+	appt := s.reminderAppointment(w, r)
+	if appt == nil {
+		return
+	}
+	id, ok := s.reminderID(w, r)
+	if !ok {
+		return
+	}
+	reminder := s.decodeReminder(w, r, appt)
+	if reminder == nil {
+		return
+	}
+	reminder.ID = id
+	if err := s.db.UpdateReminder(r.Context(), reminder); err != nil {
+		if errors.Is(err, db.ErrReminderNotFound) {
+			s.respondError(w, http.StatusNotFound, "Reminder not found")
+			return
+		}
+		s.respondInternalError(w, "Failed to update reminder", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, reminder)
+}
+
+func (s *Server) handleDeleteReminder(w http.ResponseWriter, r *http.Request) {
+	appt := s.reminderAppointment(w, r)
+	if appt == nil {
+		return
+	}
+	id, ok := s.reminderID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.db.DeleteReminder(r.Context(), id, appt.ID); err != nil {
+		if errors.Is(err, db.ErrReminderNotFound) {
+			s.respondError(w, http.StatusNotFound, "Reminder not found")
+			return
+		}
+		s.respondInternalError(w, "Failed to delete reminder", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSnoozeReminders suppresses the user's reminders until the time
+// given by the until parameter and returns the effective snooze window.
+// Snoozing again while a snooze is in effect moves its end.
+func (s *Server) handleSnoozeReminders(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("until") == "" {
+		s.respondError(w, http.StatusBadRequest, "until is required")
+		return
+	}
+	until, err := parseTimeParam(r, "until", time.Time{})
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid until time")
+		return
+	}
+	now := time.Now()
+	if !until.After(now) {
+		s.respondError(w, http.StatusBadRequest, "until must be in the future")
+		return
+	}
+	snooze, err := s.db.SnoozeReminders(r.Context(), UserID(r.Context()), until, now)
+	if err != nil {
+		s.respondInternalError(w, "Failed to snooze reminders", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, snooze)
+}
+
+// checkReminderChannels responds with an error and returns false if appt
+// asks for reminder channels the server cannot send by.
+func (s *Server) checkReminderChannels(w http.ResponseWriter, appt *models.Appointment) bool {
+	if err := s.reminderChannelError(appt); err != nil {
+		s.respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	return true
+}
+
+// reminderChannelError describes why the server cannot send reminders of
+// appt by the channels it asks for, or returns nil.
+func (s *Server) reminderChannelError(appt *models.Appointment) error {
+	if slices.Contains(appt.ReminderChannels, models.ReminderEmail) && !s.mailer.Enabled() {
+		return errors.New("email reminders are not available, no SMTP server is configured")
+	}
+	return nil
+}
+
+// sendReminder delivers a due reminder by the channels chosen for its
+// appointment or, by default, by the reminder's method and by email if the
+// user opted in to reminder emails. It is called by the reminder scheduler.
+func (s *Server) sendReminder(d db.DueReminder) {
+	a := d.Appointment
+	channels := a.ReminderChannels
+	if len(channels) == 0 {
+		s.emailUser(models.EmailReminder, a)
+		channels = []string{d.Reminder.Method}
+	}
+	for _, channel := range channels {
+		switch channel {
+		case models.ReminderEmail:
+			// Choosing email for the appointment stands in for the
+			// user's opt-in.
+			s.emailUserIf(models.EmailReminder, (*models.User).CanEmail, a)
+		case models.ReminderWebhook:
+			s.sendWebhooks(models.EventReminder, a)
+		case models.ReminderDesktop:
+			s.desktopOnce.Do(func() { s.desktop = notify.NewDesktop() })
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			message := fmt.Sprintf("Starts at %s", a.StartTime.In(a.Location()).Format("Mon Jan 2 15:04"))
+			if err := s.desktop.Notify(ctx, a.Title, message); err != nil {
+				log.Printf("Reminder %d for appointment %d: %v", d.Reminder.ID, a.ID, err)
+			}
+			cancel()
+		default:
+			log.Printf("Reminder: appointment %d %q of user %d starts at %s",
+				a.ID, a.Title, a.UserID, a.StartTime.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+)
+
+// reorderRequest lists appointment ids in the desired display order.
+type reorderRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// handleReorderAppointments sets a user defined stacking order, used to
+// order appointments that start at the same time.
+func (s *Server) handleReorderAppointments(w http.ResponseWriter, r *http.Request) {
+	var req reorderRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		s.respondError(w, http.StatusBadRequest, "No appointment IDs given")
+		return
+	}
+	seen := make(map[int64]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		if seen[id] {
+			s.respondError(w, http.StatusBadRequest, "Duplicate appointment ID")
+			return
+		}
+		seen[id] = true
+	}
+
+	if err := s.db.ReorderAppointments(r.Context(), UserID(r.Context()), req.IDs); err != nil {
+		if errors.Is(err, db.ErrAppointmentNotFound) {
+			s.respondError(w, http.StatusNotFound, "Appointment not found")
+			return
+		}
+		s.respondInternalError(w, "Failed to reorder appointments", err)
+		return
+	}
+	// Only the display order changed, which is of no interest to webhook
+	// receivers.
+	s.changes.Publish(UserID(r.Context()), events.Updated, req.IDs...)
+
+	s.respondJSON(w, http.StatusNoContent, nil)
+}
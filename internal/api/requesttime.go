@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// requestTime is a time in a request body, given in RFC 3339, as Unix time
+// in seconds or milliseconds, or, for all-day appointments, as a date like
+// "2024-01-15".
+type requestTime struct {
+	time.Time
+	// DateOnly is set if the value was a date without a time.
+	DateOnly bool
+}
+
+// timeError reports a value of a requestTime in none of the accepted
+// formats.
+type timeError struct {
+	value []byte
+}
+
+func (e *timeError) Error() string {
+	return fmt.Sprintf("invalid time %s: expected RFC 3339, Unix time in seconds or milliseconds, or a date", e.value)
+}
+
+// maxUnixSeconds separates Unix times in seconds from those in
+// milliseconds: as seconds it is in the year 5138, as milliseconds in 1973.
+const maxUnixSeconds = 1e11
+
+func (t *requestTime) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && (b[0] == '-' || b[0] >= '0' && b[0] <= '9') {
+		n, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return &timeError{value: b}
+		}
+		if n >= maxUnixSeconds || n <= -maxUnixSeconds {
+			*t = requestTime{Time: time.UnixMilli(n).UTC()}
+		} else {
+			*t = requestTime{Time: time.Unix(n, 0).UTC()}
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return &timeError{value: b}
+	}
+	if d, err := time.Parse(time.DateOnly, s); err == nil {
+		*t = requestTime{Time: d, DateOnly: true}
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return &timeError{value: b}
+	}
+	*t = requestTime{Time: v}
+	return nil
+}
+
+// MarshalJSON writes the time in RFC 3339, or the date if it was given
+// without a time.
+func (t requestTime) MarshalJSON() ([]byte, error) {
+	if t.DateOnly {
+		return json.Marshal(t.Format(time.DateOnly))
+	}
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
+
+// date returns midnight in loc of the day of t. A date given without a time
+// denotes that day in loc.
+func (t requestTime) date(loc *time.Location) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	if !t.DateOnly {
+		return dateIn(t.In(loc), loc)
+	}
+	return dateIn(t.Time, loc)
+}
+
+// dateIn returns midnight in loc of the calendar date of t, regardless of
+// the location of t.
+func dateIn(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
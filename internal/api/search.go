@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchLimit is the number of search results returned unless the
+// limit parameter asks for a different number.
+const defaultSearchLimit = 50
+
+// handleSearchAppointments returns the appointments whose title or
+// description contains the q parameter, most recent first.
+func (s *Server) handleSearchAppointments(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		s.respondError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	appointments, err := s.db.SearchAppointments(r.Context(), UserID(r.Context()), query, limit)
+	if err != nil {
+		s.respondInternalError(w, "Failed to search appointments", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, localize(r, appointments))
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/recurrence"
+)
+
+// seriesCount is the number of occurrences of a series. Truncated is set
+// if expansion stopped at the occurrence cap, so Count is a lower bound.
+type seriesCount struct {
+	Count     int  `json:"count"`
+	Truncated bool `json:"truncated"`
+}
+
+// handleSeriesCount returns the number of occurrences of a recurring
+// appointment lying entirely within [start, end). A range is required for
+// series without COUNT or UNTIL; otherwise it defaults to the whole series.
+func (s *Server) handleSeriesCount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) || appt.Recurrence == "" {
+		s.respondError(w, http.StatusNotFound, "Series not found")
+		return
+	}
+	rule, err := recurrence.Parse(appt.Recurrence)
+	if err != nil {
+		s.respondInternalError(w, "Failed to parse recurrence", err)
+		return
+	}
+
+	q := r.URL.Query()
+	bounded := rule.Count > 0 || !rule.Until.IsZero()
+	if !bounded && (q.Get("start") == "" || q.Get("end") == "") {
+		s.respondError(w, http.StatusBadRequest, "Start and end are required for unbounded series")
+		return
+	}
+	start, err := parseTimeParam(r, "start", appt.StartTime)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid start time")
+		return
+	}
+	// Without an end, a bounded series is counted to its last occurrence.
+	end, err := parseTimeParam(r, "end", time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid end time")
+		return
+	}
+	if end.Before(start) {
+		s.respondError(w, http.StatusBadRequest, "End time before start time")
+		return
+	}
+
+	occurrences, err := models.ExpandRecurrences(appt, start, end)
+	if err != nil {
+		s.respondInternalError(w, "Failed to expand recurrence", err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, seriesCount{
+		Count:     len(occurrences),
+		Truncated: len(occurrences) >= recurrence.MaxOccurrences,
+	})
+}
+
+// Scopes of deleting an occurrence of a series.
+const (
+	scopeSingle    = "single"
+	scopeFollowing = "following"
+	scopeAll       = "all"
+)
+
+// deleteOccurrences deletes the occurrence of the series appt given by the
+// occurrence parameter, or with scopeFollowing that one and all following
+// ones. It reports whether a response was written; if not, no occurrence
+// would be left and the caller deletes the series as a whole.
+func (s *Server) deleteOccurrences(w http.ResponseWriter, r *http.Request, appt *models.Appointment, scope string) bool {
+	occurrence, err := parseTimeParam(r, "occurrence", time.Time{})
+	if err != nil || occurrence.IsZero() {
+		s.respondError(w, http.StatusBadRequest, "Missing or invalid occurrence, must be the RFC3339 start time of an occurrence")
+		return true
+	}
+
+	var remaining bool
+	if scope == scopeSingle {
+		remaining, err = appt.RemoveOccurrence(occurrence)
+	} else {
+		remaining, err = appt.EndSeriesBefore(occurrence)
+	}
+	switch {
+	case errors.Is(err, models.ErrNotRecurring):
+		s.respondError(w, http.StatusBadRequest, "Scope "+scope+" requires a recurring appointment")
+		return true
+	case errors.Is(err, models.ErrNoOccurrence):
+		s.respondError(w, http.StatusNotFound, "Occurrence not found")
+		return true
+	case err != nil:
+		s.respondInternalError(w, "Failed to delete occurrence", err)
+		return true
+	case !remaining:
+		return false
+	}
+
+	updated, err := s.db.UpdateSeries(r.Context(), appt)
+	if errors.Is(err, db.ErrStaleAppointment) {
+		s.respondError(w, http.StatusPreconditionFailed, "Appointment was modified, fetch it again")
+		return true
+	}
+	if errors.Is(err, db.ErrAppointmentNotFound) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return true
+	}
+	if err != nil {
+		s.respondInternalError(w, "Failed to delete occurrence", err)
+		return true
+	}
+	s.notify(events.Updated, updated)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
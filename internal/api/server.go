@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Run serves the API and sends reminders on addr until SIGINT or SIGTERM is
+// received. It then stops accepting connections and sending reminders,
+// waits up to the configured shutdown timeout for in-flight requests,
+// pending webhooks and emails to finish and closes the database, so SQLite
+// can checkpoint and release its files cleanly.
+func (s *Server) Run(addr string) error {
+	// Per-route timeouts are enforced by the API server, so the connection
+	// level timeouts only need to accommodate the longest of them.
+	t := s.config.Timeouts
+	longest := max(t.Read, t.Write, t.Export, t.Import, t.Poll)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 15 * time.Second,
+		ReadTimeout:       longest,
+		WriteTimeout:      longest + 5*time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	// Waiting for changes would otherwise hold up shutdown until the poll
+	// timeout.
+	srv.RegisterOnShutdown(s.changes.Close)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	remindCtx, cancelReminders := context.WithCancel(context.Background())
+	remindersDone := make(chan struct{})
+	go func() {
+		defer close(remindersDone)
+		s.reminders.Run(remindCtx)
+	}()
+	// stopReminders must be called before webhooks, the mailer and the
+	// database are closed, as a scan may be using them.
+	stopReminders := func() {
+		cancelReminders()
+		<-remindersDone
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		stopReminders()
+		s.db.Close()
+		return fmt.Errorf("failed to start server: %w", err)
+	case sig := <-quit:
+		log.Printf("Received %v, shutting down server...", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+
+	stopReminders()
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("requests still running after %v: %w", s.config.Server.ShutdownTimeout, err)
+	}
+	if hookErr := s.webhooks.Close(ctx); hookErr != nil {
+		err = errors.Join(err, hookErr)
+	}
+	if mailErr := s.mailer.Close(ctx); mailErr != nil {
+		err = errors.Join(err, mailErr)
+	}
+	if closeErr := s.db.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to close database: %w", closeErr))
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("Server exited properly")
+	return nil
+}
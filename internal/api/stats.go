@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// maxDailyHoursRange bounds the range daily hours are returned for, as
+// the response holds an entry for each day.
+const maxDailyHoursRange = 366 * 24 * time.Hour
+
+// dayHours is the time booked on a calendar day.
+type dayHours struct {
+	Date  string  `json:"date"`
+	Hours float64 `json:"hours"`
+}
+
+// handleDailyHours returns the hours booked on each day of the requested
+// range, see parseRange, including days without appointments. Days are
+// calendar days in the request's timezone (see resolveTimezone);
+// appointments spanning midnight count towards both days. Overlapping
+// appointments are counted once; all-day and cancelled appointments are not
+// counted. The range must not exceed 366 days.
+func (s *Server) handleDailyHours(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := s.parseRange(w, r)
+	if !ok {
+		return
+	}
+	if end.Sub(start) > maxDailyHoursRange {
+		s.respondError(w, http.StatusBadRequest, "Range must not exceed 366 days")
+		return
+	}
+	loc := requestLocation(r.Context())
+
+	appointments, err := s.db.ListOverlappingAppointments(r.Context(), UserID(r.Context()), start, end)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	var busy []schedule.Interval
+	for _, a := range appointments {
+		if a.AllDay || a.Status == models.StatusCancelled {
+			continue
+		}
+		iv := schedule.Interval{Start: a.StartTime, End: a.EndTime}
+		if iv.Start.Before(start) {
+			iv.Start = start
+		}
+		if iv.End.After(end) {
+			iv.End = end
+		}
+		busy = append(busy, iv)
+	}
+	booked := make(map[string]time.Duration)
+	for _, iv := range schedule.Merge(busy) {
+		for _, day := range schedule.SplitDays(iv, loc) {
+			booked[day.Start.Format("2006-01-02")] += day.Duration()
+		}
+	}
+
+	days := []dayHours{}
+	for _, day := range schedule.SplitDays(schedule.Interval{Start: start, End: end}, loc) {
+		date := day.Start.Format("2006-01-02")
+		days = append(days, dayHours{Date: date, Hours: booked[date].Hours()})
+	}
+	s.respondJSON(w, http.StatusOK, days)
+}
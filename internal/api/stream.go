@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miku/cali/internal/events"
+	"github.com/miku/cali/internal/webhook"
+)
+
+// streamKeepAlive is how often a comment is sent on an idle stream, so
+// proxies do not close the connection.
+const streamKeepAlive = 15 * time.Second
+
+// handleStream streams the changes to the appointments of the user as
+// server-sent events. Each event is named like the webhook events, e.g.
+// "appointment.created", carries the webhook payload as data and the
+// change sequence number as ID, so a reconnecting client resumes after the
+// last event it saw via the Last-Event-ID header. If changes were missed
+// because they are no longer retained, a "reset" event is sent; the client
+// should then reload its appointments.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	seq := s.changes.Seq()
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			s.respondError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		seq = n
+	}
+
+	// The stream outlives the server's connection timeouts, which are
+	// meant for regular requests.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the events.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Failed to flush event stream: %v", err)
+		return
+	}
+
+	ctx := r.Context()
+	userID := UserID(ctx)
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, streamKeepAlive)
+		changes, err := s.changes.Wait(waitCtx, userID, seq)
+		cancel()
+		switch {
+		case errors.Is(err, events.ErrExpired):
+			seq = s.changes.Seq()
+			_, err = fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", seq)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case err != nil:
+			// The client went away or the server is shutting down.
+			return
+		default:
+			for _, c := range changes {
+				if err = s.writeChange(w, r, c); err != nil {
+					break
+				}
+				seq = c.Seq
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeChange writes a change as server-sent event. Changes published
+// without the appointment, like reordering, are filled in from the
+// database; those of appointments gone since are skipped.
+func (s *Server) writeChange(w http.ResponseWriter, r *http.Request, c events.Change) error {
+	appt := c.Appointment
+	if appt == nil {
+		var err error
+		if appt, err = s.db.GetAppointment(r.Context(), c.AppointmentID); err != nil {
+			return err
+		}
+		if appt == nil {
+			return nil
+		}
+	}
+	event := "appointment." + string(c.Type)
+	data, err := json.Marshal(webhook.Payload{Event: event, Appointment: appt})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, event, data)
+	return err
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// resolveTimezone stores the timezone of the request in its context: the
+// one given by the tz parameter, or else the user's default timezone, or
+// else the server's local timezone. It must run after authentication.
+func (s *Server) resolveTimezone(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loc := time.Local
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			l, err := time.LoadLocation(tz)
+			if err != nil {
+				s.respondError(w, http.StatusBadRequest, "Invalid timezone")
+				return
+			}
+			loc = l
+		} else {
+			user, err := s.db.GetUser(r.Context(), UserID(r.Context()))
+			if err != nil {
+				s.respondInternalError(w, "Failed to get user", err)
+				return
+			}
+			if user != nil && user.Location() != nil {
+				loc = user.Location()
+			}
+		}
+		ctx := context.WithValue(r.Context(), locationKey, loc)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestLocation returns the timezone stored by resolveTimezone, or the
+// server's local timezone.
+func requestLocation(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(locationKey).(*time.Location); ok {
+		return loc
+	}
+	return time.Local
+}
+
+// defaultTimezone returns the name of the timezone of new appointments
+// that do not name one: the user's default timezone, if set.
+func (s *Server) defaultTimezone(ctx context.Context) (string, error) {
+	user, err := s.db.GetUser(ctx, UserID(ctx))
+	if err != nil || user == nil {
+		return "", err
+	}
+	return user.Timezone, nil
+}
+
+// localize returns the appointments with their times in the timezone of
+// the tz parameter, if given. Times are stored in UTC and otherwise
+// returned in the timezone of each appointment. The appointments are
+// copied, as they may be shared with event subscribers.
+func localize(r *http.Request, appts []*models.Appointment) []*models.Appointment {
+	if r.URL.Query().Get("tz") == "" {
+		return appts
+	}
+	loc := requestLocation(r.Context())
+	localized := make([]*models.Appointment, len(appts))
+	for i, a := range appts {
+		localized[i] = a.In(loc)
+	}
+	return localized
+}
+
+// localizeOne is localize for a single appointment.
+func localizeOne(r *http.Request, appt *models.Appointment) *models.Appointment {
+	return localize(r, []*models.Appointment{appt})[0]
+}
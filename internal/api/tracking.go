@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/events"
+)
+
+// checkRequest optionally carries the time of a check-in or check-out. When
+// absent, the current time is used.
+type checkRequest struct {
+	Time *time.Time `json:"time"`
+}
+
+func (s *Server) handleCheckIn(w http.ResponseWriter, r *http.Request) {
+	s.recordActualTime(w, r, true)
+}
+
+func (s *Server) handleCheckOut(w http.ResponseWriter, r *http.Request) {
+	s.recordActualTime(w, r, false)
+}
+
+// recordActualTime sets the actual start (check-in) or actual end
+// (check-out) of an appointment.
+func (s *Server) recordActualTime(w http.ResponseWriter, r *http.Request, checkIn bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid appointment ID")
+		return
+	}
+
+	var req checkRequest
+	// The body is optional.
+	if err := s.readJSON(w, r, &req); err != nil && !errors.Is(err, io.EOF) {
+		s.respondBodyError(w, err)
+		return
+	}
+	at := time.Now().UTC()
+	if req.Time != nil {
+		at = *req.Time
+	}
+
+	appt, err := s.db.GetAppointment(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get appointment", err)
+		return
+	}
+	if appt == nil || appt.UserID != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "Appointment not found")
+		return
+	}
+
+	if checkIn {
+		appt.ActualStart = &at
+	} else {
+		if appt.ActualStart == nil {
+			s.respondError(w, http.StatusConflict, "Appointment has not been checked in")
+			return
+		}
+		appt.ActualEnd = &at
+	}
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.db.SetActualTimes(r.Context(), appt); err != nil {
+		s.respondInternalError(w, "Failed to record time", err)
+		return
+	}
+	s.notify(events.Updated, appt)
+
+	s.respondJSON(w, http.StatusOK, localizeOne(r, appt))
+}
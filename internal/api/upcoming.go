@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultUpcomingLimit is the number of upcoming appointments returned
+// unless the limit parameter asks for a different number.
+const defaultUpcomingLimit = 10
+
+// handleUpcomingAppointments returns the next appointments starting from
+// now, soonest first, sparing clients from computing a range. Cancelled
+// appointments are left out.
+func (s *Server) handleUpcomingAppointments(w http.ResponseWriter, r *http.Request) {
+	limit := defaultUpcomingLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	appointments, err := s.db.ListUpcoming(r.Context(), UserID(r.Context()), time.Now(), limit)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list upcoming appointments", err)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, localize(r, appointments))
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/auth"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/models"
+)
+
+type createUserRequest struct {
+	Username           string   `json:"username"`
+	Password           string   `json:"password"`
+	Timezone           string   `json:"timezone"`
+	Email              string   `json:"email"`
+	EmailNotifications []string `json:"email_notifications"`
+}
+
+// handleCreateUser adds a user with a password. Like login, it needs no
+// token, as there is no other way to create the first user. Unless
+// auth.open_registration is set, that is the only user registering
+// themselves; requests with credentials must be made by one of auth.admins.
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	_, err := r.Cookie(sessionCookie)
+	if !s.config.Auth.OpenRegistration && (r.Header.Get("Authorization") != "" || err == nil) {
+		s.authenticate(http.HandlerFunc(s.createUser)).ServeHTTP(w, r)
+		return
+	}
+	s.createUser(w, r)
+}
+
+// createUser adds a user as described at handleCreateUser, the request
+// being authenticated if registration is closed and it has credentials.
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	create := s.db.CreateUser
+	if !s.config.Auth.OpenRegistration {
+		if id := UserID(r.Context()); id != 0 {
+			admin, err := s.db.GetUser(r.Context(), id)
+			if err != nil {
+				s.respondInternalError(w, "Failed to get user", err)
+				return
+			}
+			if admin == nil || !slices.Contains(s.config.Auth.Admins, admin.Username) {
+				s.respondError(w, http.StatusForbidden, "Only admins can create users")
+				return
+			}
+		} else {
+			create = s.db.CreateFirstUser
+		}
+	}
+
+	var req createUserRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	u := &models.User{
+		Username:           req.Username,
+		Timezone:           req.Timezone,
+		Email:              req.Email,
+		EmailNotifications: req.EmailNotifications,
+	}
+	if err := u.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if errors.Is(err, auth.ErrPasswordTooShort) {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		s.respondInternalError(w, "Failed to hash password", err)
+		return
+	}
+
+	u.PasswordHash = hash
+	if err := create(r.Context(), u); err != nil {
+		if errors.Is(err, db.ErrUsernameExists) {
+			s.respondError(w, http.StatusConflict, "Username already exists")
+			return
+		}
+		if errors.Is(err, db.ErrUsersExist) {
+			s.respondError(w, http.StatusForbidden, "Registration is closed; ask an admin to create your account")
+			return
+		}
+		s.respondInternalError(w, "Failed to create user", err)
+		return
+	}
+	s.emailVerification(u)
+	s.respondJSON(w, http.StatusCreated, u)
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := s.db.GetUser(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+	if user == nil {
+		s.respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	// Email settings are private.
+	if user.ID != UserID(r.Context()) {
+		user.Email, user.EmailNotifications, user.EmailVerified = "", nil, false
+	}
+	s.respondJSON(w, http.StatusOK, user)
+}
+
+// updateUserRequest changes the fields that are set. Empty values clear
+// them.
+type updateUserRequest struct {
+	Timezone           *string   `json:"timezone"`
+	Email              *string   `json:"email"`
+	EmailNotifications *[]string `json:"email_notifications"`
+}
+
+// handleUpdateUser changes the default timezone and email settings of the
+// authenticated user. Other users are not found. A new email address is
+// sent a verification code, see handleVerifyEmail.
+func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if id != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	var req updateUserRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := s.db.GetUser(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+	if user == nil {
+		s.respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if req.Timezone == nil && req.Email == nil && req.EmailNotifications == nil {
+		s.respondJSON(w, http.StatusOK, user)
+		return
+	}
+	if req.Timezone != nil {
+		user.Timezone = *req.Timezone
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.EmailNotifications != nil {
+		user.EmailNotifications = *req.EmailNotifications
+	}
+	if err := user.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.db.UpdateUserSettings(r.Context(), user); err != nil {
+		s.respondInternalError(w, "Failed to update user", err)
+		return
+	}
+	s.emailVerification(user)
+	s.respondJSON(w, http.StatusOK, user)
+}
+
+type verifyEmailRequest struct {
+	Code string `json:"code"`
+}
+
+// handleVerifyEmail verifies the email address of the authenticated user
+// with the code mailed to it. Other users are not found.
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if id != UserID(r.Context()) {
+		s.respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	var req verifyEmailRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := s.db.GetUser(r.Context(), id)
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+	if user == nil {
+		s.respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.Email == "" {
+		s.respondError(w, http.StatusBadRequest, "User has no email address")
+		return
+	}
+	if !auth.CheckEmailCode(s.secret, user.ID, user.Email, req.Code) {
+		s.respondError(w, http.StatusBadRequest, "Invalid verification code")
+		return
+	}
+	if err := s.db.VerifyEmail(r.Context(), user.ID, user.Email); err != nil {
+		if errors.Is(err, db.ErrEmailChanged) {
+			s.respondError(w, http.StatusConflict, "Email address was changed, verify the new one")
+			return
+		}
+		s.respondInternalError(w, "Failed to verify email", err)
+		return
+	}
+	user.EmailVerified = true
+	s.respondJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// indexTemplate and loginTemplate are the templates rendering the calendar
+// and login pages.
+const (
+	indexTemplate = "index.html"
+	loginTemplate = "login.html"
+)
+
+// parseTemplates parses all HTML templates in dir.
+func parseTemplates(dir string) (*template.Template, error) {
+	return template.ParseGlob(filepath.Join(dir, "*.html"))
+}
+
+// template returns the parsed templates. With web.reload_templates they are
+// parsed again on each call, so changes show up without a restart.
+func (s *Server) template() (*template.Template, error) {
+	if s.config.Web.ReloadTemplates {
+		return parseTemplates(s.config.Web.TemplatesDir)
+	}
+	return s.templates, nil
+}
+
+// pageDay is a day of the calendar page with the appointments starting on
+// it. InRange is false for days of adjacent months filling a month grid.
+type pageDay struct {
+	Date         time.Time
+	InRange      bool
+	Today        bool
+	Appointments []*models.Appointment
+}
+
+// calendarPage is the data of the calendar page template.
+type calendarPage struct {
+	Title string
+	// View is "month" or "week".
+	View     string
+	Weekdays []string
+	Weeks    [][]pageDay
+	// Timezone is the name of the zone days and times are shown in.
+	Timezone string
+	// Prev, Next and Today link to the adjacent periods and the current
+	// one.
+	Prev, Next, Today string
+}
+
+// handleIndex renders a month or week calendar of the user's appointments.
+// The view parameter selects "month" (default) or "week", date a day within
+// the period as YYYY-MM-DD, default today, and tz the timezone, default the
+// user's.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	loc := requestLocation(r.Context())
+	view := schedule.Month
+	if v := q.Get("view"); v != "" {
+		parsed, err := schedule.ParseView(v)
+		if err != nil || parsed == schedule.Day {
+			s.respondError(w, http.StatusBadRequest, "Invalid view, must be month or week")
+			return
+		}
+		view = parsed
+	}
+	now := time.Now().In(loc)
+	date := now
+	if v := q.Get("date"); v != "" {
+		d, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid date")
+			return
+		}
+		date = d
+	}
+
+	// Validated when the configuration is loaded.
+	firstDay, _ := schedule.ParseWeekday(s.config.Calendar.FirstDayOfWeek)
+	periodStart := view.Start(date, firstDay)
+	periodEnd := view.Next(periodStart)
+	var grid [][]time.Time
+	if view == schedule.Month {
+		grid = schedule.MonthGrid(periodStart.Year(), periodStart.Month(), loc, firstDay)
+	} else {
+		week := make([]time.Time, 7)
+		for i := range week {
+			week[i] = periodStart.AddDate(0, 0, i)
+		}
+		grid = [][]time.Time{week}
+	}
+	start := grid[0][0]
+	end := grid[len(grid)-1][6].AddDate(0, 0, 1)
+
+	appointments, _, err := s.db.ListAppointments(r.Context(), UserID(r.Context()), start, end, db.Filter{}, maxPageLimit, 0)
+	if err != nil {
+		s.respondInternalError(w, "Failed to list appointments", err)
+		return
+	}
+
+	const layout = "2006-01-02"
+	page := calendarPage{
+		View:     string(view),
+		Timezone: loc.String(),
+	}
+	days := make(map[string]*pageDay)
+	for _, week := range grid {
+		row := make([]pageDay, len(week))
+		for i, d := range week {
+			row[i] = pageDay{
+				Date:    d,
+				InRange: !d.Before(periodStart) && d.Before(periodEnd),
+				Today:   d.Format(layout) == now.Format(layout),
+			}
+		}
+		page.Weeks = append(page.Weeks, row)
+	}
+	for _, week := range page.Weeks {
+		for i := range week {
+			days[week[i].Date.Format(layout)] = &week[i]
+		}
+	}
+	for _, d := range grid[0] {
+		page.Weekdays = append(page.Weekdays, d.Weekday().String()[:3])
+	}
+	for _, a := range appointments {
+		if d, ok := days[appointmentDay(a, loc).Format(layout)]; ok {
+			if !a.AllDay {
+				a.StartTime, a.EndTime = a.StartTime.In(loc), a.EndTime.In(loc)
+			}
+			d.Appointments = append(d.Appointments, a)
+		}
+	}
+
+	link := func(d time.Time) string {
+		v := url.Values{"view": {string(view)}, "date": {d.Format(layout)}}
+		if tz := q.Get("tz"); tz != "" {
+			v.Set("tz", tz)
+		}
+		return "/?" + v.Encode()
+	}
+	if view == schedule.Month {
+		page.Title = periodStart.Format("January 2006")
+		page.Prev = link(periodStart.AddDate(0, -1, 0))
+	} else {
+		page.Title = "Week of " + periodStart.Format("Jan 2, 2006")
+		page.Prev = link(periodStart.AddDate(0, 0, -7))
+	}
+	page.Next, page.Today = link(periodEnd), link(now)
+
+	tmpl, err := s.template()
+	if err != nil {
+		s.respondInternalError(w, "Failed to parse templates", err)
+		return
+	}
+	// Render into a buffer, so a failing template yields an error
+	// response rather than half a page.
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, indexTemplate, page); err != nil {
+		s.respondInternalError(w, "Failed to render page", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// authenticatePage is like authenticate for pages of the web interface,
+// but sends browsers without a session to the login page.
+func (s *Server) authenticatePage(next http.Handler) http.Handler {
+	api := s.authenticate(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			api.ServeHTTP(w, r)
+			return
+		}
+		userID, err := s.sessionUser(r)
+		if err != nil {
+			s.respondInternalError(w, "Failed to get session", err)
+			return
+		}
+		if userID == 0 {
+			http.Redirect(w, r, "/login?"+url.Values{"next": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loginPage is the data of the login page template.
+type loginPage struct {
+	Error    string
+	Username string
+	// Next is where to go after logging in.
+	Next string
+}
+
+// handleLoginPage shows the login form.
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, http.StatusOK, loginPage{Next: localPath(r.URL.Query().Get("next"))})
+}
+
+// handleLoginForm logs in with the submitted login form and continues to
+// the page given by its next field.
+func (s *Server) handleLoginForm(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.Server.MaxBodySize)
+	if err := r.ParseForm(); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid form")
+		return
+	}
+	page := loginPage{Username: r.PostForm.Get("username"), Next: localPath(r.PostForm.Get("next"))}
+	user, err := s.checkLogin(r.Context(), page.Username, r.PostForm.Get("password"))
+	if errors.Is(err, errInvalidLogin) {
+		page.Error = "Invalid username or password."
+		s.renderLogin(w, http.StatusUnauthorized, page)
+		return
+	}
+	if err != nil {
+		s.respondInternalError(w, "Failed to get user", err)
+		return
+	}
+	if err := s.startSession(w, r, user); err != nil {
+		s.respondInternalError(w, "Failed to create session", err)
+		return
+	}
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+// handleLogoutForm ends the session and returns to the login page.
+func (s *Server) handleLogoutForm(w http.ResponseWriter, r *http.Request) {
+	if err := s.endSession(w, r); err != nil {
+		s.respondInternalError(w, "Failed to end session", err)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, status int, page loginPage) {
+	tmpl, err := s.template()
+	if err != nil {
+		s.respondInternalError(w, "Failed to parse templates", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, loginTemplate, page); err != nil {
+		s.respondInternalError(w, "Failed to render page", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// localPath returns p if it is a path on this server, and "/" otherwise,
+// so a login cannot be made to redirect to another site.
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/miku/cali/internal/db"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/webhook"
+)
+
+// maxWebhooks is the number of webhooks a user may subscribe.
+const maxWebhooks = 20
+
+// webhookRequest is the body of a request subscribing a webhook. Without
+// events, all events are subscribed.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.db.ListWebhooks(r.Context(), UserID(r.Context()))
+	if err != nil {
+		s.respondInternalError(w, "Failed to list webhooks", err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	s.respondJSON(w, http.StatusOK, webhooks)
+}
+
+// handleCreateWebhook subscribes a URL to events of the user's
+// appointments. The response includes the secret the payloads are signed
+// with, which is not shown again.
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	h := &models.Webhook{UserID: UserID(r.Context()), URL: req.URL, Events: req.Events}
+	if len(h.Events) == 0 {
+		h.Events = models.WebhookEvents
+	}
+	if err := h.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := s.db.CountWebhooks(r.Context(), h.UserID)
+	if err != nil {
+		s.respondInternalError(w, "Failed to count webhooks", err)
+		return
+	}
+	if n >= maxWebhooks {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d webhooks allowed", maxWebhooks))
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		s.respondInternalError(w, "Failed to generate secret", err)
+		return
+	}
+	h.Secret = hex.EncodeToString(secret)
+	if err := s.db.CreateWebhook(r.Context(), h); err != nil {
+		s.respondInternalError(w, "Failed to create webhook", err)
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, h)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	if err := s.db.DeleteWebhook(r.Context(), id, UserID(r.Context())); err != nil {
+		if errors.Is(err, db.ErrWebhookNotFound) {
+			s.respondError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		s.respondInternalError(w, "Failed to delete webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendWebhooks posts event for each of appts, which belong to the same
+// user, to the configured webhook URLs and the user's webhooks subscribed
+// to it.
+func (s *Server) sendWebhooks(event string, appts ...*models.Appointment) {
+	if len(appts) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	webhooks, err := s.db.ListWebhooks(ctx, appts[0].UserID)
+	if err != nil {
+		// Still deliver to the configured URLs.
+		log.Printf("Webhook %s for user %d: %v", event, appts[0].UserID, err)
+	}
+	var targets []webhook.Target
+	for _, h := range webhooks {
+		if h.Subscribes(event) {
+			targets = append(targets, webhook.Target{URL: h.URL, Secret: h.Secret})
+		}
+	}
+	for _, a := range appts {
+		s.webhooks.Send(event, a, targets...)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/miku/cali/internal/config"
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/schedule"
+)
+
+// defaultWorkingHoursWindow is how far ahead occurrences are checked
+// against the working hours without an end of the window.
+const defaultWorkingHoursWindow = 365 * 24 * time.Hour
+
+// workingHours returns the configured working hours and days evaluated in
+// loc. The configuration has been validated.
+func workingHours(cfg *config.Config, loc *time.Location) *schedule.BookingHours {
+	start, _ := time.Parse("15:04", cfg.WorkingHours.Start)
+	end, _ := time.Parse("15:04", cfg.WorkingHours.End)
+	hours := &schedule.BookingHours{
+		Open:     start.Hour()*60 + start.Minute(),
+		Close:    end.Hour()*60 + end.Minute(),
+		Location: loc,
+	}
+	for _, d := range cfg.WorkingHours.Days {
+		wd, _ := schedule.ParseWeekday(d)
+		hours.Days = append(hours.Days, wd)
+	}
+	return hours
+}
+
+// workingHoursCheckRequest is a recurring appointment to be checked
+// against the working hours, with its occurrences up to Until.
+type workingHoursCheckRequest struct {
+	StartTime  time.Time   `json:"start_time"`
+	EndTime    time.Time   `json:"end_time"`
+	Timezone   string      `json:"timezone"`
+	Recurrence string      `json:"recurrence"`
+	ExDates    []time.Time `json:"exdates"`
+	Until      *time.Time  `json:"until"`
+}
+
+// workingHoursViolation is an occurrence outside the working hours.
+type workingHoursViolation struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason"`
+}
+
+// workingHoursCheck lists the occurrences outside the working hours among
+// those checked. Truncated is set if checking stopped at the occurrence
+// cap before the end of the window.
+type workingHoursCheck struct {
+	Checked    int                     `json:"checked"`
+	Truncated  bool                    `json:"truncated"`
+	Violations []workingHoursViolation `json:"violations"`
+}
+
+// handleCheckWorkingHours expands a recurrence rule and returns the
+// occurrences that lie outside the configured working hours and days in
+// the request's timezone (see resolveTimezone), without storing anything.
+// Occurrences are checked from the start up to until, by default a year
+// later, but no more than maxBookingChecks of them.
+func (s *Server) handleCheckWorkingHours(w http.ResponseWriter, r *http.Request) {
+	var req workingHoursCheckRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
+	}
+	// Validated like a stored appointment, which requires a title.
+	appt := &models.Appointment{
+		Title:      "check",
+		StartTime:  req.StartTime,
+		EndTime:    req.EndTime,
+		Timezone:   req.Timezone,
+		Recurrence: req.Recurrence,
+		ExDates:    req.ExDates,
+		Status:     models.StatusConfirmed,
+	}
+	if err := appt.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if appt.Recurrence == "" {
+		s.respondError(w, http.StatusBadRequest, "Recurrence is required")
+		return
+	}
+	until := appt.StartTime.Add(defaultWorkingHoursWindow)
+	if req.Until != nil {
+		if !req.Until.After(appt.StartTime) {
+			s.respondError(w, http.StatusBadRequest, "Until must be after start time")
+			return
+		}
+		until = *req.Until
+	}
+
+	// One more than checked, to tell whether the window was truncated.
+	occurrences, err := models.NextOccurrences(appt, appt.StartTime, maxBookingChecks+1)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hours := workingHours(s.config, requestLocation(r.Context()))
+	result := workingHoursCheck{Violations: []workingHoursViolation{}}
+	for _, o := range occurrences {
+		if !o.StartTime.Before(until) {
+			break
+		}
+		if result.Checked == maxBookingChecks {
+			result.Truncated = true
+			break
+		}
+		result.Checked++
+		if err := hours.Check(o.StartTime, o.EndTime); err != nil {
+			result.Violations = append(result.Violations, workingHoursViolation{
+				StartTime: o.StartTime,
+				EndTime:   o.EndTime,
+				Reason:    err.Error(),
+			})
+		}
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
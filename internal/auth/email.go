@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+// EmailCode returns the code a user proves to receive mail at the address
+// with. It is derived from the secret, so it need not be stored, and is
+// only valid for the user and the address.
+func EmailCode(secret []byte, userID int64, email string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("email\x00" + strconv.FormatInt(userID, 10) + "\x00" + email))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// CheckEmailCode reports whether code is the EmailCode of the user and
+// address.
+func CheckEmailCode(secret []byte, userID int64, email, code string) bool {
+	return hmac.Equal([]byte(code), []byte(EmailCode(secret, userID, email)))
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MinPasswordLength is the minimum number of characters of a password.
+const MinPasswordLength = 8
+
+// ErrPasswordTooShort is returned for passwords shorter than
+// MinPasswordLength.
+var ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+
+// passwordIterations is the PBKDF2 iteration count of new hashes, as
+// recommended by OWASP for HMAC-SHA256. Stored hashes record their own
+// count, so it can be raised later.
+const passwordIterations = 600000
+
+// HashPassword returns a salted PBKDF2-HMAC-SHA256 hash of a password, in
+// the form "pbkdf2-sha256$<iterations>$<salt>$<key>".
+func HashPassword(password string) (string, error) {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword reports whether password matches a hash returned by
+// HashPassword. A malformed hash matches no password.
+func CheckPassword(hash, password string) bool {
+	key, err := passwordKey(hash, password)
+	if err != nil {
+		return false
+	}
+	want, _ := base64.RawStdEncoding.DecodeString(hash[strings.LastIndex(hash, "$")+1:])
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// passwordKey derives the key of password with the parameters of hash.
+func passwordKey(hash, password string) ([]byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return nil, errors.New("unknown password hash format")
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return nil, errors.New("invalid iteration count")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewSession returns a random session token for a cookie, and its ID,
+// under which the session is stored. Only the ID is stored, so the tokens
+// of sessions cannot be taken from the database.
+func NewSession() (token, id string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, SessionID(token), nil
+}
+
+// SessionID returns the ID of the session with the token.
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
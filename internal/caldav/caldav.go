@@ -0,0 +1,190 @@
+// Package caldav reads and writes the XML bodies of the WebDAV (RFC 4918)
+// and CalDAV (RFC 4791) requests needed to serve calendars to native
+// clients: PROPFIND, calendar-query and calendar-multiget.
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Namespaces of the supported properties.
+const (
+	NSDAV    = "DAV:"
+	NSCalDAV = "urn:ietf:params:xml:ns:caldav"
+	// NSCalendarServer holds getctag, which clients poll to detect changes
+	// to a calendar.
+	NSCalendarServer = "http://calendarserver.org/ns/"
+)
+
+// prefixes are used for the namespaces of properties in responses.
+var prefixes = map[string]string{
+	NSDAV:            "d",
+	NSCalDAV:         "c",
+	NSCalendarServer: "cs",
+}
+
+// Request is a parsed PROPFIND or REPORT body.
+type Request struct {
+	// Root is the name of the root element, e.g. propfind,
+	// calendar-query or calendar-multiget.
+	Root xml.Name
+	// AllProp is set if all properties are requested, explicitly or by an
+	// empty PROPFIND body.
+	AllProp bool
+	Props   []xml.Name
+	// Hrefs lists the resources of a calendar-multiget.
+	Hrefs []string
+	// Start and End bound the time-range filter of a calendar-query. They
+	// are zero if not given.
+	Start, End time.Time
+}
+
+// utcLayout is the layout of time-range bounds.
+const utcLayout = "20060102T150405Z"
+
+// ParseRequest parses a PROPFIND or REPORT body. An empty body is a
+// PROPFIND for all properties.
+func ParseRequest(r io.Reader) (*Request, error) {
+	var (
+		req   = &Request{}
+		dec   = xml.NewDecoder(r)
+		stack []xml.Name
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			switch {
+			case len(stack) == 1:
+				req.Root = t.Name
+			case len(stack) == 2 && t.Name == (xml.Name{Space: NSDAV, Local: "allprop"}):
+				req.AllProp = true
+			case len(stack) == 3 && stack[1] == (xml.Name{Space: NSDAV, Local: "prop"}):
+				req.Props = append(req.Props, t.Name)
+			case t.Name == (xml.Name{Space: NSCalDAV, Local: "time-range"}):
+				for _, attr := range t.Attr {
+					v, err := time.Parse(utcLayout, attr.Value)
+					if err != nil {
+						return nil, fmt.Errorf("invalid time-range %s %q", attr.Name.Local, attr.Value)
+					}
+					switch attr.Name.Local {
+					case "start":
+						req.Start = v
+					case "end":
+						req.End = v
+					}
+				}
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 2 && stack[1] == (xml.Name{Space: NSDAV, Local: "href"}) {
+				req.Hrefs = append(req.Hrefs, strings.TrimSpace(string(t)))
+			}
+		}
+	}
+	if req.Root.Local == "" {
+		req.Root = xml.Name{Space: NSDAV, Local: "propfind"}
+		req.AllProp = true
+	}
+	return req, nil
+}
+
+// Prop is a property of a resource.
+type Prop struct {
+	Name xml.Name
+	// Value is the XML content of the property. It may only use elements
+	// of the namespaces in prefixes, with the respective prefix, and must
+	// be escaped, see Text and Href.
+	Value string
+}
+
+// Response describes a resource in a multistatus response, either with its
+// found and missing properties, or with a status only.
+type Response struct {
+	Href     string
+	Props    []Prop
+	NotFound []xml.Name
+	// Status is set for a resource without properties, e.g. one requested
+	// in a calendar-multiget that does not exist.
+	Status int
+}
+
+// WriteMultistatus writes a 207 Multi-Status response.
+func WriteMultistatus(w http.ResponseWriter, responses []Response) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="` + NSCalDAV + `" xmlns:cs="` + NSCalendarServer + `">`)
+	for _, r := range responses {
+		buf.WriteString("<d:response>" + Href(r.Href))
+		if r.Status != 0 {
+			buf.WriteString(status(r.Status))
+		}
+		if len(r.Props) > 0 {
+			buf.WriteString("<d:propstat><d:prop>")
+			for _, p := range r.Props {
+				writeElement(&buf, p.Name, p.Value)
+			}
+			buf.WriteString("</d:prop>" + status(http.StatusOK) + "</d:propstat>")
+		}
+		if len(r.NotFound) > 0 {
+			buf.WriteString("<d:propstat><d:prop>")
+			for _, name := range r.NotFound {
+				writeElement(&buf, name, "")
+			}
+			buf.WriteString("</d:prop>" + status(http.StatusNotFound) + "</d:propstat>")
+		}
+		buf.WriteString("</d:response>")
+	}
+	buf.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeElement writes an element with the given content. Elements of
+// unknown namespaces declare theirs.
+func writeElement(buf *bytes.Buffer, name xml.Name, value string) {
+	tag, decl := name.Local, ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, decl = "x:"+name.Local, ` xmlns:x="`+Text(name.Space)+`"`
+	}
+	if value == "" {
+		buf.WriteString("<" + tag + decl + "/>")
+		return
+	}
+	buf.WriteString("<" + tag + decl + ">" + value + "</" + tag + ">")
+}
+
+func status(code int) string {
+	return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", code, http.StatusText(code))
+}
+
+// Text escapes s for use as XML character data.
+func Text(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// Href returns a DAV:href element for a path.
+func Href(path string) string {
+	return "<d:href>" + Text(path) + "</d:href>"
+}
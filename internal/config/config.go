@@ -1,31 +1,309 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/miku/cali/internal/schedule"
 	"github.com/spf13/viper"
 )
 
+// What happens to snoozed reminders, see Config.Reminders.Snoozed.
+const (
+	SnoozedSkip  = "skip"
+	SnoozedDefer = "defer"
+)
+
+// Environments the server can run in.
+const (
+	EnvDev  = "dev"
+	EnvProd = "prod"
+)
+
 type Config struct {
+	// Env is "dev" or "prod". In dev, error responses include internal
+	// error details; in prod, only a generic message and incident ID.
+	Env string
+
 	Server struct {
 		Host string
 		Port int
+		// TrustedProxies lists addresses or CIDR ranges of reverse proxies
+		// whose X-Forwarded-For, X-Forwarded-Proto and X-Real-IP headers are
+		// honored.
+		TrustedProxies []string
+		// ShutdownTimeout is how long in-flight requests may take to
+		// finish after a shutdown signal.
+		ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+		// MaxBodySize limits JSON request bodies, in bytes (default 1
+		// MiB). Imports have their own, larger limit.
+		MaxBodySize int64 `mapstructure:"max_body_size"`
+		// MaxConcurrentImports limits how many imports, of calendar
+		// files, batches, archives and backups, run at once across all
+		// users (default 2), sparing the single SQLite writer. Further
+		// imports are answered with 429 Too Many Requests. Zero means
+		// no limit.
+		MaxConcurrentImports int `mapstructure:"max_concurrent_imports"`
+		// RateLimit limits requests per client address, as resolved
+		// with TrustedProxies, to RPS requests per second with bursts of
+		// up to Burst requests (default RPS, at least 1). Zero RPS
+		// disables limiting.
+		RateLimit struct {
+			RPS   float64
+			Burst int
+		} `mapstructure:"rate_limit"`
 	}
 	Database struct {
-		Path string
+		// Driver selects the database backend (default "sqlite3", the
+		// only one supported so far) and DSN the database it opens,
+		// for SQLite by default the file at Path.
+		Driver string
+		DSN    string
+		Path   string
+		// UniqueAppointments enforces a unique index on (user, title,
+		// start time) to reject exact duplicates.
+		UniqueAppointments bool
+		// ConnectAttempts is how often connecting at startup is tried
+		// (default 5), backing off exponentially, but for no longer
+		// than ConnectTimeout in total (default 30s).
+		ConnectAttempts int
+		ConnectTimeout  time.Duration
+		// MaxOpenConns, MaxIdleConns and ConnMaxLifetime configure the
+		// connection pool (defaults 25, 5 and 5m); zero means no limit.
+		// MaxIdleConns defaults to MaxOpenConns if that is lower.
+		// SQLite allows a single writer, so fewer open connections can
+		// avoid "database is locked" errors under concurrent writes.
+		MaxOpenConns    int           `mapstructure:"max_open_conns"`
+		MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+		ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+		// BusyTimeout is how long a connection waits for another one to
+		// release a lock before failing (default 5s).
+		BusyTimeout time.Duration `mapstructure:"busy_timeout"`
+		// ExpansionCacheSize is how many occurrences of recurring
+		// appointments expanded over a range are cached in total
+		// (default 100000), each for up to ExpansionCacheTTL (default
+		// 10m); 0 disables the cache.
+		ExpansionCacheSize int           `mapstructure:"expansion_cache_size"`
+		ExpansionCacheTTL  time.Duration `mapstructure:"expansion_cache_ttl"`
 	}
 	Web struct {
 		TemplatesDir string
 		StaticDir    string
+		// ReloadTemplates parses the templates on each request rather
+		// than once at startup, for working on them.
+		ReloadTemplates bool `mapstructure:"reload_templates"`
+		CORS            struct {
+			// AllowedOrigins lists origins like "https://app.example.com"
+			// allowed to make cross-origin requests, or "*" for any
+			// origin. If empty, no CORS headers are sent.
+			AllowedOrigins []string `mapstructure:"allowed_origins"`
+		}
+	}
+	Auth struct {
+		// Secret signs access tokens. If empty, a random secret is
+		// generated at startup and tokens do not survive a restart.
+		Secret string
+		// TokenTTL is how long an issued token stays valid.
+		TokenTTL time.Duration
+		// SessionTTL is how long a login session of the web interface
+		// lasts (default 168h).
+		SessionTTL time.Duration `mapstructure:"session_ttl"`
+		// OpenRegistration lets anyone create an account. Otherwise only
+		// the first user registers themselves, and further accounts are
+		// created by Admins.
+		OpenRegistration bool `mapstructure:"open_registration"`
+		// Admins lists the usernames allowed to create accounts.
+		Admins []string
+	}
+	Titles struct {
+		// Normalize trims titles and collapses runs of whitespace on
+		// create and update.
+		Normalize bool
+		// TitleCase additionally capitalizes each word of normalized
+		// titles.
+		TitleCase bool
+		// KeepOriginal stores the submitted title alongside the
+		// normalized one.
+		KeepOriginal bool
+	}
+	Timeouts struct {
+		// Read bounds requests fetching data (default 15s), Write those
+		// changing it (default 15s). Export (default 60s) and Import
+		// (default 120s) apply to the calendar and agenda exports and the
+		// iCalendar import, which legitimately take longer. Poll (default
+		// 30s) is how long a request for changes waits for one to occur.
+		Read   time.Duration
+		Write  time.Duration
+		Export time.Duration
+		Import time.Duration
+		Poll   time.Duration
+	}
+	WorkingHours struct {
+		// Start and End bound the working day as "15:04" clock times.
+		Start string
+		End   string
+		// Days lists the working days (default monday to friday). If
+		// empty, every day is a working day.
+		Days []string
+		// Buffer is the free time kept before and after appointments
+		// when suggesting slots (default 0).
+		Buffer time.Duration
+		// SlotStep is the interval at which suggested slots may start
+		// (default 15m, at least 1m).
+		SlotStep time.Duration `mapstructure:"slot_step"`
+	}
+	// Booking restricts when appointments may be created or moved to,
+	// for booking-style deployments. If nothing is set, appointments may
+	// take place at any time.
+	Booking struct {
+		// Open and Close bound the time of day as "15:04" clock times.
+		// Both or neither must be set.
+		Open  string
+		Close string
+		// DaysOfWeek lists the weekdays appointments may fall on, e.g.
+		// ["monday", "tuesday"]. If empty, all days are allowed.
+		DaysOfWeek []string `mapstructure:"days_of_week"`
+		// Timezone is the IANA zone the hours and days are evaluated
+		// in (default UTC).
+		Timezone string
+	}
+	Calendar struct {
+		// FirstDayOfWeek is the weekday month grids start with, e.g.
+		// "monday" or "sunday".
+		FirstDayOfWeek string
+		// DefaultView is the period the appointment list covers when
+		// no range is requested: "day", "week" or "month".
+		DefaultView string
+		// OverlapTolerance lets appointments overlap at their edges by up
+		// to this much without conflicting, e.g. "1m" for meetings that
+		// run over by a rounding minute. For short appointments it is
+		// capped at half their duration. The default of zero rejects any
+		// overlap.
+		OverlapTolerance time.Duration
+	}
+	Export struct {
+		// Locale controls date and time formatting in human-readable
+		// exports, e.g. "en-US" or "de-DE".
+		Locale string
+	}
+	Webhooks struct {
+		// URLs receive a POST for every created, updated and deleted
+		// appointment.
+		URLs []string
+		// Secret, if set, signs payloads with HMAC-SHA256, sent in the
+		// X-Cali-Signature header along with X-Cali-Timestamp; see
+		// package webhook for how to verify them.
+		Secret string
+		// Timeout bounds a single delivery attempt (default 5s).
+		Timeout time.Duration
+	}
+	// SMTP configures the server email notifications are sent through.
+	// Users opt in to them individually.
+	SMTP struct {
+		// Host is the SMTP server. If empty, no email is sent.
+		Host string
+		// Port defaults to 587. Port 465 uses implicit TLS, other ports
+		// STARTTLS if the server offers it. Authentication requires TLS
+		// unless Host is localhost.
+		Port int
+		// Username and Password authenticate with the server, if set.
+		Username string
+		Password string
+		// From is the sender address, e.g. "Cali <cali@example.com>".
+		From string
+		// Timeout bounds sending a single message (default 30s).
+		Timeout time.Duration
+	}
+	Reminders struct {
+		// Interval is how often due reminders are looked for (default
+		// 30s). Each scan also sends the reminders coming due within
+		// Tolerance (default half the interval, at most the interval),
+		// so a reminder is sent up to Tolerance early or up to Interval
+		// minus Tolerance late. Zero sends no reminder early.
+		Interval  time.Duration
+		Tolerance time.Duration
+		// Snoozed decides what happens to reminders that come due while
+		// their user snoozed reminders: "skip" (default) drops them,
+		// "defer" sends them when the snooze ends.
+		Snoozed string
+	}
+	Idempotency struct {
+		// TTL is how long an Idempotency-Key is remembered (default
+		// 24h). Retries after that create the appointment again.
+		TTL time.Duration
+	}
+	Log struct {
+		// Level is the minimum level logged: "debug", "info" (default),
+		// "warn" or "error". Requests are logged at info level.
+		Level string
+		// Format is "json" (default) or "text".
+		Format string
+	}
+	Metrics struct {
+		// Enabled serves Prometheus metrics at /metrics, without
+		// authentication.
+		Enabled bool
 	}
 }
 
 func LoadConfig() (*Config, error) {
+	viper.SetDefault("env", EnvProd)
 	viper.SetDefault("server.host", "127.0.0.1")
 	viper.SetDefault("server.port", 8080)
+	viper.SetDefault("server.shutdown_timeout", "30s")
+	viper.SetDefault("server.rate_limit.rps", 0)
+	viper.SetDefault("server.max_body_size", 1<<20)
+	viper.SetDefault("server.max_concurrent_imports", 2)
+	viper.SetDefault("database.driver", "sqlite3")
 	viper.SetDefault("database.path", "./cali.db")
+	viper.SetDefault("database.uniqueappointments", false)
+	viper.SetDefault("database.connectattempts", 5)
+	viper.SetDefault("database.connecttimeout", "30s")
+	viper.SetDefault("database.max_open_conns", 25)
+	viper.SetDefault("database.conn_max_lifetime", "5m")
+	viper.SetDefault("database.busy_timeout", "5s")
+	viper.SetDefault("database.expansion_cache_size", 100000)
+	viper.SetDefault("database.expansion_cache_ttl", "10m")
 	viper.SetDefault("web.templatesdir", "./web/templates")
 	viper.SetDefault("web.staticdir", "./web/static")
+	viper.SetDefault("web.reload_templates", false)
+	viper.SetDefault("auth.secret", "")
+	viper.SetDefault("auth.tokenttl", "24h")
+	viper.SetDefault("auth.session_ttl", "168h")
+	viper.SetDefault("auth.open_registration", false)
+	viper.SetDefault("auth.admins", []string{})
+	viper.SetDefault("titles.normalize", false)
+	viper.SetDefault("titles.titlecase", false)
+	viper.SetDefault("titles.keeporiginal", false)
+	viper.SetDefault("timeouts.read", "15s")
+	viper.SetDefault("timeouts.write", "15s")
+	viper.SetDefault("timeouts.export", "60s")
+	viper.SetDefault("timeouts.import", "120s")
+	viper.SetDefault("timeouts.poll", "30s")
+	viper.SetDefault("workinghours.start", "09:00")
+	viper.SetDefault("workinghours.end", "17:00")
+	viper.SetDefault("workinghours.buffer", "0s")
+	viper.SetDefault("workinghours.slot_step", "15m")
+	viper.SetDefault("workinghours.days", []string{"monday", "tuesday", "wednesday", "thursday", "friday"})
+	viper.SetDefault("calendar.firstdayofweek", "monday")
+	viper.SetDefault("calendar.defaultview", "day")
+	viper.SetDefault("calendar.overlaptolerance", "0s")
+	viper.SetDefault("export.locale", "en-GB")
+	viper.SetDefault("webhooks.timeout", "5s")
+	viper.SetDefault("smtp.port", 587)
+	viper.SetDefault("smtp.timeout", "30s")
+	viper.SetDefault("reminders.interval", "30s")
+	viper.SetDefault("reminders.snoozed", SnoozedSkip)
+	viper.SetDefault("idempotency.ttl", "24h")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "json")
+	viper.SetDefault("metrics.enabled", false)
 
 	// Look for config in standard locations
 	viper.SetConfigName("config")
@@ -46,6 +324,15 @@ func LoadConfig() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, err
 	}
+	if !viper.IsSet("reminders.tolerance") {
+		config.Reminders.Tolerance = config.Reminders.Interval / 2
+	}
+	if !viper.IsSet("database.max_idle_conns") {
+		config.Database.MaxIdleConns = 5
+		if n := config.Database.MaxOpenConns; n > 0 && n < 5 {
+			config.Database.MaxIdleConns = n
+		}
+	}
 
 	// Ensure database path is absolute
 	if !filepath.IsAbs(config.Database.Path) {
@@ -55,6 +342,247 @@ func LoadConfig() (*Config, error) {
 		}
 		config.Database.Path = absPath
 	}
+	if !viper.IsSet("database.dsn") {
+		config.Database.DSN = config.Database.Path
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
+
+// Validate checks the configuration for values that would otherwise only
+// fail once the server runs. Errors name the offending setting.
+func (c *Config) Validate() error {
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid server.port %d: must be between 1 and 65535", c.Server.Port)
+	}
+	for _, p := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(p); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(p); err != nil {
+			return fmt.Errorf("invalid server.trustedproxies entry %q: expected IP address or CIDR", p)
+		}
+	}
+
+	if c.Env != EnvDev && c.Env != EnvProd {
+		return fmt.Errorf("invalid env %q: expected %q or %q", c.Env, EnvDev, EnvProd)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return fmt.Errorf("invalid log.level %q: expected debug, info, warn or error", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		return fmt.Errorf("invalid log.format %q: expected json or text", c.Log.Format)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("invalid server.shutdown_timeout %v: must be positive", c.Server.ShutdownTimeout)
+	}
+	if c.Server.MaxBodySize <= 0 {
+		return fmt.Errorf("invalid server.max_body_size %d: must be positive", c.Server.MaxBodySize)
+	}
+	if c.Server.MaxConcurrentImports < 0 {
+		return fmt.Errorf("invalid server.max_concurrent_imports %d: must not be negative", c.Server.MaxConcurrentImports)
+	}
+	if c.Server.RateLimit.RPS < 0 {
+		return fmt.Errorf("invalid server.rate_limit.rps %v: must not be negative", c.Server.RateLimit.RPS)
+	}
+	if c.Server.RateLimit.Burst < 0 {
+		return fmt.Errorf("invalid server.rate_limit.burst %d: must not be negative", c.Server.RateLimit.Burst)
+	}
+	if c.Database.ConnectAttempts < 1 {
+		return fmt.Errorf("invalid database.connectattempts %d: must be at least 1", c.Database.ConnectAttempts)
+	}
+	if c.Database.Driver != "sqlite3" {
+		return fmt.Errorf("invalid database.driver %q: must be sqlite3", c.Database.Driver)
+	}
+	if c.Database.DSN == "" {
+		return fmt.Errorf("invalid database.dsn: must not be empty")
+	}
+	if c.Database.ConnectTimeout <= 0 {
+		return fmt.Errorf("invalid database.connecttimeout %v: must be positive", c.Database.ConnectTimeout)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid database.max_open_conns %d or database.max_idle_conns %d: must not be negative",
+			c.Database.MaxOpenConns, c.Database.MaxIdleConns)
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return fmt.Errorf("invalid database.max_idle_conns %d: must not exceed database.max_open_conns %d",
+			c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		return fmt.Errorf("invalid database.conn_max_lifetime %v: must not be negative", c.Database.ConnMaxLifetime)
+	}
+	if c.Database.BusyTimeout < 0 {
+		return fmt.Errorf("invalid database.busy_timeout %v: must not be negative", c.Database.BusyTimeout)
+	}
+	if c.Database.ExpansionCacheSize < 0 {
+		return fmt.Errorf("invalid database.expansion_cache_size %d: must not be negative", c.Database.ExpansionCacheSize)
+	}
+	if c.Database.ExpansionCacheSize > 0 && c.Database.ExpansionCacheTTL <= 0 {
+		return fmt.Errorf("invalid database.expansion_cache_ttl %v: must be positive", c.Database.ExpansionCacheTTL)
+	}
+	for name, d := range map[string]time.Duration{
+		"read":   c.Timeouts.Read,
+		"write":  c.Timeouts.Write,
+		"export": c.Timeouts.Export,
+		"import": c.Timeouts.Import,
+		"poll":   c.Timeouts.Poll,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("invalid timeouts.%s %v: must be positive", name, d)
+		}
+	}
+
+	start, err := time.Parse("15:04", c.WorkingHours.Start)
+	if err != nil {
+		return fmt.Errorf("invalid workinghours.start %q: expected HH:MM", c.WorkingHours.Start)
+	}
+	end, err := time.Parse("15:04", c.WorkingHours.End)
+	if err != nil {
+		return fmt.Errorf("invalid workinghours.end %q: expected HH:MM", c.WorkingHours.End)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("workinghours.end must be after workinghours.start")
+	}
+	if c.WorkingHours.Buffer < 0 {
+		return fmt.Errorf("invalid workinghours.buffer %v: must not be negative", c.WorkingHours.Buffer)
+	}
+	if c.WorkingHours.SlotStep < schedule.MinSlotStep {
+		return fmt.Errorf("invalid workinghours.slot_step %v: must be at least %v", c.WorkingHours.SlotStep, schedule.MinSlotStep)
+	}
+	for _, d := range c.WorkingHours.Days {
+		if _, err := schedule.ParseWeekday(d); err != nil {
+			return fmt.Errorf("invalid workinghours.days: %w", err)
+		}
+	}
+
+	if err := c.validateBooking(); err != nil {
+		return err
+	}
+
+	if _, err := schedule.ParseWeekday(c.Calendar.FirstDayOfWeek); err != nil {
+		return fmt.Errorf("invalid calendar.firstdayofweek: %w", err)
+	}
+	if _, err := schedule.ParseView(c.Calendar.DefaultView); err != nil {
+		return fmt.Errorf("invalid calendar.defaultview: %w", err)
+	}
+	if c.Calendar.OverlapTolerance < 0 {
+		return fmt.Errorf("invalid calendar.overlaptolerance %v: must not be negative", c.Calendar.OverlapTolerance)
+	}
+
+	for _, o := range c.Web.CORS.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		parsed, err := url.Parse(o)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" {
+			return fmt.Errorf("invalid web.cors.allowed_origins entry %q: expected scheme://host[:port] or *", o)
+		}
+	}
+	for _, u := range c.Webhooks.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid webhook url %q: expected http or https URL", u)
+		}
+	}
+	if c.Webhooks.Timeout <= 0 {
+		return fmt.Errorf("invalid webhooks.timeout %v: must be positive", c.Webhooks.Timeout)
+	}
+	if err := c.validateSMTP(); err != nil {
+		return err
+	}
+	if c.Reminders.Interval <= 0 {
+		return fmt.Errorf("invalid reminders.interval %v: must be positive", c.Reminders.Interval)
+	}
+	if c.Reminders.Tolerance < 0 || c.Reminders.Tolerance > c.Reminders.Interval {
+		return fmt.Errorf("invalid reminders.tolerance %v: must be between 0 and reminders.interval %v",
+			c.Reminders.Tolerance, c.Reminders.Interval)
+	}
+	if c.Reminders.Snoozed != SnoozedSkip && c.Reminders.Snoozed != SnoozedDefer {
+		return fmt.Errorf("invalid reminders.snoozed %q: expected %q or %q", c.Reminders.Snoozed, SnoozedSkip, SnoozedDefer)
+	}
+	if c.Auth.SessionTTL <= 0 {
+		return fmt.Errorf("invalid auth.session_ttl %v: must be positive", c.Auth.SessionTTL)
+	}
+	if c.Idempotency.TTL <= 0 {
+		return fmt.Errorf("invalid idempotency.ttl %v: must be positive", c.Idempotency.TTL)
+	}
+
+	for _, d := range []struct{ name, path string }{
+		{"web.templatesdir", c.Web.TemplatesDir},
+		{"web.staticdir", c.Web.StaticDir},
+	} {
+		info, err := os.Stat(d.path)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid %s %q: not a directory", d.name, d.path)
+		}
+	}
+	// SQLite creates the database and its write-ahead log next to each
+	// other, so the directory must be writable, not just the file.
+	if c.Database.DSN == c.Database.Path {
+		f, err := os.CreateTemp(filepath.Dir(c.Database.Path), ".cali-write-test-*")
+		if err != nil {
+			return fmt.Errorf("invalid database.path %q: directory not writable: %w", c.Database.Path, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	return nil
+}
+
+// validateSMTP checks the smtp section, which is only used if a host is
+// set.
+func (c *Config) validateSMTP() error {
+	m := c.SMTP
+	if m.Host == "" {
+		return nil
+	}
+	if m.Port < 1 || m.Port > 65535 {
+		return fmt.Errorf("invalid smtp.port %d: must be between 1 and 65535", m.Port)
+	}
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid smtp.from %q: %w", m.From, err)
+	}
+	if m.Timeout <= 0 {
+		return fmt.Errorf("invalid smtp.timeout %v: must be positive", m.Timeout)
+	}
+	return nil
+}
+
+// validateBooking checks the booking section, which is optional as a whole.
+func (c *Config) validateBooking() error {
+	b := c.Booking
+	if (b.Open == "") != (b.Close == "") {
+		return fmt.Errorf("invalid booking: open and close must be set together")
+	}
+	if b.Open != "" {
+		start, err := time.Parse("15:04", b.Open)
+		if err != nil {
+			return fmt.Errorf("invalid booking.open %q: expected HH:MM", b.Open)
+		}
+		end, err := time.Parse("15:04", b.Close)
+		if err != nil {
+			return fmt.Errorf("invalid booking.close %q: expected HH:MM", b.Close)
+		}
+		if !end.After(start) {
+			return fmt.Errorf("booking.close must be after booking.open")
+		}
+	}
+	for _, d := range b.DaysOfWeek {
+		if _, err := schedule.ParseWeekday(d); err != nil {
+			return fmt.Errorf("invalid booking.days_of_week: %w", err)
+		}
+	}
+	if _, err := time.LoadLocation(b.Timezone); err != nil {
+		return fmt.Errorf("invalid booking.timezone %q: %w", b.Timezone, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/miku/cali/internal/models"
+)
+
+var (
+	// ErrAttendeeExists is returned when storing an attendee already
+	// invited to the appointment.
+	ErrAttendeeExists = errors.New("attendee already invited")
+	// ErrInvitationNotFound is returned when a user is not invited to an
+	// appointment, or it was deleted.
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+// InviteAttendee adds an attendee to an appointment of the user, linked to
+// the user with at.UserID if it is set. An empty response status defaults
+// to needs-action. It returns ErrAppointmentNotFound if there is no such
+// appointment and ErrAttendeeExists if the email is already invited.
+func (d *Database) InviteAttendee(ctx context.Context, appointmentID, userID int64, at *models.Attendee) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE appointments SET `+touched+`
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, appointmentID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update appointment: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrAppointmentNotFound
+	}
+
+	err = insertAttendees(ctx, tx, &models.Appointment{ID: appointmentID, Attendees: []models.Attendee{*at}})
+	if err != nil {
+		return err
+	}
+	if at.ResponseStatus == "" {
+		at.ResponseStatus = models.ResponseNeedsAction
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit invitation: %w", err)
+	}
+	return nil
+}
+
+// RespondToInvitation sets the response status of the user to an
+// appointment they are invited to. It returns ErrInvitationNotFound if
+// there is no such invitation.
+func (d *Database) RespondToInvitation(ctx context.Context, appointmentID, userID int64, status string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE appointment_attendees SET response_status = ?
+        WHERE appointment_id = ? AND user_id = ?
+        AND appointment_id IN (SELECT id FROM appointments WHERE deleted_at IS NULL)`,
+		status, appointmentID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to respond to invitation: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrInvitationNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE appointments SET `+touched+` WHERE id = ?`, appointmentID); err != nil {
+		return fmt.Errorf("failed to update appointment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit response: %w", err)
+	}
+	return nil
+}
+
+// GetInvitation returns an appointment the user is invited to, or nil if
+// there is no such invitation.
+func (d *Database) GetInvitation(ctx context.Context, appointmentID, userID int64) (*models.Appointment, error) {
+	invitations, err := d.listInvitations(ctx, `AND id = ?`, userID, appointmentID)
+	if err != nil || len(invitations) == 0 {
+		return nil, err
+	}
+	return invitations[0], nil
+}
+
+// ListInvitations returns the appointments the user is invited to, ordered
+// by start time.
+func (d *Database) ListInvitations(ctx context.Context, userID int64) ([]*models.Appointment, error) {
+	return d.listInvitations(ctx, ``, userID)
+}
+
+func (d *Database) listInvitations(ctx context.Context, where string, userID int64, args ...interface{}) ([]*models.Appointment, error) {
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments
+        WHERE deleted_at IS NULL
+        AND id IN (SELECT appointment_id FROM appointment_attendees WHERE user_id = ?)
+        `+where+`
+        ORDER BY start_time, id`, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []*models.Appointment{}
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		invitations = append(invitations, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invitations: %w", err)
+	}
+	return invitations, nil
+}
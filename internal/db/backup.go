@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/recurrence"
+)
+
+// ErrForeignAppointment is returned when restoring an appointment whose ID
+// is taken by an appointment of another user.
+var ErrForeignAppointment = errors.New("appointment ID belongs to another user")
+
+// RestoreBackup stores appointments of the user exactly as given, including
+// their IDs, timestamps and attendees, in a single transaction. An
+// appointment whose ID exists is replaced, one without an ID or with an
+// unknown one is inserted. It returns the inserted and the replaced
+// appointments. Nothing is stored if an error is returned.
+func (d *Database) RestoreBackup(ctx context.Context, userID int64, appointments []*models.Appointment) (inserted, replaced []*models.Appointment, err error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, a := range appointments {
+		a.UserID = userID
+		exists, err := upsertAppointment(ctx, tx, a)
+		if err != nil {
+			return nil, nil, err
+		}
+		if exists {
+			replaced = append(replaced, a)
+		} else {
+			inserted = append(inserted, a)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit restore: %w", err)
+	}
+	return inserted, replaced, nil
+}
+
+// upsertAppointment stores a with its ID, replacing the appointment with
+// that ID, and reports whether there was one.
+func upsertAppointment(ctx context.Context, tx *sql.Tx, a *models.Appointment) (bool, error) {
+	var exists bool
+	if a.ID != 0 {
+		var owner int64
+		err := tx.QueryRowContext(ctx, `SELECT user_id FROM appointments WHERE id = ?`, a.ID).Scan(&owner)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return false, fmt.Errorf("failed to check appointment %d: %w", a.ID, err)
+		case owner != a.UserID:
+			return false, fmt.Errorf("appointment %d: %w", a.ID, ErrForeignAppointment)
+		default:
+			exists = true
+		}
+	}
+
+	if a.Slug == "" {
+		slug, err := availableSlug(ctx, tx, a.UserID, models.Slugify(a.Title), a.ID)
+		if err != nil {
+			return false, err
+		}
+		a.Slug = slug
+	}
+	var taken bool
+	err := tx.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM appointments WHERE user_id = ? AND slug = ? AND id != ?)`,
+		a.UserID, a.Slug, a.ID).Scan(&taken)
+	if err != nil {
+		return false, fmt.Errorf("failed to check slug: %w", err)
+	}
+	if taken {
+		return false, fmt.Errorf("appointment %q: %w", a.Slug, ErrSlugExists)
+	}
+
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	if a.UpdatedAt.IsZero() {
+		a.UpdatedAt = a.CreatedAt
+	}
+	var id interface{}
+	if a.ID != 0 {
+		id = a.ID
+	}
+	// Store UTC, so timestamps compare correctly as text.
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO appointments (
+            id, user_id, title, original_title, slug, uid, description, location,
+            conference_url, category, color, start_time, end_time, all_day,
+            timezone, recurrence, exdates, status, actual_start, actual_end,
+            sort_order, created_at, updated_at, deleted_at, reminder_channels
+        ) VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''),
+                  ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
+        ON CONFLICT (id) DO UPDATE SET
+            title = excluded.title,
+            original_title = excluded.original_title,
+            slug = excluded.slug,
+            uid = excluded.uid,
+            description = excluded.description,
+            location = excluded.location,
+            conference_url = excluded.conference_url,
+            category = excluded.category,
+            color = excluded.color,
+            start_time = excluded.start_time,
+            end_time = excluded.end_time,
+            all_day = excluded.all_day,
+            timezone = excluded.timezone,
+            recurrence = excluded.recurrence,
+            exdates = excluded.exdates,
+            status = excluded.status,
+            actual_start = excluded.actual_start,
+            actual_end = excluded.actual_end,
+            sort_order = excluded.sort_order,
+            created_at = excluded.created_at,
+            updated_at = excluded.updated_at,
+            deleted_at = excluded.deleted_at,
+            reminder_channels = excluded.reminder_channels,
+            version = appointments.version + 1
+        RETURNING id`,
+		id,
+		a.UserID,
+		a.Title,
+		a.OriginalTitle,
+		a.Slug,
+		a.UID,
+		a.Description,
+		a.Place,
+		a.ConferenceURL,
+		a.Category,
+		a.Color,
+		a.StartTime.UTC(),
+		a.EndTime.UTC(),
+		a.AllDay,
+		a.Timezone,
+		a.Recurrence,
+		recurrence.FormatDates(a.ExDates),
+		a.Status,
+		utcPtr(a.ActualStart),
+		utcPtr(a.ActualEnd),
+		a.SortOrder,
+		a.CreatedAt.UTC(),
+		a.UpdatedAt.UTC(),
+		utcPtr(a.DeletedAt),
+		strings.Join(a.ReminderChannels, ","),
+	).Scan(&a.ID)
+	if err := appointmentUniqueError(err); err != nil {
+		return false, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to restore appointment: %w", err)
+	}
+
+	if err := replaceAttendees(ctx, tx, a); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// utcPtr returns t in UTC, or nil if t is nil.
+func utcPtr(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
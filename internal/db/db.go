@@ -1,199 +1,2030 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/miku/cali/internal/models"
+	"github.com/miku/cali/internal/recurrence"
+)
+
+var (
+	// ErrDuplicateAppointment is returned when the optional unique index on
+	// (user_id, title, start_time) rejects an insert or update.
+	ErrDuplicateAppointment = errors.New("duplicate appointment")
+	// ErrUIDExists is returned when another appointment of the user has the
+	// iCalendar UID of an appointment being stored.
+	ErrUIDExists = errors.New("uid already exists")
+	// ErrAppointmentNotFound is returned when an appointment does not exist
+	// or belongs to another user.
+	ErrAppointmentNotFound = errors.New("appointment not found")
+	// ErrStaleAppointment is returned when a conditional update finds the
+	// appointment modified since the version the client last saw.
+	ErrStaleAppointment = errors.New("appointment was modified")
+	// ErrSlugExists is returned when storing an appointment whose slug is
+	// already used by another appointment of the user.
+	ErrSlugExists = errors.New("slug already exists")
+	// ErrMergeRecurring is returned when merging recurring appointments,
+	// whose occurrences cannot be combined into one range.
+	ErrMergeRecurring = errors.New("recurring appointments cannot be merged")
+	// ErrUsernameExists is returned when creating a user whose name is
+	// already taken.
+	ErrUsernameExists = errors.New("username already exists")
+	// ErrUsersExist is returned when creating the first user of a
+	// database that already has users.
+	ErrUsersExist = errors.New("users already exist")
+	// ErrEmailChanged is returned when verifying an email address the
+	// user no longer has.
+	ErrEmailChanged = errors.New("email address changed")
 )
 
 type Database struct {
 	db *sql.DB
+	// overlapTolerance is how much appointments may overlap at their
+	// edges without conflicting.
+	overlapTolerance time.Duration
+	// expansions caches the occurrences of recurring appointments, nil
+	// if disabled.
+	expansions *expansionCache
+}
+
+// Options configure database connections. MaxOpenConns, MaxIdleConns and
+// ConnMaxLifetime configure the connection pool, see the methods of sql.DB
+// of the same names; zero values mean no limit.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// BusyTimeout is how long a connection waits for a lock held by
+	// another connection before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// OverlapTolerance lets appointments overlap at their edges by up to
+	// this much without conflicting.
+	OverlapTolerance time.Duration
+	// ExpansionCacheSize is how many occurrences of recurring appointments
+	// expanded over a range are cached in total, each for up to
+	// ExpansionCacheTTL; zero disables the cache.
+	ExpansionCacheSize int
+	ExpansionCacheTTL  time.Duration
+	// Driver selects the database backend; empty means DriverSQLite, the
+	// only one supported so far.
+	Driver string
+}
+
+// New opens the database with the data source name dsn, for SQLite its
+// path, using the driver selected in opts. Every SQLite connection uses
+// write-ahead logging, so readers do not block the writer, waits up to the
+// busy timeout for locks, and enforces foreign keys. Transactions take the
+// write lock when they begin: a transaction that reads first and upgrades
+// its lock later fails at once if another connection wrote in between,
+// regardless of the busy timeout. These settings are connection parameters
+// rather than PRAGMA statements, which would only affect the single pooled
+// connection they happen to run on.
+func New(dsn string, opts Options) (*Database, error) {
+	switch opts.Driver {
+	case "", DriverSQLite:
+		dsn = sqliteDSN(dsn, opts.BusyTimeout)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", opts.Driver)
+	}
+	db, err := sql.Open(DriverSQLite, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	// Verify connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	d := &Database{db: db, overlapTolerance: opts.OverlapTolerance}
+	if opts.ExpansionCacheSize > 0 {
+		d.expansions = newExpansionCache(opts.ExpansionCacheSize, opts.ExpansionCacheTTL)
+	}
+	return d, nil
+}
+
+// Stats returns connection pool statistics.
+func (d *Database) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+
+// Connect opens the database like New, retrying while it is unavailable,
+// e.g. because a volume is not mounted yet. Waits between attempts start
+// at half a second and double each time. Connect gives up after attempts
+// tries, or when the next wait would exceed timeout in total.
+func Connect(dsn string, opts Options, attempts int, timeout time.Duration) (*Database, error) {
+	var (
+		deadline = time.Now().Add(timeout)
+		wait     = 500 * time.Millisecond
+	)
+	for attempt := 1; ; attempt++ {
+		d, err := New(dsn, opts)
+		if err == nil {
+			return d, nil
+		}
+		if attempt >= attempts || time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("Database not available (attempt %d of %d): %v; retrying in %v", attempt, attempts, err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// SetUniqueAppointments creates or drops the unique index on (user_id,
+// title, start_time). Before creating the index, existing duplicates are
+// looked up and reported, so the caller gets a list of offending rows
+// instead of an opaque constraint error.
+func (d *Database) SetUniqueAppointments(enabled bool) error {
+	if !enabled {
+		if _, err := d.db.Exec(`DROP INDEX IF EXISTS idx_appointments_unique`); err != nil {
+			return fmt.Errorf("failed to drop unique index: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+        SELECT user_id, title, start_time, COUNT(*)
+        FROM appointments
+        WHERE deleted_at IS NULL
+        GROUP BY user_id, title, start_time
+        HAVING COUNT(*) > 1`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []string
+	for rows.Next() {
+		var (
+			userID    int64
+			title     string
+			startTime time.Time
+			count     int
+		)
+		if err := rows.Scan(&userID, &title, &startTime, &count); err != nil {
+			return fmt.Errorf("failed to scan duplicate: %w", err)
+		}
+		conflicts = append(conflicts, fmt.Sprintf("user %d: %q at %s (%d rows)",
+			userID, title, startTime.Format(time.RFC3339), count))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating duplicates: %w", err)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("cannot create unique index, found %d duplicate appointment(s):\n  %s",
+			len(conflicts), strings.Join(conflicts, "\n  "))
+	}
+
+	_, err = d.db.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_unique
+        ON appointments (user_id, title, start_time) WHERE deleted_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to create unique index: %w", err)
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err is a SQLite unique or primary key
+// constraint error.
+func isUniqueViolation(err error) bool {
+	return uniqueViolation(err) != ""
+}
+
+// appointmentUniqueError returns the error for a violation of one of the
+// unique constraints on appointments and their attendees, or nil if err
+// is no such violation.
+func appointmentUniqueError(err error) error {
+	switch columns := uniqueViolation(err); {
+	case columns == "":
+		return nil
+	case strings.HasPrefix(columns, "appointment_attendees."):
+		return ErrAttendeeExists
+	case strings.HasSuffix(columns, ".slug"):
+		return ErrSlugExists
+	case strings.HasSuffix(columns, ".uid"):
+		return ErrUIDExists
+	default:
+		return ErrDuplicateAppointment
+	}
+}
+
+// appointmentColumns lists the columns read by scanAppointment, in order.
+// Attendees are aggregated into a JSON array, ordered by email.
+const appointmentColumns = `id, user_id, title, COALESCE(original_title, ''), COALESCE(slug, ''), COALESCE(uid, ''), description,
+               COALESCE(location, ''), COALESCE(conference_url, ''), COALESCE(category, ''), COALESCE(color, ''),
+               start_time, end_time,
+               all_day, COALESCE(timezone, ''), COALESCE(recurrence, ''), COALESCE(exdates, ''), status, actual_start, actual_end,
+               sort_order, created_at, updated_at, version, deleted_at,
+               COALESCE(merged_into, 0), COALESCE(merged_from, ''), COALESCE(reminder_channels, ''),
+               ` + attendeesColumn
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAppointment reads a row selected with appointmentColumns.
+func scanAppointment(row rowScanner) (*models.Appointment, error) {
+	var (
+		a                      = &models.Appointment{}
+		actualStart, actualEnd sql.NullTime
+		deletedAt              sql.NullTime
+		exDates, attendees     string
+		mergedFrom, channels   string
+	)
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.Title,
+		&a.OriginalTitle,
+		&a.Slug,
+		&a.UID,
+		&a.Description,
+		&a.Place,
+		&a.ConferenceURL,
+		&a.Category,
+		&a.Color,
+		&a.StartTime,
+		&a.EndTime,
+		&a.AllDay,
+		&a.Timezone,
+		&a.Recurrence,
+		&exDates,
+		&a.Status,
+		&actualStart,
+		&actualEnd,
+		&a.SortOrder,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+		&a.Version,
+		&deletedAt,
+		&a.MergedInto,
+		&mergedFrom,
+		&channels,
+		&attendees,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if a.ExDates, err = recurrence.ParseDates(exDates); err != nil {
+		return nil, fmt.Errorf("failed to decode exdates: %w", err)
+	}
+	if err := json.Unmarshal([]byte(attendees), &a.Attendees); err != nil {
+		return nil, fmt.Errorf("failed to decode attendees: %w", err)
+	}
+	if mergedFrom != "" {
+		for _, v := range strings.Split(mergedFrom, ",") {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode merged_from: %w", err)
+			}
+			a.MergedFrom = append(a.MergedFrom, id)
+		}
+	}
+	if channels != "" {
+		a.ReminderChannels = strings.Split(channels, ",")
+	}
+	if len(a.Attendees) == 0 {
+		a.Attendees = nil
+	}
+	if actualStart.Valid {
+		a.ActualStart = &actualStart.Time
+	}
+	if actualEnd.Valid {
+		a.ActualEnd = &actualEnd.Time
+	}
+	if deletedAt.Valid {
+		a.DeletedAt = &deletedAt.Time
+	}
+	a.LocalizeTimes()
+	return a, nil
+}
+
+// userColumns lists the columns scanned by getUser.
+const userColumns = `id, username, COALESCE(password_hash, ''), COALESCE(timezone, ''),
+        COALESCE(email, ''), COALESCE(email_notifications, ''), email_verified_at IS NOT NULL, created_at`
+
+// getUser returns the user matching a condition on the users table, or nil
+// if there is none.
+func (d *Database) getUser(ctx context.Context, where string, arg interface{}) (*models.User, error) {
+	var (
+		u             = &models.User{}
+		notifications string
+	)
+	err := d.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE `+where, arg).Scan(
+		&u.ID, &u.Username, &u.PasswordHash, &u.Timezone, &u.Email, &notifications, &u.EmailVerified, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if notifications != "" {
+		u.EmailNotifications = strings.Split(notifications, ",")
+	}
+	return u, nil
+}
+
+// GetUserByUsername retrieves a user by name, or nil if there is none
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return d.getUser(ctx, `username = ?`, username)
+}
+
+// GetUser retrieves a user by ID, or nil if there is none
+func (d *Database) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	return d.getUser(ctx, `id = ?`, id)
+}
+
+// CreateUser adds a user with the username, password hash, timezone and
+// email settings of u, and sets its ID and creation time. It returns
+// ErrUsernameExists if the name is taken.
+func (d *Database) CreateUser(ctx context.Context, u *models.User) error {
+	query := `INSERT INTO users (username, password_hash, timezone, email, email_notifications)
+        VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+        RETURNING id, created_at`
+
+	err := d.db.QueryRowContext(ctx, query, u.Username, u.PasswordHash, u.Timezone,
+		u.Email, strings.Join(u.EmailNotifications, ",")).Scan(&u.ID, &u.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrUsernameExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+// CreateFirstUser is CreateUser, unless the database has users already,
+// in which case it returns ErrUsersExist.
+func (d *Database) CreateFirstUser(ctx context.Context, u *models.User) error {
+	query := `INSERT INTO users (username, password_hash, timezone, email, email_notifications)
+        SELECT ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, '')
+        WHERE NOT EXISTS (SELECT 1 FROM users)
+        RETURNING id, created_at`
+
+	err := d.db.QueryRowContext(ctx, query, u.Username, u.PasswordHash, u.Timezone,
+		u.Email, strings.Join(u.EmailNotifications, ",")).Scan(&u.ID, &u.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUsersExist
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserSettings stores the timezone and email settings of u. Empty
+// values clear them. Changing the email address makes it unverified, which
+// is reflected in u.EmailVerified.
+func (d *Database) UpdateUserSettings(ctx context.Context, u *models.User) error {
+	err := d.db.QueryRowContext(ctx, `
+        UPDATE users
+        SET email_verified_at = CASE WHEN email IS NULLIF(?, '') THEN email_verified_at END,
+            timezone = NULLIF(?, ''), email = NULLIF(?, ''), email_notifications = NULLIF(?, '')
+        WHERE id = ?
+        RETURNING email_verified_at IS NOT NULL`,
+		u.Email, u.Timezone, u.Email, strings.Join(u.EmailNotifications, ","), u.ID).Scan(&u.EmailVerified)
+	if err != nil {
+		return fmt.Errorf("failed to update user settings: %w", err)
+	}
+	return nil
+}
+
+// VerifyEmail marks email as the verified address of the user. It returns
+// ErrEmailChanged if that is no longer the user's address.
+func (d *Database) VerifyEmail(ctx context.Context, userID int64, email string) error {
+	res, err := d.db.ExecContext(ctx, `
+        UPDATE users SET email_verified_at = COALESCE(email_verified_at, `+now+`)
+        WHERE id = ? AND email = ?`, userID, email)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	} else if n == 0 {
+		return ErrEmailChanged
+	}
+	return nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx, so helpers can run
+// inside or outside of a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CreateAppointment inserts a new appointment along with its attendees into
+// the database. The slug is derived from the title when empty, and suffixed
+// with a number if another appointment of the same user already uses it.
+// Unless forced, it fails with a *ConflictError if the appointment
+// overlaps another one of the user.
+func (d *Database) CreateAppointment(ctx context.Context, a *models.Appointment, force bool) error {
+	return d.CreateAppointments(ctx, []*models.Appointment{a}, force)
+}
+
+// CreateAppointments inserts several appointments in a single transaction,
+// in order. If any insert fails, none of the appointments are stored.
+// Unless forced, each appointment is checked for conflicts with the
+// existing appointments, but not with the others inserted along with it.
+func (d *Database) CreateAppointments(ctx context.Context, appointments []*models.Appointment, force bool) error {
+	return d.createAppointments(ctx, appointments, false, force)
+}
+
+// PreviewAppointments performs the inserts of CreateAppointments, but rolls
+// them back, so it fails exactly when CreateAppointments would. Slugs are
+// assigned as they would be; IDs and timestamps are left zero.
+func (d *Database) PreviewAppointments(ctx context.Context, appointments []*models.Appointment, force bool) error {
+	return d.createAppointments(ctx, appointments, true, force)
+}
+
+func (d *Database) createAppointments(ctx context.Context, appointments []*models.Appointment, dryRun, force bool) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Checked before inserting any, as the appointments of a batch are
+	// not checked against each other. The transaction holds the write
+	// lock, so no conflicting appointment can be stored in between.
+	if !force {
+		for _, a := range appointments {
+			if err := d.checkConflicts(ctx, tx, a); err != nil {
+				return err
+			}
+		}
+	}
+
+	insert, err := prepareInsert(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, a := range appointments {
+		if err := insertAppointment(ctx, tx, insert, a); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		forgetInserted(appointments)
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit appointments: %w", err)
+	}
+
+	return nil
+}
+
+// forgetInserted clears what inserts assigned to appointments, other than
+// their slugs, after rolling back.
+func forgetInserted(appointments []*models.Appointment) {
+	for _, a := range appointments {
+		a.ID = 0
+		a.CreatedAt, a.UpdatedAt = time.Time{}, time.Time{}
+	}
+}
+
+// RestoreAppointments inserts appointments from a data archive in a single
+// transaction, keeping their slugs, check-in times and sort order. Conflicts
+// are decided by slug: if an appointment of the user already has the slug,
+// the archived appointment is skipped when skipExisting is set, otherwise
+// the restore fails with ErrSlugExists and nothing is stored. It returns
+// the appointments inserted. With dryRun, the inserts are rolled back, as
+// by PreviewAppointments.
+func (d *Database) RestoreAppointments(ctx context.Context, appointments []*models.Appointment, skipExisting, dryRun bool) ([]*models.Appointment, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert, err := prepareInsert(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+
+	var restored []*models.Appointment
+	for _, a := range appointments {
+		if a.Slug != "" {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `
+                SELECT EXISTS (SELECT 1 FROM appointments WHERE user_id = ? AND slug = ?)`,
+				a.UserID, a.Slug).Scan(&exists)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check slug: %w", err)
+			}
+			if exists && skipExisting {
+				continue
+			}
+			if exists {
+				return nil, fmt.Errorf("appointment %q: %w", a.Slug, ErrSlugExists)
+			}
+		}
+		if err := insertAppointment(ctx, tx, insert, a); err != nil {
+			return nil, err
+		}
+		if a.ActualStart != nil || a.ActualEnd != nil || a.SortOrder != 0 {
+			_, err := tx.ExecContext(ctx, `
+                UPDATE appointments SET actual_start = ?, actual_end = ?, sort_order = ?
+                WHERE id = ?`, a.ActualStart, a.ActualEnd, a.SortOrder, a.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore appointment: %w", err)
+			}
+		}
+		restored = append(restored, a)
+	}
+
+	if dryRun {
+		forgetInserted(restored)
+		return restored, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit restore: %w", err)
+	}
+
+	return restored, nil
+}
+
+// prepareInsert prepares the statement inserting an appointment, for use
+// with insertAppointment.
+func prepareInsert(ctx context.Context, tx *sql.Tx) (*sql.Stmt, error) {
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO appointments (
+            user_id, title, original_title, slug, uid, description, location,
+            conference_url, category, color, start_time, end_time, all_day,
+            timezone, recurrence, exdates, status, reminder_channels
+        ) VALUES (?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''),
+                  ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, NULLIF(?, ''))
+        RETURNING id, created_at, updated_at, version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	return stmt, nil
+}
+
+// insertAppointment stores a along with its attendees, using the insert
+// statement prepared on tx.
+func insertAppointment(ctx context.Context, tx *sql.Tx, insert *sql.Stmt, a *models.Appointment) error {
+	base := a.Slug
+	if base == "" {
+		base = models.Slugify(a.Title)
+	}
+	slug, err := availableSlug(ctx, tx, a.UserID, base, 0)
+	if err != nil {
+		return err
+	}
+	a.Slug = slug
+	if a.Status == "" {
+		a.Status = models.StatusConfirmed
+	}
+
+	// Store UTC, so timestamps compare correctly as text.
+	err = insert.QueryRowContext(
+		ctx,
+		a.UserID,
+		a.Title,
+		a.OriginalTitle,
+		a.Slug,
+		a.UID,
+		a.Description,
+		a.Place,
+		a.ConferenceURL,
+		a.Category,
+		a.Color,
+		a.StartTime.UTC(),
+		a.EndTime.UTC(),
+		a.AllDay,
+		a.Timezone,
+		a.Recurrence,
+		recurrence.FormatDates(a.ExDates),
+		a.Status,
+		strings.Join(a.ReminderChannels, ","),
+	).Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt, &a.Version)
+	a.LocalizeTimes()
+
+	if err := appointmentUniqueError(err); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create appointment: %w", err)
+	}
+
+	return insertAttendees(ctx, tx, a)
+}
+
+// insertAttendees stores the attendees of appt. An empty response status
+// defaults to needs-action. Attendees are linked to the users given by
+// their UserID, which callers must only set for attendees invited with
+// InviteAttendee.
+func insertAttendees(ctx context.Context, q querier, a *models.Appointment) error {
+	for i := range a.Attendees {
+		at := &a.Attendees[i]
+		if at.ResponseStatus == "" {
+			at.ResponseStatus = models.ResponseNeedsAction
+		}
+		_, err := q.ExecContext(ctx, `
+            INSERT INTO appointment_attendees (appointment_id, email, response_status, user_id)
+            VALUES (?, ?, ?, NULLIF(?, 0))`, a.ID, at.Email, at.ResponseStatus, at.UserID)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("attendee %s: %w", at.Email, ErrAttendeeExists)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add attendee %s: %w", at.Email, err)
+		}
+	}
+	return nil
+}
+
+// replaceAttendees replaces the stored attendees of a by a.Attendees.
+// Attendees that were invited as users stay linked to them, matched by
+// email; links given in a.Attendees are ignored.
+func replaceAttendees(ctx context.Context, q querier, a *models.Appointment) error {
+	rows, err := q.QueryContext(ctx, `
+        SELECT email, user_id FROM appointment_attendees
+        WHERE appointment_id = ? AND user_id IS NOT NULL`, a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list attendees: %w", err)
+	}
+	users := make(map[string]int64)
+	for rows.Next() {
+		var (
+			email  string
+			userID int64
+		)
+		if err := rows.Scan(&email, &userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan attendee: %w", err)
+		}
+		users[strings.ToLower(email)] = userID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating attendees: %w", err)
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM appointment_attendees WHERE appointment_id = ?`, a.ID); err != nil {
+		return fmt.Errorf("failed to remove attendees: %w", err)
+	}
+	for i := range a.Attendees {
+		a.Attendees[i].UserID = users[strings.ToLower(a.Attendees[i].Email)]
+	}
+	return insertAttendees(ctx, q, a)
+}
+
+// availableSlug returns base, or base with the lowest numeric suffix
+// ("standup-2", "standup-3", ...) not used by another appointment of the
+// user, shortened to fit the maximum slug length. The appointment with
+// excludeID is ignored, so it may keep its slug.
+func availableSlug(ctx context.Context, q querier, userID int64, base string, excludeID int64) (string, error) {
+	// Suffixes shorten long bases, so look up all slugs sharing the part
+	// of base that any suffix up to ten digits leaves.
+	prefix := base
+	if n := models.MaxSlugLength - len("-1234567890"); len(prefix) > n {
+		prefix = strings.TrimRight(prefix[:n], "-")
+	}
+	query := `
+        SELECT slug FROM appointments
+        WHERE user_id = ? AND id != ? AND (slug = ? OR slug LIKE ?)`
+
+	rows, err := q.QueryContext(ctx, query, userID, excludeID, base, prefix+"%-%")
+	if err != nil {
+		return "", fmt.Errorf("failed to look up slugs: %w", err)
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", fmt.Errorf("failed to scan slug: %w", err)
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating slugs: %w", err)
+	}
+
+	slug := base
+	for i := 2; taken[slug]; i++ {
+		slug = models.SuffixSlug(base, i)
+	}
+	return slug, nil
+}
+
+// GetAppointment retrieves an appointment by ID, or nil if there is none or
+// it was deleted
+func (d *Database) GetAppointment(ctx context.Context, id int64) (*models.Appointment, error) {
+	return d.getAppointment(ctx, id, false)
+}
+
+// GetAppointmentIncludingDeleted is like GetAppointment, but also returns
+// deleted appointments.
+func (d *Database) GetAppointmentIncludingDeleted(ctx context.Context, id int64) (*models.Appointment, error) {
+	return d.getAppointment(ctx, id, true)
+}
+
+func (d *Database) getAppointment(ctx context.Context, id int64, includeDeleted bool) (*models.Appointment, error) {
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE id = ?`
+	if !includeDeleted {
+		query += ` AND deleted_at IS NULL`
+	}
+
+	a, err := scanAppointment(d.db.QueryRowContext(ctx, query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	return a, nil
+}
+
+// GetAppointmentBySlug retrieves an appointment of a user by its slug
+func (d *Database) GetAppointmentBySlug(ctx context.Context, userID int64, slug string) (*models.Appointment, error) {
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ? AND slug = ? AND deleted_at IS NULL`
+
+	a, err := scanAppointment(d.db.QueryRowContext(ctx, query, userID, slug))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	return a, nil
+}
+
+// GetAppointmentByUID retrieves an appointment of a user by its iCalendar
+// UID, see models.Appointment.ICalUID, or nil if there is none.
+func (d *Database) GetAppointmentByUID(ctx context.Context, userID int64, uid string) (*models.Appointment, error) {
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ? AND deleted_at IS NULL
+        AND (uid = ? OR (uid IS NULL AND id || '@cali' = ?))`
+
+	a, err := scanAppointment(d.db.QueryRowContext(ctx, query, userID, uid, uid))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment: %w", err)
+	}
+
+	return a, nil
+}
+
+// CollectionTag returns a value that changes whenever an appointment of the
+// user is created, updated or deleted, like the CalDAV getctag property.
+func (d *Database) CollectionTag(ctx context.Context, userID int64) (string, error) {
+	var count, updated, deleted int64
+	err := d.db.QueryRowContext(ctx, `
+        SELECT COUNT(*),
+               COALESCE(`+epochMillis("MAX(updated_at)")+`, 0),
+               COALESCE(`+epochMillis("MAX(deleted_at)")+`, 0)
+        FROM appointments
+        WHERE user_id = ?`, userID).Scan(&count, &updated, &deleted)
+	if err != nil {
+		return "", fmt.Errorf("failed to get collection tag: %w", err)
+	}
+	return fmt.Sprintf("%d-%d-%d", count, updated, deleted), nil
+}
+
+// ListCategories returns the distinct categories of a user's appointments,
+// sorted, e.g. to build a legend.
+func (d *Database) ListCategories(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+        SELECT DISTINCT category
+        FROM appointments
+        WHERE user_id = ? AND deleted_at IS NULL AND category IS NOT NULL
+        ORDER BY category ASC`
+
+	rows, err := d.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []string{}
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating categories: %w", err)
+	}
+	return categories, nil
+}
+
+// Filter restricts the appointments returned by list queries. Zero fields
+// do not restrict the result.
+type Filter struct {
+	// Status selects appointments with the given status.
+	Status string
+	// Category selects appointments with the given category.
+	Category string
+}
+
+// where returns the SQL conditions of the filter, each preceded by AND,
+// along with their arguments.
+func (f Filter) where() (string, []interface{}) {
+	var (
+		conditions string
+		args       []interface{}
+	)
+	if f.Status != "" {
+		conditions += " AND status = ?"
+		args = append(args, f.Status)
+	}
+	if f.Category != "" {
+		conditions += " AND category = ?"
+		args = append(args, f.Category)
+	}
+	return conditions, args
+}
+
+// ListAppointments retrieves a page of appointments for a user within a time
+// range, skipping offset appointments and returning at most limit, or all if
+// limit is not positive. Only appointments matching filter are included. It
+// also returns the total number of matching appointments in the range. The
+// result is never nil, so it encodes as an empty JSON array rather than null
+// when nothing matches.
+func (d *Database) ListAppointments(ctx context.Context, userID int64, start, end time.Time, filter Filter, limit, offset int) ([]*models.Appointment, int, error) {
+	var (
+		appointments []*models.Appointment
+		total        int
+	)
+	err := readSnapshot(ctx, d.db, func(q querier) error {
+		var err error
+		appointments, total, err = d.listAppointments(ctx, q, userID, start, end, filter, limit, offset)
+		return err
+	})
+	return appointments, total, err
+}
+
+// listAppointments implements ListAppointments, making its queries with q,
+// which must see a single snapshot of the database, so the count and the
+// page agree.
+func (d *Database) listAppointments(ctx context.Context, q querier, userID int64, start, end time.Time, filter Filter, limit, offset int) ([]*models.Appointment, int, error) {
+	occurrences, err := d.expandRecurring(ctx, q, userID, start, end, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conditions, filterArgs := filter.where()
+	where := `
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND COALESCE(recurrence, '') = ''
+        AND start_time >= ?
+        AND end_time <= ?` + conditions
+	args := append([]interface{}{userID, start.UTC(), end.UTC()}, filterArgs...)
+
+	var total int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM appointments`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count appointments: %w", err)
+	}
+	total += len(occurrences)
+	appointments := []*models.Appointment{}
+	if offset >= total {
+		return appointments, total, nil
+	}
+
+	// Each occurrence may come before any appointment, so the page starts
+	// at most len(occurrences) appointments earlier in the table, and holds
+	// at most that many more.
+	skip := max(offset-len(occurrences), 0)
+	fetch := -1
+	if limit > 0 {
+		fetch = limit + len(occurrences)
+	}
+	rows, err := q.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments`+where+`
+        ORDER BY start_time ASC, sort_order ASC, id ASC
+        LIMIT ? OFFSET ?`, append(args, fetch, skip)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list appointments: %w", err)
+	}
+	defer rows.Close()
+	var plain []*models.Appointment
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		plain = append(plain, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	// pos is the position of the next appointment in the merged list.
+	// Occurrences before the first appointment read lie before the page
+	// if appointments were skipped. If none was read, all occurrences do.
+	pos := 0
+	if skip > 0 {
+		n := 0
+		for n < len(occurrences) && (len(plain) == 0 || appointmentLess(occurrences[n], plain[0])) {
+			n++
+		}
+		occurrences, pos = occurrences[n:], skip+n
+	}
+	for (limit <= 0 || len(appointments) < limit) && (len(plain) > 0 || len(occurrences) > 0) {
+		var next *models.Appointment
+		if len(plain) == 0 || (len(occurrences) > 0 && appointmentLess(occurrences[0], plain[0])) {
+			next, occurrences = occurrences[0], occurrences[1:]
+		} else {
+			next, plain = plain[0], plain[1:]
+		}
+		if pos >= offset {
+			appointments = append(appointments, next)
+		}
+		pos++
+	}
+	return appointments, total, nil
+}
+
+// CountAppointments returns the number of appointments of a user within a
+// time range, counting the occurrences of recurring appointments like
+// ListAppointments does.
+func (d *Database) CountAppointments(ctx context.Context, userID int64, start, end time.Time) (int, error) {
+	occurrences, err := d.expandRecurring(ctx, d.db, userID, start, end, Filter{})
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+        SELECT COUNT(*)
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND COALESCE(recurrence, '') = ''
+        AND start_time >= ?
+        AND end_time <= ?`
+
+	var count int
+	if err := d.db.QueryRowContext(ctx, query, userID, start.UTC(), end.UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count appointments: %w", err)
+	}
+	return count + len(occurrences), nil
+}
+
+// CountAppointmentsByDay is like CountAppointments, but returns the number of
+// appointments starting on each calendar day in loc, keyed by date
+// (2006-01-02). Days without appointments are left out.
+func (d *Database) CountAppointmentsByDay(ctx context.Context, userID int64, start, end time.Time, loc *time.Location) (map[string]int, error) {
+	occurrences, err := d.expandRecurring(ctx, d.db, userID, start, end, Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Days depend on the timezone, so only start times are read and
+	// grouped here rather than in SQL.
+	query := `
+        SELECT start_time
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND COALESCE(recurrence, '') = ''
+        AND start_time >= ?
+        AND end_time <= ?`
+
+	rows, err := d.db.QueryContext(ctx, query, userID, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count appointments: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to scan start time: %w", err)
+		}
+		counts[t.In(loc).Format("2006-01-02")]++
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	for _, o := range occurrences {
+		counts[o.StartTime.In(loc).Format("2006-01-02")]++
+	}
+	return counts, nil
+}
+
+// StreamAppointments calls fn for each appointment of a user within a time
+// range matching filter, in start time order. Recurring appointments are
+// expanded into their occurrences within the range. Plain appointments are
+// passed on as rows are read, without loading the whole result into
+// memory. Iteration stops at the first error returned by fn.
+func (d *Database) StreamAppointments(ctx context.Context, userID int64, start, end time.Time, filter Filter, fn func(*models.Appointment) error) error {
+	occurrences, err := d.expandRecurring(ctx, d.db, userID, start, end, filter)
+	if err != nil {
+		return err
+	}
+
+	conditions, filterArgs := filter.where()
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND COALESCE(recurrence, '') = ''
+        AND start_time >= ?
+        AND end_time <= ?` + conditions + `
+        ORDER BY start_time ASC, sort_order ASC, id ASC`
+
+	// Timestamps are compared as text, so bounds must use the same zone as
+	// the stored values.
+	args := append([]interface{}{userID, start.UTC(), end.UTC()}, filterArgs...)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list appointments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		// Interleave occurrences, which are sorted already.
+		for len(occurrences) > 0 && appointmentLess(occurrences[0], a) {
+			if err := fn(occurrences[0]); err != nil {
+				return err
+			}
+			occurrences = occurrences[1:]
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	for _, o := range occurrences {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WalkAppointments calls fn for each stored appointment of a user, ordered
+// by start time. Unlike StreamAppointments, recurring appointments are not
+// expanded. Iteration stops at the first error returned by fn.
+func (d *Database) WalkAppointments(ctx context.Context, userID int64, fn func(*models.Appointment) error) error {
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ? AND deleted_at IS NULL
+        ORDER BY start_time ASC, sort_order ASC, id ASC`
+	return d.walkAppointments(ctx, fn, query, userID)
+}
+
+// WalkChangedAppointments is like WalkAppointments, but includes deleted
+// appointments and calls fn only for those updated at or after since,
+// ordered by the time of their last update. A zero since includes all.
+func (d *Database) WalkChangedAppointments(ctx context.Context, userID int64, since time.Time, fn func(*models.Appointment) error) error {
+	// Format since like the timestamps written by the database, so they
+	// compare correctly as text.
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ? AND updated_at >= ?
+        ORDER BY updated_at ASC, id ASC`
+	return d.walkAppointments(ctx, fn, query, userID, since.UTC().Format("2006-01-02 15:04:05.000"))
+}
+
+func (d *Database) walkAppointments(ctx context.Context, fn func(*models.Appointment) error, query string, args ...interface{}) error {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to list appointments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	return nil
+}
+
+// expandRecurring returns the occurrences of all recurring appointments of a
+// user matching filter within a time range, sorted like list results.
+func (d *Database) expandRecurring(ctx context.Context, q querier, userID int64, start, end time.Time, filter Filter) ([]*models.Appointment, error) {
+	conditions, filterArgs := filter.where()
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND COALESCE(recurrence, '') != ''
+        AND start_time <= ?` + conditions
+
+	args := append([]interface{}{userID, end.UTC()}, filterArgs...)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recurring appointments: %w", err)
+	}
+	defer rows.Close()
+
+	var occurrences []*models.Appointment
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		expanded, err := d.expansions.expand(a, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("appointment %d: %w", a.ID, err)
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recurring appointments: %w", err)
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return appointmentLess(occurrences[i], occurrences[j])
+	})
+	return occurrences, nil
+}
+
+// appointmentLess orders appointments by start time, then sort order, then
+// id, matching the ORDER BY of list queries.
+func appointmentLess(a, b *models.Appointment) bool {
+	if !a.StartTime.Equal(b.StartTime) {
+		return a.StartTime.Before(b.StartTime)
+	}
+	if a.SortOrder != b.SortOrder {
+		return a.SortOrder < b.SortOrder
+	}
+	return a.ID < b.ID
+}
+
+// UpdateAppointment updates an existing appointment and replaces its
+// attendees. An empty slug keeps the current one, so links stay stable when
+// only the title changes. If a.Version is set, the update is conditional:
+// it fails with ErrStaleAppointment unless the stored appointment has that
+// version. Otherwise, if a.UpdatedAt is set, it is conditional on the
+// stored appointment having been last updated at exactly that time. It
+// returns ErrAppointmentNotFound if the user has no appointment with the
+// ID. Unless forced, it fails with a *ConflictError if the updated
+// appointment overlaps another one of the user.
+func (d *Database) UpdateAppointment(ctx context.Context, a *models.Appointment, force bool) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if a.Slug != "" {
+		slug, err := availableSlug(ctx, tx, a.UserID, a.Slug, a.ID)
+		if err != nil {
+			return err
+		}
+		a.Slug = slug
+	}
+
+	query := `
+        UPDATE appointments
+        SET title = ?, original_title = NULLIF(?, ''),
+            slug = COALESCE(NULLIF(?, ''), slug), description = ?,
+            location = NULLIF(?, ''), conference_url = NULLIF(?, ''),
+            category = NULLIF(?, ''), color = NULLIF(?, ''),
+            start_time = ?, end_time = ?, all_day = ?, timezone = NULLIF(?, ''),
+            recurrence = ?, status = ?, reminder_channels = NULLIF(?, ''),
+            ` + touched + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+
+	args := []interface{}{
+		a.Title,
+		a.OriginalTitle,
+		a.Slug,
+		a.Description,
+		a.Place,
+		a.ConferenceURL,
+		a.Category,
+		a.Color,
+		a.StartTime.UTC(),
+		a.EndTime.UTC(),
+		a.AllDay,
+		a.Timezone,
+		a.Recurrence,
+		a.Status,
+		strings.Join(a.ReminderChannels, ","),
+		a.ID,
+		a.UserID,
+	}
+	condition, conditionArgs := precondition(a)
+	query += condition + `
+        RETURNING COALESCE(slug, ''), created_at, updated_at, version`
+	args = append(args, conditionArgs...)
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&a.Slug, &a.CreatedAt, &a.UpdatedAt, &a.Version)
+
+	if err := appointmentUniqueError(err); err != nil {
+		return err
+	}
+	if err == sql.ErrNoRows {
+		return missingAppointment(ctx, tx, a.ID, a.UserID, condition != "")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update appointment: %w", err)
+	}
+	a.LocalizeTimes()
+	if !force {
+		if err := d.checkConflicts(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+
+	if err := replaceAttendees(ctx, tx, a); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit update: %w", err)
+	}
+	return nil
+}
+
+// precondition returns the condition of a conditional change of a on the
+// stored appointment, with its arguments: the version of a if set, and
+// otherwise its update time if set. Without either, the condition is empty.
+func precondition(a *models.Appointment) (string, []interface{}) {
+	switch {
+	case a.Version != 0:
+		return ` AND version = ?`, []interface{}{a.Version}
+	case !a.UpdatedAt.IsZero():
+		return ` AND ` + sameInstant("updated_at"), []interface{}{a.UpdatedAt.UTC()}
+	}
+	return "", nil
 }
 
-func New(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// missingAppointment returns the error of a change that found no
+// appointment to change: ErrStaleAppointment if the change was conditional
+// and the user has the appointment, and ErrAppointmentNotFound otherwise.
+func missingAppointment(ctx context.Context, q querier, id, userID int64, conditional bool) error {
+	if !conditional {
+		return ErrAppointmentNotFound
+	}
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM appointments WHERE id = ? AND user_id = ? AND deleted_at IS NULL)`,
+		id, userID).Scan(&exists)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return fmt.Errorf("failed to check appointment: %w", err)
+	}
+	if exists {
+		return ErrStaleAppointment
 	}
+	return ErrAppointmentNotFound
+}
 
-	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+// MoveAppointment stores the start and end time and EXDATEs of an
+// appointment of the user, as changed by moving it, and updates a from the
+// stored appointment. The update is conditional on a.Version or
+// a.UpdatedAt like in UpdateAppointment, one of which must be set. It returns
+// ErrStaleAppointment if the appointment was modified since, and
+// ErrAppointmentNotFound if the user has no appointment with the ID. Unless
+// forced, it fails with a *ConflictError if the moved appointment overlaps
+// another one of the user.
+func (d *Database) MoveAppointment(ctx context.Context, a *models.Appointment, force bool) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	// Verify connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	condition, conditionArgs := precondition(a)
+	if condition == "" {
+		return fmt.Errorf("move of appointment %d is not conditional", a.ID)
+	}
+	query := `
+        UPDATE appointments
+        SET start_time = ?, end_time = ?, exdates = NULLIF(?, ''), ` + touched + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL` + condition + `
+        RETURNING ` + appointmentColumns
+
+	args := append([]interface{}{
+		a.StartTime.UTC(), a.EndTime.UTC(), recurrence.FormatDates(a.ExDates), a.ID, a.UserID,
+	}, conditionArgs...)
+	moved, err := scanAppointment(tx.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return missingAppointment(ctx, tx, a.ID, a.UserID, true)
+	}
+	if err := appointmentUniqueError(err); err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move appointment: %w", err)
+	}
+	if !force {
+		if err := d.checkConflicts(ctx, tx, moved); err != nil {
+			return err
+		}
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit move: %w", err)
+	}
+	*a = *moved
+	return nil
+}
 
-	return &Database{db: db}, nil
+// UpdateSeries stores the start and end time, recurrence rule and EXDATEs
+// of a recurring appointment of the user, as changed by deleting some of
+// its occurrences, and returns the updated appointment. If a.Version is
+// set, the update is conditional on it like in PatchAppointment. It returns
+// ErrAppointmentNotFound if the user has no appointment with the ID.
+func (d *Database) UpdateSeries(ctx context.Context, a *models.Appointment) (*models.Appointment, error) {
+	// Deleting occurrences cannot cause conflicts.
+	return d.PatchAppointment(ctx, a.ID, a.UserID, a.Version, map[string]interface{}{
+		"start_time": a.StartTime,
+		"end_time":   a.EndTime,
+		"recurrence": a.Recurrence,
+		"exdates":    recurrence.FormatDates(a.ExDates),
+	}, true)
 }
 
-func (d *Database) Close() error {
-	return d.db.Close()
+// patchableColumns lists the columns PatchAppointment may update. Columns
+// mapped to true are nullable and store an empty string as NULL.
+var patchableColumns = map[string]bool{
+	"title":             false,
+	"original_title":    true,
+	"slug":              false,
+	"description":       false,
+	"location":          true,
+	"conference_url":    true,
+	"category":          true,
+	"color":             true,
+	"start_time":        false,
+	"end_time":          false,
+	"all_day":           false,
+	"timezone":          true,
+	"recurrence":        false,
+	"exdates":           true,
+	"status":            false,
+	"reminder_channels": true,
 }
 
-// InitSchema creates the database schema if it doesn't exist
-func (d *Database) InitSchema() error {
-	schema := `
-        CREATE TABLE IF NOT EXISTS users (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            username TEXT UNIQUE NOT NULL,
-            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-        );
+// PatchAppointment updates only the given columns of an appointment of the
+// user and returns the updated appointment. Fields maps column names to
+// values; times are stored as UTC and a slug is made unique among the
+// user's appointments. Unless version is zero, the update is conditional:
+// it fails with ErrStaleAppointment unless the stored appointment has that
+// version. It returns ErrAppointmentNotFound if the user has no appointment
+// with the ID. Unless forced, it fails with a *ConflictError if the patched
+// appointment overlaps another one of the user.
+func (d *Database) PatchAppointment(ctx context.Context, id, userID, version int64, fields map[string]interface{}, force bool) (*models.Appointment, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		if _, ok := patchableColumns[column]; !ok {
+			return nil, fmt.Errorf("cannot patch column %q", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var (
+		assignments []string
+		args        []interface{}
+	)
+	for _, column := range columns {
+		value := fields[column]
+		switch v := value.(type) {
+		case time.Time:
+			value = v.UTC()
+		case string:
+			if column == "slug" {
+				slug, err := availableSlug(ctx, tx, userID, v, id)
+				if err != nil {
+					return nil, err
+				}
+				value = slug
+			}
+		}
+		if patchableColumns[column] {
+			assignments = append(assignments, column+" = NULLIF(?, '')")
+		} else {
+			assignments = append(assignments, column+" = ?")
+		}
+		args = append(args, value)
+	}
+	assignments = append(assignments, touched)
+	condition, conditionArgs := precondition(&models.Appointment{Version: version})
 
-        CREATE TABLE IF NOT EXISTS appointments (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user_id INTEGER NOT NULL,
-            title TEXT NOT NULL,
-            description TEXT,
-            start_time TIMESTAMP NOT NULL,
-            end_time TIMESTAMP NOT NULL,
-            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (user_id) REFERENCES users(id),
-            CHECK (end_time > start_time)
-        );`
+	query := `
+        UPDATE appointments
+        SET ` + strings.Join(assignments, ", ") + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL` + condition + `
+        RETURNING ` + appointmentColumns
 
-	_, err := d.db.Exec(schema)
+	args = append(append(args, id, userID), conditionArgs...)
+	a, err := scanAppointment(tx.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, missingAppointment(ctx, tx, id, userID, condition != "")
+	}
+	if err := appointmentUniqueError(err); err != nil {
+		return nil, err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+		return nil, fmt.Errorf("failed to patch appointment: %w", err)
+	}
+	if !force {
+		if err := d.checkConflicts(ctx, tx, a); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit patch: %w", err)
 	}
 
-	return nil
+	return a, nil
 }
 
-// CreateAppointment inserts a new appointment into the database
-func (d *Database) CreateAppointment(a *models.Appointment) error {
+// SetActualTimes records when an appointment actually started and ended.
+// Nil values clear the respective column.
+func (d *Database) SetActualTimes(ctx context.Context, a *models.Appointment) error {
 	query := `
-        INSERT INTO appointments (
-            user_id, title, description, start_time, end_time
-        ) VALUES (?, ?, ?, ?, ?)
-        RETURNING id, created_at, updated_at`
+        UPDATE appointments
+        SET actual_start = ?, actual_end = ?, ` + touched + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL
+        RETURNING updated_at, version`
 
-	err := d.db.QueryRow(
+	err := d.db.QueryRowContext(
+		ctx,
 		query,
+		a.ActualStart,
+		a.ActualEnd,
+		a.ID,
 		a.UserID,
-		a.Title,
-		a.Description,
-		a.StartTime,
-		a.EndTime,
-	).Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
+	).Scan(&a.UpdatedAt, &a.Version)
 
 	if err != nil {
-		return fmt.Errorf("failed to create appointment: %w", err)
+		return fmt.Errorf("failed to set actual times: %w", err)
 	}
 
 	return nil
 }
 
-// GetAppointment retrieves an appointment by ID
-func (d *Database) GetAppointment(id int64) (*models.Appointment, error) {
-	a := &models.Appointment{}
+// HasConflict reports whether the user has an appointment overlapping the
+// range [start, end). The appointment with excludeID is ignored, so an
+// appointment being updated does not conflict with itself. All-day and
+// cancelled appointments never conflict.
+func (d *Database) HasConflict(ctx context.Context, userID int64, start, end time.Time, excludeID int64) (bool, error) {
+	a, err := d.FindConflict(ctx, userID, start, end, excludeID)
+	return a != nil, err
+}
+
+// FindConflict returns the earliest appointment of the user overlapping the
+// range [start, end), ignoring the appointment with excludeID, or nil if
+// there is none. Bounds are exclusive: an appointment ending at 10:00 does
+// not conflict with one starting at 10:00. Occurrences of recurring
+// appointments are taken into account.
+func (d *Database) FindConflict(ctx context.Context, userID int64, start, end time.Time, excludeID int64) (*models.Appointment, error) {
+	conflicts, err := findConflicts(ctx, d.db, userID, []timeRange{{start, end}}, excludeID)
+	if err != nil || len(conflicts) == 0 {
+		return nil, err
+	}
+	return conflicts[0], nil
+}
+
+// timeRange is the range [start, end).
+type timeRange struct {
+	start, end time.Time
+}
+
+// overlapsAny reports whether [start, end) overlaps one of ranges, which
+// are ordered by start and whose ends are ordered as well, as those of the
+// occurrences of an appointment are.
+func overlapsAny(ranges []timeRange, start, end time.Time) bool {
+	// The first range ending after start is the only candidate: earlier
+	// ones end too soon, later ones start no earlier.
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].end.After(start) })
+	return i < len(ranges) && ranges[i].start.Before(end)
+}
+
+// findConflicts returns the appointments of the user overlapping any of
+// ranges, like FindConflict, ordered by start time. The ranges must be
+// ordered as described at overlapsAny. Of a recurring appointment, only the
+// earliest overlapping occurrence is returned.
+func findConflicts(ctx context.Context, q querier, userID int64, ranges []timeRange, excludeID int64) ([]*models.Appointment, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	start, end := ranges[0].start, ranges[len(ranges)-1].end
 	query := `
-        SELECT id, user_id, title, description, start_time, end_time,
-               created_at, updated_at
+        SELECT ` + appointmentColumns + `
         FROM appointments
-        WHERE id = ?`
+        WHERE user_id = ?
+        AND id != ?
+        AND deleted_at IS NULL
+        AND NOT all_day
+        AND status != 'cancelled'
+        AND COALESCE(recurrence, '') = ''
+        AND start_time < ?
+        AND end_time > ?
+        ORDER BY start_time ASC`
 
-	err := d.db.QueryRow(query, id).Scan(
-		&a.ID,
-		&a.UserID,
-		&a.Title,
-		&a.Description,
-		&a.StartTime,
-		&a.EndTime,
-		&a.CreatedAt,
-		&a.UpdatedAt,
-	)
+	rows, err := q.QueryContext(ctx, query, userID, excludeID, end.UTC(), start.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for conflicts: %w", err)
+	}
+	defer rows.Close()
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	var conflicts []*models.Appointment
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		if overlapsAny(ranges, a.StartTime, a.EndTime) {
+			conflicts = append(conflicts, a)
+		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	series, err := q.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments
+        WHERE user_id = ?
+        AND id != ?
+        AND deleted_at IS NULL
+        AND NOT all_day
+        AND status != 'cancelled'
+        AND COALESCE(recurrence, '') != ''
+        AND start_time < ?`, userID, excludeID, end.UTC())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get appointment: %w", err)
+		return nil, fmt.Errorf("failed to check for conflicts: %w", err)
 	}
+	defer series.Close()
 
-	return a, nil
+	for series.Next() {
+		a, err := scanAppointment(series)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		// Widen the range by the duration, so occurrences that merely
+		// overlap the range are expanded as well.
+		duration := a.EndTime.Sub(a.StartTime)
+		occurrences, err := models.ExpandRecurrences(a, start.Add(-duration), end.Add(duration))
+		if err != nil {
+			return nil, fmt.Errorf("appointment %d: %w", a.ID, err)
+		}
+		for _, o := range occurrences {
+			if overlapsAny(ranges, o.StartTime, o.EndTime) {
+				conflicts = append(conflicts, o)
+				break
+			}
+		}
+	}
+	if err := series.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recurring appointments: %w", err)
+	}
+
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].StartTime.Before(conflicts[j].StartTime)
+	})
+	return conflicts, nil
 }
 
-// ListAppointments retrieves appointments for a user within a time range
-func (d *Database) ListAppointments(userID int64, start, end time.Time) ([]*models.Appointment, error) {
+// ConflictError is returned when an appointment to be written overlaps
+// other appointments of its user.
+type ConflictError struct {
+	// Appointment is the appointment that was to be written.
+	Appointment *models.Appointment
+	// Conflicts are the appointments it overlaps, ordered by start time.
+	// Recurring appointments are represented by an occurrence.
+	Conflicts []*models.Appointment
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("appointment conflicts with %d other appointments", len(e.Conflicts))
+}
+
+// checkConflicts returns a *ConflictError if a, as about to be written,
+// overlaps other appointments of its user, beyond the overlap tolerance,
+// see models.Appointment.ConflictRange. Each occurrence of a recurring
+// appointment is checked, up to recurrence.MaxOccurrences. All-day and
+// cancelled appointments do not block time and never conflict.
+func (d *Database) checkConflicts(ctx context.Context, q querier, a *models.Appointment) error {
+	if a.AllDay || a.Status == models.StatusCancelled {
+		return nil
+	}
+	occurrences, err := models.ExpandRecurrences(a, a.StartTime, time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	ranges := make([]timeRange, len(occurrences))
+	for i, o := range occurrences {
+		ranges[i].start, ranges[i].end = o.ConflictRange(d.overlapTolerance)
+	}
+	conflicts, err := findConflicts(ctx, q, a.UserID, ranges, a.ID)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Appointment: a, Conflicts: conflicts}
+	}
+	return nil
+}
+
+// ListOverlappingAppointments returns the appointments of the user that
+// overlap the range [start, end), including occurrences of recurring
+// appointments, ordered by start time. Unlike ListAppointments, appointments
+// that only partially fall into the range are included.
+func (d *Database) ListOverlappingAppointments(ctx context.Context, userID int64, start, end time.Time) ([]*models.Appointment, error) {
 	query := `
-        SELECT id, user_id, title, description, start_time, end_time,
-               created_at, updated_at
+        SELECT ` + appointmentColumns + `
         FROM appointments
         WHERE user_id = ?
-        AND start_time >= ?
-        AND end_time <= ?
-        ORDER BY start_time ASC`
+        AND deleted_at IS NULL
+        AND start_time < ?
+        AND (COALESCE(recurrence, '') != '' OR end_time > ?)`
 
-	rows, err := d.db.Query(query, userID, start, end)
+	rows, err := d.db.QueryContext(ctx, query, userID, end.UTC(), start.UTC())
 	if err != nil {
 		return nil, fmt.Errorf("failed to list appointments: %w", err)
 	}
 	defer rows.Close()
 
-	var appointments []*models.Appointment
+	appointments := []*models.Appointment{}
 	for rows.Next() {
-		a := &models.Appointment{}
-		err := rows.Scan(
-			&a.ID,
-			&a.UserID,
-			&a.Title,
-			&a.Description,
-			&a.StartTime,
-			&a.EndTime,
-			&a.CreatedAt,
-			&a.UpdatedAt,
-		)
+		a, err := scanAppointment(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan appointment: %w", err)
 		}
-		appointments = append(appointments, a)
+		if a.Recurrence == "" {
+			appointments = append(appointments, a)
+			continue
+		}
+		// Widen the range by the duration, so occurrences that merely
+		// overlap the range are expanded as well.
+		duration := a.EndTime.Sub(a.StartTime)
+		occurrences, err := d.expansions.expand(a, start.Add(-duration), end.Add(duration))
+		if err != nil {
+			return nil, fmt.Errorf("appointment %d: %w", a.ID, err)
+		}
+		for _, o := range occurrences {
+			if o.StartTime.Before(end) && o.EndTime.After(start) {
+				appointments = append(appointments, o)
+			}
+		}
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating appointments: %w", err)
 	}
 
+	sort.SliceStable(appointments, func(i, j int) bool {
+		return appointmentLess(appointments[i], appointments[j])
+	})
 	return appointments, nil
 }
 
-// UpdateAppointment updates an existing appointment
-func (d *Database) UpdateAppointment(a *models.Appointment) error {
-	query := `
-        UPDATE appointments
-        SET title = ?, description = ?, start_time = ?, end_time = ?,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = ? AND user_id = ?
-        RETURNING updated_at`
+// ListUpcoming returns the next limit appointments of a user starting at or
+// after from, in chronological order. Recurring appointments contribute
+// their occurrences. Cancelled appointments are left out.
+func (d *Database) ListUpcoming(ctx context.Context, userID int64, from time.Time, limit int) ([]*models.Appointment, error) {
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND status != 'cancelled'
+        AND COALESCE(recurrence, '') = ''
+        AND start_time >= ?
+        ORDER BY start_time ASC, sort_order ASC, id ASC
+        LIMIT ?`, userID, from.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upcoming appointments: %w", err)
+	}
+	defer rows.Close()
 
-	err := d.db.QueryRow(
-		query,
-		a.Title,
-		a.Description,
-		a.StartTime,
-		a.EndTime,
-		a.ID,
-		a.UserID,
-	).Scan(&a.UpdatedAt)
+	appointments := []*models.Appointment{}
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		appointments = append(appointments, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating appointments: %w", err)
+	}
 
+	series, err := d.db.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND status != 'cancelled'
+        AND COALESCE(recurrence, '') != ''`, userID)
 	if err != nil {
-		return fmt.Errorf("failed to update appointment: %w", err)
+		return nil, fmt.Errorf("failed to list recurring appointments: %w", err)
+	}
+	defer series.Close()
+
+	for series.Next() {
+		a, err := scanAppointment(series)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		next, err := models.NextOccurrences(a, from, limit)
+		if err != nil {
+			return nil, fmt.Errorf("appointment %d: %w", a.ID, err)
+		}
+		appointments = append(appointments, next...)
+	}
+	if err = series.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recurring appointments: %w", err)
+	}
+
+	sort.SliceStable(appointments, func(i, j int) bool {
+		return appointmentLess(appointments[i], appointments[j])
+	})
+	if len(appointments) > limit {
+		appointments = appointments[:limit]
+	}
+	return appointments, nil
+}
+
+// SearchAppointments returns up to limit appointments of a user whose title
+// or description contains query, ignoring case, most recent first.
+// Recurring appointments are matched once, not per occurrence.
+func (d *Database) SearchAppointments(ctx context.Context, userID int64, query string, limit int) ([]*models.Appointment, error) {
+	// LIKE only folds ASCII letters, so non-ASCII text is matched
+	// case-sensitively.
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	q := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE user_id = ?
+        AND deleted_at IS NULL
+        AND (title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')
+        ORDER BY start_time DESC, id DESC
+        LIMIT ?`
+
+	rows, err := d.db.QueryContext(ctx, q, userID, pattern, pattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search appointments: %w", err)
+	}
+	defer rows.Close()
+
+	appointments := []*models.Appointment{}
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		appointments = append(appointments, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating appointments: %w", err)
+	}
+	return appointments, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, using backslash as
+// the escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ReorderAppointments assigns sequential sort orders to the given
+// appointments of a user, in the order given. Sort order breaks ties between
+// appointments starting at the same time. If any id does not exist,
+// ErrAppointmentNotFound is returned and nothing is changed.
+func (d *Database) ReorderAppointments(ctx context.Context, userID int64, ids []int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+        UPDATE appointments
+        SET sort_order = ?, `+touched+`
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare reorder: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, id := range ids {
+		result, err := stmt.ExecContext(ctx, i, id, userID)
+		if err != nil {
+			return fmt.Errorf("failed to reorder appointment: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("appointment %d: %w", id, ErrAppointmentNotFound)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit reorder: %w", err)
 	}
 
 	return nil
 }
 
-// DeleteAppointment removes an appointment
-func (d *Database) DeleteAppointment(id, userID int64) error {
-	query := `DELETE FROM appointments WHERE id = ? AND user_id = ?`
+// MergeAppointments replaces two appointments of a user by a single one
+// spanning both time ranges, with the descriptions concatenated. The merged
+// appointment takes the title, slug and UID of the appointment with keepID,
+// which must be one of the two. The originals are deleted, keeping their
+// reminders and attendees, and record the merge in MergedInto and
+// MergedFrom. Everything happens in one transaction. If either appointment
+// does not exist, nil is returned; if either is recurring,
+// ErrMergeRecurring.
+func (d *Database) MergeAppointments(ctx context.Context, userID, firstID, secondID, keepID int64) (*models.Appointment, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT ` + appointmentColumns + `
+        FROM appointments
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+
+	var originals [2]*models.Appointment
+	for i, id := range []int64{firstID, secondID} {
+		a, err := scanAppointment(tx.QueryRowContext(ctx, query, id, userID))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get appointment: %w", err)
+		}
+		if a.Recurrence != "" {
+			return nil, ErrMergeRecurring
+		}
+		originals[i] = a
+	}
+
+	first, second := originals[0], originals[1]
+	kept := first
+	if keepID == second.ID {
+		kept = second
+	}
+	merged := &models.Appointment{
+		UserID:           userID,
+		Title:            kept.Title,
+		Slug:             kept.Slug,
+		UID:              kept.UID,
+		Place:            kept.Place,
+		ConferenceURL:    kept.ConferenceURL,
+		Category:         kept.Category,
+		Color:            kept.Color,
+		Timezone:         kept.Timezone,
+		Status:           kept.Status,
+		AllDay:           first.AllDay && second.AllDay,
+		ReminderChannels: kept.ReminderChannels,
+		StartTime:        first.StartTime,
+		EndTime:          first.EndTime,
+	}
+	if second.StartTime.Before(merged.StartTime) {
+		merged.StartTime = second.StartTime
+	}
+	if second.EndTime.After(merged.EndTime) {
+		merged.EndTime = second.EndTime
+	}
+	var descriptions []string
+	for _, a := range originals {
+		if a.Description != "" {
+			descriptions = append(descriptions, a.Description)
+		}
+	}
+	merged.Description = strings.Join(descriptions, "\n\n")
+	// Attendees of both are invited, with the responses given to the kept
+	// appointment taking precedence.
+	invited := make(map[string]bool)
+	for _, a := range []*models.Appointment{kept, first, second} {
+		for _, at := range a.Attendees {
+			if key := strings.ToLower(at.Email); !invited[key] {
+				invited[key] = true
+				merged.Attendees = append(merged.Attendees, at)
+			}
+		}
+	}
+
+	// The slugs are freed for the merged appointment; the UIDs are only
+	// unique among appointments not deleted.
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE appointments
+        SET deleted_at = `+now+`, slug = NULL, `+touched+`
+        WHERE id IN (?, ?) AND user_id = ?`,
+		first.ID, second.ID, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete merged appointments: %w", err)
+	}
+
+	insert, err := prepareInsert(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+	if err := insertAppointment(ctx, tx, insert, merged); err != nil {
+		return nil, err
+	}
+
+	merged.MergedFrom = []int64{first.ID, second.ID}
+	if _, err := tx.ExecContext(ctx, `UPDATE appointments SET merged_from = ? WHERE id = ?`,
+		fmt.Sprintf("%d,%d", first.ID, second.ID), merged.ID); err != nil {
+		return nil, fmt.Errorf("failed to record merge: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE appointments SET merged_into = ? WHERE id IN (?, ?)`,
+		merged.ID, first.ID, second.ID); err != nil {
+		return nil, fmt.Errorf("failed to record merge: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit merge: %w", err)
+	}
+
+	return merged, nil
+}
+
+// DeleteAppointment marks an appointment as deleted, see
+// RestoreAppointment. Unless version is zero, the deletion is conditional
+// on it like in PatchAppointment. It returns ErrAppointmentNotFound if the
+// user has no appointment with the ID.
+func (d *Database) DeleteAppointment(ctx context.Context, id, userID, version int64) error {
+	condition, conditionArgs := precondition(&models.Appointment{Version: version})
+	query := `
+        UPDATE appointments
+        SET deleted_at = ` + now + `, ` + touched + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NULL` + condition
 
-	result, err := d.db.Exec(query, id, userID)
+	result, err := d.db.ExecContext(ctx, query, append([]interface{}{id, userID}, conditionArgs...)...)
 	if err != nil {
 		return fmt.Errorf("failed to delete appointment: %w", err)
 	}
@@ -204,8 +2035,33 @@ func (d *Database) DeleteAppointment(id, userID int64) error {
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("appointment not found or unauthorized")
+		return missingAppointment(ctx, d.db, id, userID, condition != "")
 	}
 
 	return nil
 }
+
+// RestoreAppointment undoes the deletion of an appointment and returns it.
+// It returns ErrAppointmentNotFound if the user has no deleted appointment
+// with the ID, and ErrDuplicateAppointment if the optional unique index
+// rejects it.
+func (d *Database) RestoreAppointment(ctx context.Context, id, userID int64) (*models.Appointment, error) {
+	query := `
+        UPDATE appointments
+        SET deleted_at = NULL, ` + touched + `
+        WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL
+        RETURNING ` + appointmentColumns
+
+	a, err := scanAppointment(d.db.QueryRowContext(ctx, query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAppointmentNotFound
+	}
+	if err := appointmentUniqueError(err); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore appointment: %w", err)
+	}
+
+	return a, nil
+}
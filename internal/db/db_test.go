@@ -0,0 +1,476 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// newTestDatabase returns a migrated database in a temporary directory
+// with a single user.
+func newTestDatabase(t *testing.T) (*Database, *models.User) {
+	t.Helper()
+	d, err := New(filepath.Join(t.TempDir(), "cali.db"), Options{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice"}
+	if err := d.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return d, user
+}
+
+func TestListAppointmentsPages(t *testing.T) {
+	d, user := newTestDatabase(t)
+	ctx := context.Background()
+	day := time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+	var appointments = []struct {
+		hour       int
+		recurrence string
+	}{
+		{8, ""},
+		{9, "FREQ=DAILY;COUNT=5"},
+		{10, ""},
+		{10, ""},
+		{11, "FREQ=DAILY;INTERVAL=2;COUNT=3"},
+		{12, ""},
+		{7, "FREQ=DAILY;COUNT=2"},
+		{18, ""},
+	}
+	for i, a := range appointments {
+		// Plain appointments are repeated on the first days, so they
+		// interleave with the occurrences.
+		days := 3
+		if a.recurrence != "" {
+			days = 1
+		}
+		for n := 0; n < days; n++ {
+			start := day.AddDate(0, 0, n).Add(time.Duration(a.hour) * time.Hour)
+			appt := &models.Appointment{
+				UserID:     user.ID,
+				Title:      "Appointment",
+				StartTime:  start,
+				EndTime:    start.Add(30 * time.Minute),
+				Recurrence: a.recurrence,
+				Status:     models.StatusConfirmed,
+			}
+			if err := d.CreateAppointment(ctx, appt, true); err != nil {
+				t.Fatalf("appointment %d: %v", i, err)
+			}
+		}
+	}
+
+	start, end := day, day.AddDate(0, 0, 7)
+	var all []*models.Appointment
+	err := d.StreamAppointments(ctx, user.ID, start, end, Filter{}, func(a *models.Appointment) error {
+		all = append(all, a)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for limit := 0; limit <= len(all)+1; limit++ {
+		for offset := 0; offset <= len(all)+1; offset++ {
+			page, total, err := d.ListAppointments(ctx, user.ID, start, end, Filter{}, limit, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != len(all) {
+				t.Errorf("limit %d, offset %d: got total %d, want %d", limit, offset, total, len(all))
+			}
+			want := all[min(offset, len(all)):]
+			if limit > 0 && len(want) > limit {
+				want = want[:limit]
+			}
+			if len(page) != len(want) {
+				t.Errorf("limit %d, offset %d: got %d appointments, want %d", limit, offset, len(page), len(want))
+				continue
+			}
+			for i := range page {
+				if page[i].ID != want[i].ID || !page[i].StartTime.Equal(want[i].StartTime) {
+					t.Errorf("limit %d, offset %d: appointment %d is %d at %v, want %d at %v", limit, offset, i,
+						page[i].ID, page[i].StartTime, want[i].ID, want[i].StartTime)
+				}
+			}
+		}
+	}
+}
+
+// racingQuerier deletes the plain appointments right before the page of
+// ListAppointments is read, as another request could between counting and
+// reading them outside of a snapshot.
+type racingQuerier struct {
+	querier
+}
+
+func (q racingQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if strings.Contains(query, "LIMIT") {
+		if _, err := q.querier.ExecContext(ctx, `UPDATE appointments SET deleted_at = `+now+`
+            WHERE COALESCE(recurrence, '') = ''`); err != nil {
+			return nil, err
+		}
+	}
+	return q.querier.QueryContext(ctx, query, args...)
+}
+
+func TestListAppointmentsConsistent(t *testing.T) {
+	d, user := newTestDatabase(t)
+	ctx := context.Background()
+	day := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	for i, recurrence := range []string{"FREQ=DAILY;COUNT=3", "", "", "", ""} {
+		start := day.AddDate(0, 0, 2+i)
+		if recurrence != "" {
+			start = day
+		}
+		a := &models.Appointment{
+			UserID:     user.ID,
+			Title:      "Appointment",
+			StartTime:  start,
+			EndTime:    start.Add(time.Hour),
+			Recurrence: recurrence,
+			Status:     models.StatusConfirmed,
+		}
+		if err := d.CreateAppointment(ctx, a, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start, end := day.AddDate(0, 0, -1), day.AddDate(0, 0, 7)
+
+	// A snapshot does not see changes made meanwhile.
+	var before, after int
+	err := readSnapshot(ctx, d.db, func(q querier) error {
+		if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM appointments WHERE deleted_at IS NULL`).Scan(&before); err != nil {
+			return err
+		}
+		if _, err := d.db.ExecContext(ctx, `UPDATE appointments SET deleted_at = `+now+` WHERE id = 3`); err != nil {
+			return err
+		}
+		return q.QueryRowContext(ctx, `SELECT COUNT(*) FROM appointments WHERE deleted_at IS NULL`).Scan(&after)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 5 || after != before {
+		t.Errorf("got %d appointments, then %d, want 5 both times", before, after)
+	}
+	page, total, err := d.ListAppointments(ctx, user.ID, start, end, Filter{}, 10, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 6 || len(page) != 2 || page[0].ID != 4 || page[1].ID != 5 {
+		t.Errorf("got %d of %d appointments, want appointments 4 and 5 of 6", len(page), total)
+	}
+
+	// Without a snapshot, the page may hold no plain appointment although
+	// counted, and the offset lies past the occurrences.
+	page, total, err = d.listAppointments(ctx, racingQuerier{d.db}, user.ID, start, end, Filter{}, 10, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 6 || len(page) != 0 {
+		t.Errorf("got %d of %d appointments, want none of 6", len(page), total)
+	}
+}
+
+func TestOverlapTolerance(t *testing.T) {
+	d, user := newTestDatabase(t)
+	d.overlapTolerance = 15 * time.Minute
+	ctx := context.Background()
+	at := func(hh, mm int) time.Time {
+		return time.Date(2030, 1, 7, hh, mm, 0, 0, time.UTC)
+	}
+	existing := &models.Appointment{
+		UserID:    user.ID,
+		Title:     "Workshop",
+		StartTime: at(9, 0),
+		EndTime:   at(10, 0),
+		Status:    models.StatusConfirmed,
+	}
+	if err := d.CreateAppointment(ctx, existing, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		about      string
+		start, end time.Time
+		conflict   bool
+	}{
+		{"overlapping the end by the tolerance", at(9, 45), at(11, 0), false},
+		{"overlapping the start by the tolerance", at(8, 0), at(9, 15), false},
+		{"overlapping the end by more than the tolerance", at(9, 44), at(11, 0), true},
+		{"covering it", at(8, 0), at(11, 0), true},
+		// Shorter than twice the tolerance.
+		{"short within it", at(9, 20), at(9, 30), true},
+		{"short at its start", at(9, 0), at(9, 10), true},
+		{"short mostly before its end", at(9, 50), at(10, 5), true},
+		{"short half after its end", at(9, 55), at(10, 5), false},
+		{"short after it", at(10, 0), at(10, 10), false},
+	}
+	for _, c := range cases {
+		a := &models.Appointment{
+			UserID:    user.ID,
+			Title:     "Call",
+			StartTime: c.start,
+			EndTime:   c.end,
+			Status:    models.StatusConfirmed,
+		}
+		var conflict *ConflictError
+		err := d.CreateAppointment(ctx, a, false)
+		switch {
+		case errors.As(err, &conflict) && !c.conflict:
+			t.Errorf("%s: got a conflict", c.about)
+		case err == nil && c.conflict:
+			t.Errorf("%s: got no conflict", c.about)
+		case err != nil && conflict == nil:
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if err == nil {
+			if err := d.DeleteAppointment(ctx, a.ID, user.ID, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRecurringConflicts(t *testing.T) {
+	d, user := newTestDatabase(t)
+	ctx := context.Background()
+	// Monday.
+	day := time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+	at := func(days, hh int) time.Time {
+		return day.AddDate(0, 0, days).Add(time.Duration(hh) * time.Hour)
+	}
+	for _, a := range []*models.Appointment{
+		{Title: "Dentist", StartTime: at(7, 9), EndTime: at(7, 10)},
+		{Title: "Review", StartTime: at(2, 14), EndTime: at(2, 15), Recurrence: "FREQ=WEEKLY"},
+	} {
+		a.UserID, a.Status = user.ID, models.StatusConfirmed
+		if err := d.CreateAppointment(ctx, a, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cases = []struct {
+		about      string
+		start      time.Time
+		recurrence string
+		conflict   string
+	}{
+		{"weekly hitting the one-off next week", at(0, 9), "FREQ=WEEKLY", "Dentist"},
+		{"weekly ending before the one-off", at(0, 9), "FREQ=WEEKLY;COUNT=1", ""},
+		{"daily hitting the series", at(0, 14), "FREQ=DAILY", "Review"},
+		{"twice weekly hitting the series", at(0, 14), "FREQ=WEEKLY;INTERVAL=3;BYDAY=MO,WE", "Review"},
+		{"daily next to both", at(0, 12), "FREQ=DAILY", ""},
+		{"one-off next week", at(7, 9), "", "Dentist"},
+	}
+	for _, c := range cases {
+		a := &models.Appointment{
+			UserID:     user.ID,
+			Title:      "Standup",
+			StartTime:  c.start,
+			EndTime:    c.start.Add(30 * time.Minute),
+			Recurrence: c.recurrence,
+			Status:     models.StatusConfirmed,
+		}
+		var conflict *ConflictError
+		err := d.CreateAppointment(ctx, a, false)
+		switch {
+		case errors.As(err, &conflict):
+			if got := conflict.Conflicts[0].Title; got != c.conflict {
+				t.Errorf("%s: got a conflict with %q, want %q", c.about, got, c.conflict)
+			}
+		case err != nil:
+			t.Fatalf("%s: %v", c.about, err)
+		case c.conflict != "":
+			t.Errorf("%s: got no conflict, want one with %q", c.about, c.conflict)
+		default:
+			if err := d.DeleteAppointment(ctx, a.ID, user.ID, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMergeAppointments(t *testing.T) {
+	d, user := newTestDatabase(t)
+	ctx := context.Background()
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	var originals []*models.Appointment
+	for i, title := range []string{"Planning", "Planning call"} {
+		a := &models.Appointment{
+			UserID:    user.ID,
+			Title:     title,
+			UID:       fmt.Sprintf("uid-%d@example.com", i),
+			StartTime: start.Add(time.Duration(i) * 30 * time.Minute),
+			EndTime:   start.Add(time.Duration(i+2) * 30 * time.Minute),
+			Status:    models.StatusConfirmed,
+		}
+		if err := d.CreateAppointment(ctx, a, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.CreateReminder(ctx, &models.Reminder{AppointmentID: a.ID, MinutesBefore: 10, Method: models.ReminderLog}); err != nil {
+			t.Fatal(err)
+		}
+		originals = append(originals, a)
+	}
+
+	merged, err := d.MergeAppointments(ctx, user.ID, originals[0].ID, originals[1].ID, originals[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Slug != originals[0].Slug || merged.UID != originals[0].UID {
+		t.Errorf("got slug %q and UID %q, want those of the kept appointment, %q and %q",
+			merged.Slug, merged.UID, originals[0].Slug, originals[0].UID)
+	}
+	if !merged.StartTime.Equal(originals[0].StartTime) || !merged.EndTime.Equal(originals[1].EndTime) {
+		t.Errorf("got %v to %v, want the span of both", merged.StartTime, merged.EndTime)
+	}
+	got, err := d.GetAppointment(ctx, merged.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.MergedFrom) != 2 || got.MergedFrom[0] != originals[0].ID || got.MergedFrom[1] != originals[1].ID {
+		t.Errorf("got merged from %v, want %d and %d", got.MergedFrom, originals[0].ID, originals[1].ID)
+	}
+
+	for _, o := range originals {
+		a, err := d.GetAppointmentIncludingDeleted(ctx, o.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == nil || a.DeletedAt == nil {
+			t.Errorf("appointment %d: got %v, want it deleted", o.ID, a)
+			continue
+		}
+		if a.MergedInto != merged.ID {
+			t.Errorf("appointment %d: got merged into %d, want %d", o.ID, a.MergedInto, merged.ID)
+		}
+		if reminders, err := d.ListReminders(ctx, o.ID); err != nil || len(reminders) != 1 {
+			t.Errorf("appointment %d: got reminders %v, %v, want them kept", o.ID, reminders, err)
+		}
+	}
+
+	series := &models.Appointment{
+		UserID:     user.ID,
+		Title:      "Standup",
+		StartTime:  start.AddDate(0, 0, 1),
+		EndTime:    start.AddDate(0, 0, 1).Add(15 * time.Minute),
+		Recurrence: "FREQ=DAILY",
+		Status:     models.StatusConfirmed,
+	}
+	if err := d.CreateAppointment(ctx, series, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MergeAppointments(ctx, user.ID, merged.ID, series.ID, merged.ID); !errors.Is(err, ErrMergeRecurring) {
+		t.Errorf("merging a series: got %v, want %v", err, ErrMergeRecurring)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cali.db")
+	open := func() *Database {
+		d, err := New(path, Options{BusyTimeout: 5 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		return d
+	}
+	// Two databases on the same file stand in for two server processes,
+	// each with its own connection pool.
+	first, second := open(), open()
+	if err := first.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	user := &models.User{Username: "alice"}
+	if err := first.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, appointments = 4, 25
+	day := time.Date(2030, 1, 7, 0, 0, 0, 0, time.UTC)
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, writers)
+	)
+	for i := 0; i < writers; i++ {
+		d := first
+		if i%2 == 1 {
+			d = second
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < appointments; n++ {
+				// Reading before writing, to find a free slug and
+				// conflicts, must not fail when another writer came
+				// first.
+				start := day.Add(time.Duration(i*appointments+n) * time.Hour)
+				a := &models.Appointment{
+					UserID:    user.ID,
+					Title:     "Standup",
+					StartTime: start,
+					EndTime:   start.Add(30 * time.Minute),
+					Status:    models.StatusConfirmed,
+				}
+				if err := d.CreateAppointment(ctx, a, false); err != nil {
+					errs <- fmt.Errorf("writer %d: create %d: %w", i, n, err)
+					return
+				}
+				a.Title = "Daily standup"
+				if err := d.UpdateAppointment(ctx, a, false); err != nil {
+					errs <- fmt.Errorf("writer %d: update %d: %w", i, n, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	_, total, err := first.ListAppointments(ctx, user.ID, day, day.AddDate(0, 0, writers*appointments/24+1), Filter{}, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != writers*appointments {
+		t.Errorf("got %d appointments, want %d", total, writers*appointments)
+	}
+}
+
+func TestNewDriver(t *testing.T) {
+	var cases = []struct {
+		driver string
+		ok     bool
+	}{
+		{"", true},
+		{DriverSQLite, true},
+		{"postgres", false},
+	}
+	for _, c := range cases {
+		d, err := New(filepath.Join(t.TempDir(), "cali.db"), Options{Driver: c.driver})
+		if err == nil {
+			d.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("driver %q: got error %v", c.driver, err)
+		}
+	}
+}
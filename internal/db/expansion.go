@@ -0,0 +1,176 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// expansionKey identifies the expansion of a recurring appointment over a
+// range. As the version of the appointment is part of the key, changes to
+// a series or its exceptions, which update it, make earlier entries
+// unreachable; they are evicted like any other unused entry.
+type expansionKey struct {
+	id         int64
+	version    int64
+	start, end int64
+}
+
+// maxCachedExpansion is the number of occurrences above which expansions
+// are not cached, as they would take much of the cache for a rare view.
+const maxCachedExpansion = 1000
+
+type expansionEntry struct {
+	key expansionKey
+	// starts are the start times of the occurrences; their ends follow
+	// from the duration of the appointment.
+	starts []time.Time
+	// before and after are the starts of the occurrences preceding the
+	// first and following the last one, if any.
+	before, after *time.Time
+	expires       time.Time
+}
+
+// cost is the share of the cache size taken by the entry.
+func (e *expansionEntry) cost() int {
+	return 1 + len(e.starts)
+}
+
+// expansionCache remembers the occurrences recurring appointments expand to
+// within a range, so repeated views of the same range, like a calendar
+// month, need not expand them again. Only the times of the occurrences are
+// kept. It holds up to size occurrences in total, counting each entry as
+// one more, evicting the least recently used entries, each for at most
+// ttl. Expansions of more than maxCachedExpansion occurrences are not
+// cached.
+type expansionCache struct {
+	size int
+	ttl  time.Duration
+
+	mu           sync.Mutex
+	entries      map[expansionKey]*list.Element
+	lru          *list.List
+	used         int
+	hits, misses uint64
+}
+
+func newExpansionCache(size int, ttl time.Duration) *expansionCache {
+	return &expansionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[expansionKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// expand returns the occurrences of a within [start, end], like
+// models.ExpandRecurrences. Cached occurrences are copies of a, so they
+// carry its current fields, only their times come from the cache.
+func (c *expansionCache) expand(a *models.Appointment, start, end time.Time) ([]*models.Appointment, error) {
+	if c == nil || a.Recurrence == "" {
+		return models.ExpandRecurrences(a, start, end)
+	}
+	key := expansionKey{a.ID, a.Version, start.UnixNano(), end.UnixNano()}
+	if entry, ok := c.get(key); ok {
+		duration := a.EndTime.Sub(a.StartTime)
+		occurrences := make([]*models.Appointment, len(entry.starts))
+		for i, t := range entry.starts {
+			copied := *a
+			copied.StartTime, copied.EndTime = t, t.Add(duration)
+			// Neighbours are copied, so callers cannot change the cache.
+			prev, next := entry.before, entry.after
+			if i > 0 {
+				prev = &entry.starts[i-1]
+			}
+			if i < len(entry.starts)-1 {
+				next = &entry.starts[i+1]
+			}
+			if prev != nil {
+				t := *prev
+				copied.PrevOccurrence = &t
+			}
+			if next != nil {
+				t := *next
+				copied.NextOccurrence = &t
+			}
+			occurrences[i] = &copied
+		}
+		return occurrences, nil
+	}
+
+	occurrences, err := models.ExpandRecurrences(a, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(occurrences) > maxCachedExpansion {
+		return occurrences, nil
+	}
+	entry := &expansionEntry{key: key, starts: make([]time.Time, len(occurrences))}
+	for i, o := range occurrences {
+		entry.starts[i] = o.StartTime
+	}
+	if len(occurrences) > 0 {
+		entry.before, entry.after = occurrences[0].PrevOccurrence, occurrences[len(occurrences)-1].NextOccurrence
+	}
+	c.put(entry)
+	return occurrences, nil
+}
+
+func (c *expansionCache) get(key expansionKey) (*expansionEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*expansionEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.hits++
+			return entry, true
+		}
+		c.remove(el)
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *expansionCache) put(entry *expansionEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	entry.expires = time.Now().Add(c.ttl)
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.used += entry.cost()
+	for c.used > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry from the cache. c.mu must be held.
+func (c *expansionCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*expansionEntry)
+	delete(c.entries, entry.key)
+	c.used -= entry.cost()
+}
+
+// hitRatio returns the share of lookups answered from the cache so far.
+func (c *expansionCache) hitRatio() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hits+c.misses == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(c.hits+c.misses)
+}
+
+// ExpansionCacheHitRatio returns the share of expansions of recurring
+// appointments answered from the cache so far, or zero if the cache is
+// disabled.
+func (d *Database) ExpansionCacheHitRatio() float64 {
+	if d.expansions == nil {
+		return 0
+	}
+	return d.expansions.hitRatio()
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+func TestExpansionCache(t *testing.T) {
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	a := &models.Appointment{
+		ID:         1,
+		Version:    1,
+		Title:      "Standup",
+		StartTime:  start,
+		EndTime:    start.Add(15 * time.Minute),
+		Recurrence: "FREQ=DAILY",
+	}
+	month := start.AddDate(0, 1, 0)
+
+	var cases = []struct {
+		about      string
+		start, end time.Time
+		// cached is whether the expansion is kept.
+		cached bool
+	}{
+		{"first week", start, start.AddDate(0, 0, 7), true},
+		{"later week", start.AddDate(0, 0, 14), start.AddDate(0, 0, 21), true},
+		{"empty", start.AddDate(0, 0, -7), start.Add(-time.Hour), true},
+		{"too many occurrences", start, start.AddDate(0, 0, maxCachedExpansion+1), false},
+	}
+	for _, c := range cases {
+		cache := newExpansionCache(100000, time.Hour)
+		want, err := models.ExpandRecurrences(a, c.start, c.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			got, err := cache.expand(a, c.start, c.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("%s, lookup %d: got %d occurrences, want %d", c.about, i, len(got), len(want))
+			}
+			for j := range got {
+				if !got[j].StartTime.Equal(want[j].StartTime) || !got[j].EndTime.Equal(want[j].EndTime) ||
+					!sameTime(got[j].PrevOccurrence, want[j].PrevOccurrence) ||
+					!sameTime(got[j].NextOccurrence, want[j].NextOccurrence) {
+					t.Errorf("%s, lookup %d: occurrence %d differs", c.about, i, j)
+				}
+			}
+		}
+		if hit := cache.hitRatio() > 0; hit != c.cached {
+			t.Errorf("%s: got cached %v, want %v", c.about, hit, c.cached)
+		}
+	}
+
+	// Ranges differing by a nanosecond are different keys; they fill the
+	// cache up to its size and no further.
+	cache := newExpansionCache(1000, time.Hour)
+	for i := 0; i < 200; i++ {
+		from := start.Add(time.Duration(i))
+		if _, err := cache.expand(a, from, month); err != nil {
+			t.Fatal(err)
+		}
+		if cache.used > cache.size || cache.lru.Len() != len(cache.entries) {
+			t.Fatalf("after %d expansions: %d used of %d, %d entries of %d", i+1,
+				cache.used, cache.size, len(cache.entries), cache.lru.Len())
+		}
+	}
+}
+
+// sameTime reports whether a and b are both nil or point to the same
+// instant.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// ErrIdempotencyKeyExists is returned when an idempotency key was recorded
+// by a concurrent request.
+var ErrIdempotencyKeyExists = errors.New("idempotency key exists")
+
+// IdempotencyKey records the appointment created by a request carrying an
+// Idempotency-Key header, so a retry of the request can be answered with it.
+type IdempotencyKey struct {
+	Key string
+	// RequestHash identifies the request body, so reuse of the key for
+	// another request can be detected.
+	RequestHash   string
+	AppointmentID int64
+	CreatedAt     time.Time
+}
+
+// GetIdempotencyKey returns the key of the user if it was recorded after
+// since, or nil.
+func (d *Database) GetIdempotencyKey(ctx context.Context, userID int64, key string, since time.Time) (*IdempotencyKey, error) {
+	k := &IdempotencyKey{Key: key}
+	err := d.db.QueryRowContext(ctx, `
+        SELECT request_hash, appointment_id, created_at
+        FROM idempotency_keys
+        WHERE user_id = ? AND key = ? AND created_at > ?`,
+		userID, key, since.UTC()).Scan(&k.RequestHash, &k.AppointmentID, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return k, nil
+}
+
+// CreateAppointmentWithKey creates an appointment like CreateAppointment and
+// records the idempotency key of the request for it, in one transaction.
+// Keys recorded before since have expired and are removed. It returns
+// ErrIdempotencyKeyExists if the key was recorded after since, e.g. by a
+// concurrent request; no appointment is created then. Conflicts are
+// checked as by CreateAppointment.
+func (d *Database) CreateAppointmentWithKey(ctx context.Context, a *models.Appointment, key, requestHash string, since time.Time, force bool) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Expired keys of all users are purged here, so the table only holds
+	// the keys of the expiry window.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at <= ?`, since.UTC()); err != nil {
+		return fmt.Errorf("failed to remove expired idempotency keys: %w", err)
+	}
+
+	// A concurrent request with the key would make this one conflict with
+	// the appointment it created, so the key is looked up first.
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM idempotency_keys WHERE user_id = ? AND key = ?)`,
+		a.UserID, key).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check idempotency key: %w", err)
+	}
+	if exists {
+		return ErrIdempotencyKeyExists
+	}
+	if !force {
+		if err := d.checkConflicts(ctx, tx, a); err != nil {
+			return err
+		}
+	}
+
+	insert, err := prepareInsert(ctx, tx)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	if err := insertAppointment(ctx, tx, insert, a); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO idempotency_keys (user_id, key, request_hash, appointment_id, created_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		a.UserID, key, requestHash, a.ID, time.Now().UTC())
+	if isUniqueViolation(err) {
+		return ErrIdempotencyKeyExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record idempotency key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit appointment: %w", err)
+	}
+	return nil
+}
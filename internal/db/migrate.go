@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migration is a single step of schema evolution. Steps run in order, each
+// in its own transaction, and are recorded in schema_migrations by their
+// position in migrations, starting at 1.
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations lists all schema changes, in SQLite's dialect. Append new
+// steps at the end; never modify or reorder steps that may have been
+// applied.
+var migrations = []migration{
+	{"create initial schema", createInitialSchema},
+	{"add appointment status", execMigration(`
+        ALTER TABLE appointments ADD COLUMN status TEXT NOT NULL DEFAULT 'confirmed'
+            CHECK (status IN ('confirmed', 'tentative', 'cancelled'))`)},
+	{"add appointment attendees", execMigration(`
+        CREATE TABLE appointment_attendees (
+            appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+            email TEXT NOT NULL COLLATE NOCASE,
+            response_status TEXT NOT NULL DEFAULT 'needs-action'
+                CHECK (response_status IN ('needs-action', 'accepted', 'declined', 'tentative')),
+            PRIMARY KEY (appointment_id, email)
+        );
+        -- Foreign keys are not enforced on our connections, so the
+        -- cascade is carried out by a trigger.
+        CREATE TRIGGER appointment_attendees_cascade AFTER DELETE ON appointments
+        BEGIN
+            DELETE FROM appointment_attendees WHERE appointment_id = OLD.id;
+        END`)},
+	{"add reminders", execMigration(`
+        CREATE TABLE reminders (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+            minutes_before INTEGER NOT NULL CHECK (minutes_before >= 0),
+            method TEXT NOT NULL CHECK (method IN ('log', 'webhook', 'desktop'))
+        );
+        CREATE INDEX idx_reminders_appointment ON reminders (appointment_id)`)},
+	// The optional unique index is recreated by SetUniqueAppointments,
+	// now ignoring deleted appointments.
+	{"add appointment deletion time", execMigration(`
+        ALTER TABLE appointments ADD COLUMN deleted_at TIMESTAMP;
+        DROP INDEX IF EXISTS idx_appointments_unique`)},
+	{"add appointment location", execMigration(`
+        ALTER TABLE appointments ADD COLUMN location TEXT;
+        ALTER TABLE appointments ADD COLUMN conference_url TEXT`)},
+	{"add appointment category and color", execMigration(`
+        ALTER TABLE appointments ADD COLUMN category TEXT;
+        ALTER TABLE appointments ADD COLUMN color TEXT;
+        CREATE INDEX idx_appointments_category ON appointments (user_id, category)`)},
+	{"add appointment exdates", execMigration(`
+        ALTER TABLE appointments ADD COLUMN exdates TEXT`)},
+	{"add idempotency keys", execMigration(`
+        CREATE TABLE idempotency_keys (
+            user_id INTEGER NOT NULL REFERENCES users(id),
+            key TEXT NOT NULL,
+            request_hash TEXT NOT NULL,
+            appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+            created_at TIMESTAMP NOT NULL,
+            UNIQUE (user_id, key)
+        );
+        CREATE INDEX idx_idempotency_keys_created ON idempotency_keys (created_at)`)},
+	{"add appointment uid", execMigration(`
+        ALTER TABLE appointments ADD COLUMN uid TEXT;
+        CREATE UNIQUE INDEX idx_appointments_uid ON appointments (user_id, uid)
+            WHERE uid IS NOT NULL AND deleted_at IS NULL`)},
+	{"add passwords and sessions", execMigration(`
+        ALTER TABLE users ADD COLUMN password_hash TEXT;
+        CREATE TABLE sessions (
+            id TEXT PRIMARY KEY,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            created_at TIMESTAMP NOT NULL,
+            expires_at TIMESTAMP NOT NULL
+        );
+        CREATE INDEX idx_sessions_expires ON sessions (expires_at)`)},
+	{"add user timezone", execMigration(`
+        ALTER TABLE users ADD COLUMN timezone TEXT`)},
+	{"add reminder sent time", execMigration(`
+        ALTER TABLE reminders ADD COLUMN sent_at TIMESTAMP`)},
+	{"add user email settings", execMigration(`
+        ALTER TABLE users ADD COLUMN email TEXT;
+        ALTER TABLE users ADD COLUMN email_notifications TEXT`)},
+	{"add webhook subscriptions", execMigration(`
+        CREATE TABLE webhooks (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            url TEXT NOT NULL,
+            events TEXT NOT NULL,
+            secret TEXT NOT NULL,
+            created_at TIMESTAMP NOT NULL
+        );
+        CREATE INDEX idx_webhooks_user ON webhooks (user_id)`)},
+	{"add attendee users", execMigration(`
+        ALTER TABLE appointment_attendees ADD COLUMN user_id INTEGER REFERENCES users(id);
+        CREATE INDEX idx_attendees_user ON appointment_attendees (user_id) WHERE user_id IS NOT NULL`)},
+	{"add reminder snoozes", execMigration(`
+        ALTER TABLE users ADD COLUMN reminders_snoozed_from TIMESTAMP;
+        ALTER TABLE users ADD COLUMN reminders_snoozed_until TIMESTAMP`)},
+	{"index appointments by update time", execMigration(`
+        CREATE INDEX idx_appointments_updated ON appointments (user_id, updated_at)`)},
+	{"add appointment versions", execMigration(`
+        ALTER TABLE appointments ADD COLUMN version INTEGER NOT NULL DEFAULT 1`)},
+	{"add user email verification", execMigration(`
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP`)},
+	{"record merged appointments", execMigration(`
+        ALTER TABLE appointments ADD COLUMN merged_into INTEGER REFERENCES appointments(id);
+        ALTER TABLE appointments ADD COLUMN merged_from TEXT`)},
+	{"add appointment reminder channels", execMigration(`
+        ALTER TABLE appointments ADD COLUMN reminder_channels TEXT`)},
+}
+
+// execMigration returns a migration step executing the given statements.
+func execMigration(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// Migrate brings the database schema up to date by applying all migrations
+// not yet recorded in schema_migrations. It is idempotent and safe to call
+// on every startup.
+func (d *Database) Migrate() error {
+	_, err := d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            description TEXT NOT NULL,
+            applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	err = d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		if err := d.applyMigration(i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", i+1, migrations[i].description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs m and records it as version in a single transaction.
+func (d *Database) applyMigration(version int, m migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, description) VALUES (?, ?)`,
+		version, m.description)
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// appointmentsTable is the definition of the appointments table as of the
+// initial migration.
+const appointmentsTable = `
+        CREATE TABLE appointments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            title TEXT NOT NULL,
+            original_title TEXT,
+            slug TEXT,
+            description TEXT,
+            start_time TIMESTAMP NOT NULL,
+            end_time TIMESTAMP NOT NULL,
+            all_day BOOLEAN NOT NULL DEFAULT 0,
+            timezone TEXT,
+            recurrence TEXT,
+            actual_start TIMESTAMP,
+            actual_end TIMESTAMP,
+            sort_order INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            CHECK (end_time > start_time OR (all_day AND end_time >= start_time))
+        )`
+
+// createInitialSchema creates the users and appointments tables. Databases
+// created before migrations were introduced may have an appointments table
+// lacking columns or an outdated check constraint; such a table is rebuilt,
+// keeping all rows.
+func createInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS users (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            username TEXT UNIQUE NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("failed to create users table: %w", err)
+	}
+
+	existing, err := tableDefinition(tx, "appointments")
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := tx.Exec(appointmentsTable); err != nil {
+			return fmt.Errorf("failed to create appointments table: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to inspect appointments table: %w", err)
+	case normalizeSQL(existing) != normalizeSQL(appointmentsTable):
+		if err := rebuildTable(tx, "appointments", appointmentsTable); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_slug
+            ON appointments (user_id, slug)`)
+	if err != nil {
+		return fmt.Errorf("failed to create slug index: %w", err)
+	}
+	return nil
+}
+
+// rebuildTable replaces table by one created with definition, copying the
+// values of all columns the old and new table have in common. Indexes on
+// the old table are dropped along with it.
+func rebuildTable(tx *sql.Tx, table, definition string) error {
+	oldColumns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	tmp := table + "_old"
+	if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, table, tmp)); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", table, err)
+	}
+	if _, err := tx.Exec(definition); err != nil {
+		return fmt.Errorf("failed to create %s: %w", table, err)
+	}
+	newColumns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	var common []string
+	for _, c := range newColumns {
+		for _, o := range oldColumns {
+			if c == o {
+				common = append(common, c)
+				break
+			}
+		}
+	}
+	columns := strings.Join(common, ", ")
+	_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s`, table, columns, columns, tmp))
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", table, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE %s`, tmp)); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// normalizeSQL collapses whitespace, so table definitions can be compared
+// regardless of formatting.
+func normalizeSQL(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
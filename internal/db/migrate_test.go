@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// baselineSchema is the schema created by InitSchema before migrations
+// were introduced.
+const baselineSchema = `
+        CREATE TABLE IF NOT EXISTS users (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            username TEXT UNIQUE NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS appointments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            title TEXT NOT NULL,
+            description TEXT,
+            start_time TIMESTAMP NOT NULL,
+            end_time TIMESTAMP NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            CHECK (end_time > start_time)
+        );`
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	d, err := New(filepath.Join(t.TempDir(), "cali.db"), Options{BusyTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	var rows = []struct {
+		title, description string
+		start, end         time.Time
+	}{
+		{"Standup", "Daily sync", start, start.Add(15 * time.Minute)},
+		{"Review", "", start.Add(time.Hour), start.Add(2 * time.Hour)},
+		{"Lunch", "With the team", start.Add(3 * time.Hour), start.Add(4 * time.Hour)},
+	}
+	if _, err := d.db.Exec(`INSERT INTO users (username) VALUES ('alice')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		_, err := d.db.Exec(`INSERT INTO appointments (user_id, title, description, start_time, end_time)
+            VALUES (1, ?, ?, ?, ?)`, r.title, r.description, r.start, r.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Migrating twice must be a no-op the second time.
+	for i := 0; i < 2; i++ {
+		if err := d.Migrate(); err != nil {
+			t.Fatalf("migrate #%d: %v", i+1, err)
+		}
+	}
+
+	var version, steps int
+	err = d.db.QueryRow(`SELECT MAX(version), COUNT(*) FROM schema_migrations`).Scan(&version, &steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) || steps != len(migrations) {
+		t.Errorf("got version %d after %d steps, want %d", version, steps, len(migrations))
+	}
+
+	user, err := d.GetUserByUsername(context.Background(), "alice")
+	if err != nil || user == nil {
+		t.Fatalf("user alice: got %v, %v", user, err)
+	}
+	for i, r := range rows {
+		a, err := d.GetAppointment(context.Background(), int64(i+1))
+		if err != nil {
+			t.Fatalf("appointment %d: %v", i+1, err)
+		}
+		if a == nil {
+			t.Errorf("appointment %d: missing after migration", i+1)
+			continue
+		}
+		if a.UserID != user.ID || a.Title != r.title || a.Description != r.description ||
+			!a.StartTime.Equal(r.start) || !a.EndTime.Equal(r.end) {
+			t.Errorf("appointment %d: got %q (%q) from %v to %v of user %d, want %q (%q) from %v to %v of user %d",
+				i+1, a.Title, a.Description, a.StartTime, a.EndTime, a.UserID,
+				r.title, r.description, r.start, r.end, user.ID)
+		}
+		if a.Status != "confirmed" {
+			t.Errorf("appointment %d: got status %q, want confirmed", i+1, a.Status)
+		}
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	d, _ := newTestDatabase(t)
+	if _, err := d.db.Exec(`INSERT INTO schema_migrations (version, description) VALUES (?, 'from the future')`,
+		len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Migrate(); err == nil {
+		t.Error("migrating a database of a newer version succeeded")
+	}
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// ErrReminderNotFound is returned when a reminder does not exist or belongs
+// to another appointment.
+var ErrReminderNotFound = errors.New("reminder not found")
+
+// DueReminder is a reminder whose time has come for an appointment, or one
+// occurrence of a recurring appointment.
+type DueReminder struct {
+	Reminder    models.Reminder
+	Appointment *models.Appointment
+	// At is when the reminder is due.
+	At time.Time
+}
+
+// reminderColumns lists the columns scanned by scanReminder.
+const reminderColumns = `id, appointment_id, minutes_before, method, sent_at`
+
+// scanReminder reads a row of reminderColumns.
+func scanReminder(row rowScanner) (models.Reminder, error) {
+	var (
+		r      models.Reminder
+		sentAt sql.NullTime
+	)
+	if err := row.Scan(&r.ID, &r.AppointmentID, &r.MinutesBefore, &r.Method, &sentAt); err != nil {
+		return r, err
+	}
+	if sentAt.Valid {
+		t := sentAt.Time.UTC()
+		r.SentAt = &t
+	}
+	return r, nil
+}
+
+// CreateReminder adds a reminder to an appointment and sets its ID.
+func (d *Database) CreateReminder(ctx context.Context, r *models.Reminder) error {
+	err := d.db.QueryRowContext(ctx, `
+        INSERT INTO reminders (appointment_id, minutes_before, method)
+        VALUES (?, ?, ?)
+        RETURNING id`, r.AppointmentID, r.MinutesBefore, r.Method).Scan(&r.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create reminder: %w", err)
+	}
+	return nil
+}
+
+// ListReminders returns the reminders of an appointment, earliest first.
+func (d *Database) ListReminders(ctx context.Context, appointmentID int64) ([]models.Reminder, error) {
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT `+reminderColumns+`
+        FROM reminders
+        WHERE appointment_id = ?
+        ORDER BY minutes_before DESC, id ASC`, appointmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reminders: %w", err)
+	}
+	defer rows.Close()
+
+	reminders := []models.Reminder{}
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %w", err)
+		}
+		reminders = append(reminders, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reminders: %w", err)
+	}
+	return reminders, nil
+}
+
+// GetReminder returns a reminder of an appointment, or nil if there is no
+// such reminder.
+func (d *Database) GetReminder(ctx context.Context, id, appointmentID int64) (*models.Reminder, error) {
+	r, err := scanReminder(d.db.QueryRowContext(ctx, `
+        SELECT `+reminderColumns+`
+        FROM reminders
+        WHERE id = ? AND appointment_id = ?`, id, appointmentID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminder: %w", err)
+	}
+	return &r, nil
+}
+
+// UpdateReminder changes the offset and method of a reminder. As the
+// reminder is due at a different time now, it counts as not sent yet. It
+// returns ErrReminderNotFound if there is no such reminder.
+func (d *Database) UpdateReminder(ctx context.Context, r *models.Reminder) error {
+	result, err := d.db.ExecContext(ctx, `
+        UPDATE reminders
+        SET minutes_before = ?, method = ?, sent_at = NULL
+        WHERE id = ? AND appointment_id = ?`,
+		r.MinutesBefore, r.Method, r.ID, r.AppointmentID)
+	if err != nil {
+		return fmt.Errorf("failed to update reminder: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrReminderNotFound
+	}
+	r.SentAt = nil
+	return nil
+}
+
+// MarkReminderSent records that a reminder was sent for the occurrence due
+// at at. Reminders deleted in the meantime are ignored.
+func (d *Database) MarkReminderSent(ctx context.Context, id int64, at time.Time) error {
+	if _, err := d.db.ExecContext(ctx, `UPDATE reminders SET sent_at = ? WHERE id = ?`, at.UTC(), id); err != nil {
+		return fmt.Errorf("failed to mark reminder sent: %w", err)
+	}
+	return nil
+}
+
+// DeleteReminder removes a reminder of an appointment. It returns
+// ErrReminderNotFound if there is no such reminder.
+func (d *Database) DeleteReminder(ctx context.Context, id, appointmentID int64) error {
+	result, err := d.db.ExecContext(ctx, `DELETE FROM reminders WHERE id = ? AND appointment_id = ?`, id, appointmentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete reminder: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrReminderNotFound
+	}
+	return nil
+}
+
+// SnoozeReminders suppresses the reminders of a user due until until, and
+// returns the effective snooze. A snooze still in effect at now is
+// extended, keeping its start; otherwise the snooze starts at now.
+func (d *Database) SnoozeReminders(ctx context.Context, userID int64, until, now time.Time) (models.Snooze, error) {
+	var sn models.Snooze
+	err := d.db.QueryRowContext(ctx, `
+        UPDATE users
+        SET reminders_snoozed_from = CASE
+                WHEN reminders_snoozed_from <= ?1 AND reminders_snoozed_until > ?1 THEN reminders_snoozed_from
+                ELSE ?1 END,
+            reminders_snoozed_until = ?2
+        WHERE id = ?3
+        RETURNING reminders_snoozed_from, reminders_snoozed_until`,
+		now.UTC(), until.UTC(), userID).Scan(&sn.From, &sn.Until)
+	if err != nil {
+		return sn, fmt.Errorf("failed to snooze reminders: %w", err)
+	}
+	sn.From, sn.Until = sn.From.UTC(), sn.Until.UTC()
+	return sn, nil
+}
+
+// ReminderSnoozes returns the snoozes lasting beyond after, by user ID.
+func (d *Database) ReminderSnoozes(ctx context.Context, after time.Time) (map[int64]models.Snooze, error) {
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT id, reminders_snoozed_from, reminders_snoozed_until
+        FROM users
+        WHERE reminders_snoozed_until > ?`, after.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snoozes: %w", err)
+	}
+	defer rows.Close()
+
+	snoozes := make(map[int64]models.Snooze)
+	for rows.Next() {
+		var (
+			userID int64
+			sn     models.Snooze
+		)
+		if err := rows.Scan(&userID, &sn.From, &sn.Until); err != nil {
+			return nil, fmt.Errorf("failed to scan snooze: %w", err)
+		}
+		snoozes[userID] = models.Snooze{From: sn.From.UTC(), Until: sn.Until.UTC()}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating snoozes: %w", err)
+	}
+	return snoozes, nil
+}
+
+// DueReminders returns the reminders due within (from, to], ordered by when
+// they are due. Reminders of recurring appointments are due once per
+// occurrence. Cancelled appointments are not reminded of, and reminders
+// already sent for an occurrence are not returned again.
+func (d *Database) DueReminders(ctx context.Context, from, to time.Time) ([]DueReminder, error) {
+	return d.dueReminders(ctx, 0, from, to)
+}
+
+// UserDueReminders is like DueReminders, but only returns the reminders of
+// the appointments of a user.
+func (d *Database) UserDueReminders(ctx context.Context, userID int64, from, to time.Time) ([]DueReminder, error) {
+	return d.dueReminders(ctx, userID, from, to)
+}
+
+// dueReminders returns the reminders due within (from, to] of the user
+// with userID, or of all users if it is zero.
+func (d *Database) dueReminders(ctx context.Context, userID int64, from, to time.Time) ([]DueReminder, error) {
+	// A reminder is due at most MaxReminderMinutes before the start, so
+	// only appointments starting within that much after to qualify.
+	const candidates = `
+        status != 'cancelled'
+        AND deleted_at IS NULL
+        AND (COALESCE(recurrence, '') != '' OR (start_time > ? AND start_time <= ?))
+        AND (? = 0 OR user_id = ?)`
+	horizon := to.Add(models.MaxReminderMinutes * time.Minute)
+	args := []interface{}{from.UTC(), horizon.UTC(), userID, userID}
+
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT r.id, r.appointment_id, r.minutes_before, r.method, r.sent_at
+        FROM reminders r
+        JOIN appointments ON appointments.id = r.appointment_id
+        WHERE `+candidates, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reminders: %w", err)
+	}
+	defer rows.Close()
+
+	reminders := make(map[int64][]models.Reminder)
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %w", err)
+		}
+		reminders[r.AppointmentID] = append(reminders[r.AppointmentID], r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reminders: %w", err)
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+
+	appts, err := d.db.QueryContext(ctx, `
+        SELECT `+appointmentColumns+`
+        FROM appointments
+        WHERE id IN (SELECT appointment_id FROM reminders)
+        AND `+candidates, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list appointments: %w", err)
+	}
+	defer appts.Close()
+
+	var due []DueReminder
+	for appts.Next() {
+		a, err := scanAppointment(appts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan appointment: %w", err)
+		}
+		for _, r := range reminders[a.ID] {
+			lead := time.Duration(r.MinutesBefore) * time.Minute
+			occurrences, err := models.ExpandRecurrences(a, from, to.Add(lead+a.EndTime.Sub(a.StartTime)))
+			if err != nil {
+				return nil, fmt.Errorf("appointment %d: %w", a.ID, err)
+			}
+			for _, o := range occurrences {
+				at := o.StartTime.Add(-lead)
+				if r.SentAt != nil && !at.After(*r.SentAt) {
+					continue
+				}
+				if at.After(from) && !at.After(to) {
+					due = append(due, DueReminder{Reminder: r, Appointment: o, At: at})
+				}
+			}
+		}
+	}
+	if err = appts.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating appointments: %w", err)
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+	return due, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateSession records a login session of the user, valid until
+// expiresAt. Expired sessions of all users are removed in passing.
+func (d *Database) CreateSession(ctx context.Context, id string, userID int64, expiresAt time.Time) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("failed to remove expired sessions: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO sessions (id, user_id, created_at, expires_at)
+        VALUES (?, ?, ?, ?)`,
+		id, userID, now, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session: %w", err)
+	}
+	return nil
+}
+
+// GetSessionUser returns the ID of the user of a session, or zero if there
+// is no such session or it expired.
+func (d *Database) GetSessionUser(ctx context.Context, id string) (int64, error) {
+	var userID int64
+	err := d.db.QueryRowContext(ctx, `
+        SELECT user_id FROM sessions WHERE id = ? AND expires_at > ?`,
+		id, time.Now().UTC()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get session: %w", err)
+	}
+	return userID, nil
+}
+
+// DeleteSession ends a session. Ending an unknown session is no error.
+func (d *Database) DeleteSession(ctx context.Context, id string) error {
+	if _, err := d.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// This file holds what is specific to SQLite: connection parameters,
+// functions used in queries, error codes and the schema catalog. Queries
+// elsewhere stick to SQL that other databases understand as well, apart
+// from ? placeholders; the migrations are written for SQLite.
+
+// DriverSQLite is the name of the SQLite driver, the only one supported.
+const DriverSQLite = "sqlite3"
+
+// sqliteDSN returns the data source name opening the SQLite database at
+// path, with the connection parameters described at New.
+func sqliteDSN(path string, busyTimeout time.Duration) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_journal_mode=WAL&_busy_timeout=%d&_foreign_keys=on&_txlock=immediate",
+		path, sep, busyTimeout.Milliseconds())
+}
+
+// now is the SQL expression for the current time used for updated_at. It
+// has millisecond precision, unlike CURRENT_TIMESTAMP, so changes within
+// the same second are told apart.
+const now = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// touched are the assignments recording a change of an appointment.
+const touched = `updated_at = ` + now + `, version = version + 1`
+
+// attendeesColumn aggregates the attendees of an appointment into a JSON
+// array, ordered by email, as the last of appointmentColumns.
+const attendeesColumn = `(SELECT json_group_array(json_object('email', email, 'user_id', user_id, 'response_status', response_status))
+                FROM (SELECT email, user_id, response_status FROM appointment_attendees
+                      WHERE appointment_id = appointments.id ORDER BY email))`
+
+// sameInstant returns the condition that the timestamp column holds the
+// instant given as parameter. Instants are compared, as stored timestamps
+// and parameters differ in format.
+func sameInstant(column string) string {
+	return `julianday(` + column + `) = julianday(?)`
+}
+
+// epochMillis returns the expression converting the timestamp expression
+// to milliseconds since the epoch.
+func epochMillis(expr string) string {
+	return `CAST((julianday(` + expr + `) - 2440587.5) * 86400000 AS INTEGER)`
+}
+
+// uniqueViolation returns the columns of the unique or primary key
+// constraint err violates, as reported by SQLite, e.g.
+// "appointments.user_id, appointments.slug", or "" if err is no such
+// violation.
+func uniqueViolation(err error) string {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) ||
+		(sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique && sqliteErr.ExtendedCode != sqlite3.ErrConstraintPrimaryKey) {
+		return ""
+	}
+	_, columns, _ := strings.Cut(sqliteErr.Error(), ": ")
+	return columns
+}
+
+// tableDefinition returns the CREATE TABLE statement of table, or
+// sql.ErrNoRows if there is no such table.
+func tableDefinition(tx *sql.Tx, table string) (string, error) {
+	var definition string
+	err := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&definition)
+	return definition, err
+}
+
+// tableColumns returns the column names of table in order.
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// readSnapshot calls fn with a connection in a deferred transaction, so
+// its queries see a single snapshot of the database. Unlike transactions
+// begun with BeginTx, which are immediate (see sqliteDSN), it does not
+// take the write lock. The transaction is rolled back afterwards.
+func readSnapshot(ctx context.Context, db *sql.DB, fn func(q querier) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `BEGIN DEFERRED`); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	err = fn(conn)
+	// Also when ctx is done, so the connection returns to the pool
+	// without a transaction.
+	if _, rollbackErr := conn.ExecContext(context.Background(), `ROLLBACK`); rollbackErr != nil && err == nil {
+		err = fmt.Errorf("failed to end transaction: %w", rollbackErr)
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// Store is the storage the server works with. Database implements it on
+// SQLite; the SQL specific to SQLite is kept in sqlite.go, so that another
+// backend can share the rest. Methods are documented on Database.
+type Store interface {
+	AppointmentStore
+	UserStore
+	ReminderStore
+	WebhookStore
+
+	Migrate() error
+	SetUniqueAppointments(enabled bool) error
+	Stats() sql.DBStats
+	ExpansionCacheHitRatio() float64
+	Close() error
+}
+
+var _ Store = (*Database)(nil)
+
+// AppointmentStore stores appointments, their attendees and idempotency
+// keys of requests creating them.
+type AppointmentStore interface {
+	CreateAppointment(ctx context.Context, a *models.Appointment, force bool) error
+	CreateAppointmentWithKey(ctx context.Context, a *models.Appointment, key, requestHash string, since time.Time, force bool) error
+	CreateAppointments(ctx context.Context, appointments []*models.Appointment, force bool) error
+	PreviewAppointments(ctx context.Context, appointments []*models.Appointment, force bool) error
+	GetIdempotencyKey(ctx context.Context, userID int64, key string, since time.Time) (*IdempotencyKey, error)
+	GetAppointment(ctx context.Context, id int64) (*models.Appointment, error)
+	GetAppointmentIncludingDeleted(ctx context.Context, id int64) (*models.Appointment, error)
+	GetAppointmentBySlug(ctx context.Context, userID int64, slug string) (*models.Appointment, error)
+	GetAppointmentByUID(ctx context.Context, userID int64, uid string) (*models.Appointment, error)
+	ListAppointments(ctx context.Context, userID int64, start, end time.Time, filter Filter, limit, offset int) ([]*models.Appointment, int, error)
+	StreamAppointments(ctx context.Context, userID int64, start, end time.Time, filter Filter, fn func(*models.Appointment) error) error
+	ListOverlappingAppointments(ctx context.Context, userID int64, start, end time.Time) ([]*models.Appointment, error)
+	ListUpcoming(ctx context.Context, userID int64, from time.Time, limit int) ([]*models.Appointment, error)
+	SearchAppointments(ctx context.Context, userID int64, query string, limit int) ([]*models.Appointment, error)
+	CountAppointments(ctx context.Context, userID int64, start, end time.Time) (int, error)
+	CountAppointmentsByDay(ctx context.Context, userID int64, start, end time.Time, loc *time.Location) (map[string]int, error)
+	ListCategories(ctx context.Context, userID int64) ([]string, error)
+	FindConflict(ctx context.Context, userID int64, start, end time.Time, excludeID int64) (*models.Appointment, error)
+	CollectionTag(ctx context.Context, userID int64) (string, error)
+	WalkAppointments(ctx context.Context, userID int64, fn func(*models.Appointment) error) error
+	WalkChangedAppointments(ctx context.Context, userID int64, since time.Time, fn func(*models.Appointment) error) error
+	UpdateAppointment(ctx context.Context, a *models.Appointment, force bool) error
+	PatchAppointment(ctx context.Context, id, userID, version int64, fields map[string]interface{}, force bool) (*models.Appointment, error)
+	MoveAppointment(ctx context.Context, a *models.Appointment, force bool) error
+	UpdateSeries(ctx context.Context, a *models.Appointment) (*models.Appointment, error)
+	SetActualTimes(ctx context.Context, a *models.Appointment) error
+	ReorderAppointments(ctx context.Context, userID int64, ids []int64) error
+	MergeAppointments(ctx context.Context, userID, firstID, secondID, keepID int64) (*models.Appointment, error)
+	DeleteAppointment(ctx context.Context, id, userID, version int64) error
+	RestoreAppointment(ctx context.Context, id, userID int64) (*models.Appointment, error)
+	RestoreAppointments(ctx context.Context, appointments []*models.Appointment, skipExisting, dryRun bool) ([]*models.Appointment, error)
+	RestoreBackup(ctx context.Context, userID int64, appointments []*models.Appointment) (inserted, replaced []*models.Appointment, err error)
+	InviteAttendee(ctx context.Context, appointmentID, userID int64, at *models.Attendee) error
+	ListInvitations(ctx context.Context, userID int64) ([]*models.Appointment, error)
+	GetInvitation(ctx context.Context, appointmentID, userID int64) (*models.Appointment, error)
+	RespondToInvitation(ctx context.Context, appointmentID, userID int64, status string) error
+}
+
+// UserStore stores users and their login sessions.
+type UserStore interface {
+	CreateUser(ctx context.Context, u *models.User) error
+	CreateFirstUser(ctx context.Context, u *models.User) error
+	GetUser(ctx context.Context, id int64) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	UpdateUserSettings(ctx context.Context, u *models.User) error
+	VerifyEmail(ctx context.Context, userID int64, email string) error
+	CreateSession(ctx context.Context, id string, userID int64, expiresAt time.Time) error
+	GetSessionUser(ctx context.Context, id string) (int64, error)
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// ReminderStore stores reminders of appointments and when they were sent.
+type ReminderStore interface {
+	CreateReminder(ctx context.Context, r *models.Reminder) error
+	GetReminder(ctx context.Context, id, appointmentID int64) (*models.Reminder, error)
+	ListReminders(ctx context.Context, appointmentID int64) ([]models.Reminder, error)
+	UpdateReminder(ctx context.Context, r *models.Reminder) error
+	DeleteReminder(ctx context.Context, id, appointmentID int64) error
+	DueReminders(ctx context.Context, from, to time.Time) ([]DueReminder, error)
+	UserDueReminders(ctx context.Context, userID int64, from, to time.Time) ([]DueReminder, error)
+	MarkReminderSent(ctx context.Context, id int64, at time.Time) error
+	SnoozeReminders(ctx context.Context, userID int64, until, now time.Time) (models.Snooze, error)
+	ReminderSnoozes(ctx context.Context, after time.Time) (map[int64]models.Snooze, error)
+}
+
+// WebhookStore stores webhook subscriptions of users.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, h *models.Webhook) error
+	ListWebhooks(ctx context.Context, userID int64) ([]models.Webhook, error)
+	CountWebhooks(ctx context.Context, userID int64) (int, error)
+	DeleteWebhook(ctx context.Context, id, userID int64) error
+}
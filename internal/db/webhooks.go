@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist or belongs to
+// another user.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// CreateWebhook adds a webhook subscription and sets its ID and creation
+// time.
+func (d *Database) CreateWebhook(ctx context.Context, h *models.Webhook) error {
+	h.CreatedAt = time.Now().UTC()
+	err := d.db.QueryRowContext(ctx, `
+        INSERT INTO webhooks (user_id, url, events, secret, created_at)
+        VALUES (?, ?, ?, ?, ?)
+        RETURNING id`,
+		h.UserID, h.URL, strings.Join(h.Events, ","), h.Secret, h.CreatedAt).Scan(&h.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns the webhooks of a user, oldest first, including
+// their secrets.
+func (d *Database) ListWebhooks(ctx context.Context, userID int64) ([]models.Webhook, error) {
+	rows, err := d.db.QueryContext(ctx, `
+        SELECT id, user_id, url, events, secret, created_at
+        FROM webhooks
+        WHERE user_id = ?
+        ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var (
+			h      models.Webhook
+			events string
+		)
+		if err := rows.Scan(&h.ID, &h.UserID, &h.URL, &events, &h.Secret, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		h.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// CountWebhooks returns the number of webhooks of a user.
+func (d *Database) CountWebhooks(ctx context.Context, userID int64) (int, error) {
+	var n int
+	if err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhooks WHERE user_id = ?`, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteWebhook removes a webhook of a user. It returns ErrWebhookNotFound
+// if there is no such webhook.
+func (d *Database) DeleteWebhook(ctx context.Context, id, userID int64) error {
+	result, err := d.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
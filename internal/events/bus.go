@@ -0,0 +1,159 @@
+// Package events distributes appointment changes to interested clients.
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// Type is the kind of a change.
+type Type string
+
+const (
+	Created Type = "created"
+	Updated Type = "updated"
+	Deleted Type = "deleted"
+)
+
+var (
+	// ErrExpired is returned for a sequence number older than the retained
+	// history, or unknown to the bus, e.g. after a restart. Clients should
+	// reload their data and continue from the current sequence number.
+	ErrExpired = errors.New("change history expired")
+	// ErrClosed is returned by Wait once the bus is closed.
+	ErrClosed = errors.New("bus closed")
+)
+
+// Change is a change to an appointment.
+type Change struct {
+	Seq           int64     `json:"seq"`
+	Type          Type      `json:"type"`
+	AppointmentID int64     `json:"appointment_id"`
+	Time          time.Time `json:"time"`
+	// Appointment is the appointment after the change, if published with
+	// PublishAppointments.
+	Appointment *models.Appointment `json:"-"`
+
+	userID int64
+}
+
+// Bus keeps a bounded history of recent changes in memory and wakes waiting
+// clients when changes are published. Sequence numbers are shared by all
+// users and start over when the process restarts.
+type Bus struct {
+	mu      sync.Mutex
+	seq     int64
+	size    int
+	history []Change
+	// notify is closed and replaced on every publish.
+	notify chan struct{}
+	closed bool
+}
+
+// NewBus returns a bus retaining the latest size changes.
+func NewBus(size int) *Bus {
+	return &Bus{size: size, notify: make(chan struct{})}
+}
+
+// Publish records a change of the given appointments of a user.
+func (b *Bus) Publish(userID int64, typ Type, appointmentIDs ...int64) {
+	changes := make([]Change, len(appointmentIDs))
+	for i, id := range appointmentIDs {
+		changes[i] = Change{Type: typ, AppointmentID: id}
+	}
+	b.publish(userID, changes)
+}
+
+// PublishAppointments is like Publish, but keeps the changed appointments
+// along with the changes.
+func (b *Bus) PublishAppointments(userID int64, typ Type, appts ...*models.Appointment) {
+	changes := make([]Change, len(appts))
+	for i, a := range appts {
+		changes[i] = Change{Type: typ, AppointmentID: a.ID, Appointment: a}
+	}
+	b.publish(userID, changes)
+}
+
+func (b *Bus) publish(userID int64, changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, c := range changes {
+		b.seq++
+		c.Seq, c.Time, c.userID = b.seq, now, userID
+		b.history = append(b.history, c)
+	}
+	if n := len(b.history) - b.size; n > 0 {
+		b.history = append(b.history[:0:0], b.history[n:]...)
+	}
+	if !b.closed {
+		close(b.notify)
+		b.notify = make(chan struct{})
+	}
+}
+
+// Seq returns the sequence number of the latest change.
+func (b *Bus) Seq() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// since returns the changes of a user after seq. If there are none, it also
+// returns a channel closed on the next publish.
+func (b *Bus) since(userID, seq int64) ([]Change, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if seq > b.seq || (len(b.history) > 0 && seq < b.history[0].Seq-1) {
+		return nil, nil, ErrExpired
+	}
+	var changes []Change
+	for _, c := range b.history {
+		if c.Seq > seq && c.userID == userID {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) > 0 {
+		return changes, nil, nil
+	}
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+	return nil, b.notify, nil
+}
+
+// Wait returns the changes of a user after seq, blocking until there are
+// any, ctx is done or the bus is closed.
+func (b *Bus) Wait(ctx context.Context, userID, seq int64) ([]Change, error) {
+	for {
+		changes, notify, err := b.since(userID, seq)
+		if err != nil || len(changes) > 0 {
+			return changes, err
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close wakes all waiting clients, which then return ErrClosed. Changes
+// can still be published, but no longer wake anyone.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.notify)
+	}
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used when no locale is requested or the requested one is
+// unknown.
+const DefaultLocale = "en-GB"
+
+// Locale describes how dates and times are written in human-readable
+// exports. The JSON API always uses RFC3339 and is not affected.
+type Locale struct {
+	Tag      string
+	Weekdays [7]string  // Sunday first, as time.Weekday
+	Months   [12]string // January first
+	// DayPattern is used for day headings and may contain the placeholders
+	// {weekday}, {day}, {month} and {year}.
+	DayPattern string
+	// DateLayout and TimeLayout are Go reference time layouts for compact
+	// dates and clock times.
+	DateLayout string
+	TimeLayout string
+}
+
+var (
+	englishWeekdays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	englishMonths   = [12]string{"January", "February", "March", "April", "May", "June", "July",
+		"August", "September", "October", "November", "December"}
+)
+
+var locales = map[string]*Locale{
+	"en-US": {
+		Tag:        "en-US",
+		Weekdays:   englishWeekdays,
+		Months:     englishMonths,
+		DayPattern: "{weekday}, {month} {day}, {year}",
+		DateLayout: "01/02/2006",
+		TimeLayout: "3:04 PM",
+	},
+	"en-GB": {
+		Tag:        "en-GB",
+		Weekdays:   englishWeekdays,
+		Months:     englishMonths,
+		DayPattern: "{weekday}, {day} {month} {year}",
+		DateLayout: "02/01/2006",
+		TimeLayout: "15:04",
+	},
+	"de-DE": {
+		Tag:        "de-DE",
+		Weekdays:   [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		Months:     [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		DayPattern: "{weekday}, {day}. {month} {year}",
+		DateLayout: "02.01.2006",
+		TimeLayout: "15:04",
+	},
+	"fr-FR": {
+		Tag:        "fr-FR",
+		Weekdays:   [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		Months:     [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		DayPattern: "{weekday} {day} {month} {year}",
+		DateLayout: "02/01/2006",
+		TimeLayout: "15:04",
+	},
+	"es-ES": {
+		Tag:        "es-ES",
+		Weekdays:   [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		Months:     [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		DayPattern: "{weekday}, {day} de {month} de {year}",
+		DateLayout: "02/01/2006",
+		TimeLayout: "15:04",
+	},
+}
+
+// LookupLocale returns the locale for a tag like "de-DE", "de_DE" or just
+// "de". Unknown or empty tags fall back to DefaultLocale.
+func LookupLocale(tag string) *Locale {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	for k, l := range locales {
+		if strings.EqualFold(k, tag) {
+			return l
+		}
+	}
+	// Match on language only, preferring the default for English.
+	if lang, _, _ := strings.Cut(tag, "-"); lang != "" {
+		if strings.EqualFold(lang, "en") {
+			return locales[DefaultLocale]
+		}
+		for k, l := range locales {
+			if prefix, _, _ := strings.Cut(k, "-"); strings.EqualFold(prefix, lang) {
+				return l
+			}
+		}
+	}
+	return locales[DefaultLocale]
+}
+
+// FormatDay formats t as a long day heading, e.g. "Monday, 15 January 2024".
+func (l *Locale) FormatDay(t time.Time) string {
+	return strings.NewReplacer(
+		"{weekday}", l.Weekdays[t.Weekday()],
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", l.Months[t.Month()-1],
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(l.DayPattern)
+}
+
+// FormatDate formats t as a compact date.
+func (l *Locale) FormatDate(t time.Time) string {
+	return t.Format(l.DateLayout)
+}
+
+// FormatTime formats the clock time of t.
+func (l *Locale) FormatTime(t time.Time) string {
+	return t.Format(l.TimeLayout)
+}
@@ -0,0 +1,64 @@
+// Package export renders appointments into human-readable formats.
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/miku/cali/internal/models"
+)
+
+// PDFAgenda writes a day-by-day agenda of appointments as a PDF document.
+// Appointments are expected to be sorted by start time and are grouped by
+// their start date in loc and dates and times are formatted according to lc.
+func PDFAgenda(w io.Writer, title string, appointments []*models.Appointment, loc *time.Location, lc *Locale) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(title, true)
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+
+	if len(appointments) == 0 {
+		pdf.SetFont("Helvetica", "I", 11)
+		pdf.CellFormat(0, 8, "No appointments.", "", 1, "L", false, 0, "")
+		return pdf.Output(w)
+	}
+
+	var day string
+	for _, a := range appointments {
+		start, end := a.StartTime.In(loc), a.EndTime.In(loc)
+		if d := start.Format(time.DateOnly); d != day {
+			day = d
+			pdf.Ln(4)
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.CellFormat(0, 8, tr(lc.FormatDay(start)), "B", 1, "L", false, 0, "")
+			pdf.Ln(1)
+		}
+
+		until := lc.FormatTime(end)
+		if end.Format(time.DateOnly) != day {
+			until = lc.FormatDate(end) + " " + until
+		}
+		pdf.SetFont("Helvetica", "", 11)
+		pdf.CellFormat(40, 7, lc.FormatTime(start)+" - "+until, "", 0, "L", false, 0, "")
+		pdf.MultiCell(0, 7, tr(a.Title), "", "L", false)
+
+		if a.Place != "" {
+			pdf.SetX(60)
+			pdf.SetFont("Helvetica", "", 9)
+			pdf.MultiCell(0, 5, tr(a.Place), "", "L", false)
+		}
+		if a.Description != "" {
+			pdf.SetX(60)
+			pdf.SetFont("Helvetica", "I", 9)
+			pdf.MultiCell(0, 5, tr(a.Description), "", "L", false)
+		}
+	}
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,316 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a VEVENT read from an iCalendar stream.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	// Category is the first value of the CATEGORIES property, if any.
+	Category string
+	// Conference is the first http or https URI of a CONFERENCE
+	// property (RFC 7986), if any. Others, like tel: URIs, are ignored.
+	Conference string
+	Start      time.Time
+	End        time.Time
+	// TZID is the timezone DTSTART was given in, if any.
+	TZID string
+	// AllDay is set if DTSTART is a date rather than a date-time.
+	AllDay bool
+	RRule  string
+	// ExDates are the starts of occurrences excluded from RRule.
+	ExDates []time.Time
+	// Override is set if the event has a RECURRENCE-ID, i.e. it modifies a
+	// single occurrence of a series given by another event with the UID.
+	Override bool
+	// Status is the STATUS property, e.g. TENTATIVE, if present.
+	Status    string
+	Attendees []Attendee
+	// Err records the first problem found in the event; such events
+	// should be skipped.
+	Err error
+}
+
+// Attendee is an ATTENDEE property with a mailto address.
+type Attendee struct {
+	Email string
+	// PartStat is the PARTSTAT parameter, e.g. ACCEPTED, if present.
+	PartStat string
+}
+
+// maxLineLength limits the length of an unfolded content line.
+const maxLineLength = 1 << 20
+
+// Decode reads all VEVENTs from r. Floating times, which carry neither a UTC
+// designator nor a TZID, and dates are interpreted in loc. Problems within a
+// single event are reported in its Err field; an error is only returned if
+// the stream cannot be read or contains no calendar.
+func Decode(r io.Reader, loc *time.Location) ([]*Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		events   []*Event
+		stack    []string
+		ev       *Event
+		hasStart bool
+		hasEnd   bool
+		duration time.Duration
+		calendar bool
+	)
+	for _, line := range lines {
+		name, params, value, err := parseLine(line)
+		if err != nil {
+			if ev != nil && ev.Err == nil {
+				ev.Err = err
+			}
+			continue
+		}
+		switch name {
+		case "BEGIN":
+			component := strings.ToUpper(value)
+			stack = append(stack, component)
+			if component == "VCALENDAR" {
+				calendar = true
+			}
+			if component == "VEVENT" && len(stack) == 2 {
+				ev, hasStart, hasEnd, duration = &Event{}, false, false, 0
+			}
+			continue
+		case "END":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if strings.EqualFold(value, "VEVENT") && ev != nil && len(stack) == 1 {
+				switch {
+				case ev.Err != nil:
+				case !hasStart:
+					ev.Err = fmt.Errorf("missing DTSTART")
+				case !hasEnd && duration != 0:
+					ev.End = ev.Start.Add(duration)
+				case !hasEnd && ev.AllDay:
+					ev.End = ev.Start.AddDate(0, 0, 1)
+				case !hasEnd:
+					ev.End = ev.Start
+				}
+				events = append(events, ev)
+				ev = nil
+			}
+			continue
+		}
+		// Only properties of the event itself are of interest, not those
+		// of nested components like VALARM.
+		if ev == nil || len(stack) != 2 || stack[1] != "VEVENT" || ev.Err != nil {
+			continue
+		}
+		switch name {
+		case "UID":
+			ev.UID = value
+		case "SUMMARY":
+			ev.Summary = UnescapeText(value)
+		case "DESCRIPTION":
+			ev.Description = UnescapeText(value)
+		case "CATEGORIES":
+			if ev.Category == "" {
+				ev.Category = UnescapeText(firstValue(value))
+			}
+		case "LOCATION":
+			ev.Location = UnescapeText(value)
+		case "CONFERENCE":
+			lower := strings.ToLower(value)
+			if ev.Conference == "" && (strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")) {
+				ev.Conference = value
+			}
+		case "RRULE":
+			ev.RRule = value
+		case "RECURRENCE-ID":
+			ev.Override = true
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseTime(v, params, loc)
+				if err != nil {
+					ev.Err = err
+					break
+				}
+				ev.ExDates = append(ev.ExDates, t)
+			}
+		case "STATUS":
+			ev.Status = value
+		case "ATTENDEE":
+			// Attendees without an email address, e.g. given by
+			// telephone number, are ignored.
+			if len(value) > 7 && strings.EqualFold(value[:7], "mailto:") {
+				ev.Attendees = append(ev.Attendees, Attendee{
+					Email:    value[7:],
+					PartStat: params["PARTSTAT"],
+				})
+			}
+		case "DTSTART":
+			ev.Start, ev.AllDay, ev.Err = parseTime(value, params, loc)
+			ev.TZID = params["TZID"]
+			hasStart = true
+		case "DTEND":
+			ev.End, _, ev.Err = parseTime(value, params, loc)
+			hasEnd = true
+		case "DURATION":
+			duration, ev.Err = ParseDuration(value)
+		}
+	}
+	if !calendar {
+		return nil, fmt.Errorf("no VCALENDAR found")
+	}
+	return events, nil
+}
+
+// unfold reads content lines, joining folded continuation lines.
+func unfold(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read calendar: %w", err)
+	}
+	return lines, nil
+}
+
+// parseLine splits a content line like "DTSTART;TZID=Europe/Berlin:2024..."
+// into its upper-cased name, parameters and value. Colons and semicolons
+// within quoted parameter values are respected.
+func parseLine(line string) (name string, params map[string]string, value string, err error) {
+	var (
+		quoted bool
+		colon  = -1
+	)
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", fmt.Errorf("malformed line %q", line)
+	}
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	name = strings.ToUpper(parts[0])
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return name, params, value, nil
+}
+
+// parseTime parses DATE and DATE-TIME values, honoring a TZID parameter.
+func parseTime(value string, params map[string]string, loc *time.Location) (t time.Time, date bool, err error) {
+	if tzid := params["TZID"]; tzid != "" {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return t, false, fmt.Errorf("unknown timezone %q", tzid)
+		}
+		loc = l
+	}
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err = time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return t, true, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse(utcLayout, value)
+	} else {
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date-time %q", value)
+	}
+	return t, false, nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseDuration parses an iCalendar duration like "PT1H30M" or "P1D".
+func ParseDuration(v string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(v)
+	if m == nil || v == "P" || strings.HasSuffix(v, "T") {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// firstValue returns the first of the comma separated values of a text list
+// property, still escaped.
+func firstValue(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// UnescapeText reverses EscapeText.
+func UnescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			sb.WriteByte('\n')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
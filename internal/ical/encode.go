@@ -0,0 +1,189 @@
+// Package ical reads and writes iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// maxLineOctets is the maximum length of a content line, excluding the line
+// break, before it must be folded.
+const maxLineOctets = 75
+
+// utcLayout formats a UTC date-time value, e.g. 20240115T090000Z.
+const utcLayout = "20060102T150405Z"
+
+// dateLayout formats a DATE value.
+const dateLayout = "20060102"
+
+// localLayout formats a date-time value qualified by a TZID parameter.
+const localLayout = "20060102T150405"
+
+// Encoder writes appointments as a VCALENDAR with one VEVENT each.
+type Encoder struct {
+	// Method is the iTIP method of the calendar, "PUBLISH" by default. It
+	// is left out if empty, as required for CalDAV calendar resources.
+	Method string
+
+	bw      *bufio.Writer
+	started bool
+}
+
+// NewEncoder returns an encoder writing to w. Close must be called to
+// terminate the calendar.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{Method: "PUBLISH", bw: bufio.NewWriter(w)}
+}
+
+func (e *Encoder) begin() {
+	if e.started {
+		return
+	}
+	e.started = true
+	e.line("BEGIN:VCALENDAR")
+	e.line("VERSION:2.0")
+	e.line("PRODID:-//miku//cali//EN")
+	e.line("CALSCALE:GREGORIAN")
+	if e.Method != "" {
+		e.line("METHOD:" + e.Method)
+	}
+}
+
+// Encode writes a single appointment as a VEVENT. Recurring appointments are
+// written once, with their RRULE.
+func (e *Encoder) Encode(a *models.Appointment) error {
+	e.begin()
+	e.line("BEGIN:VEVENT")
+	e.line("UID:" + a.ICalUID())
+	e.line("DTSTAMP:" + FormatUTC(a.UpdatedAt))
+	switch {
+	case a.AllDay:
+		// DTEND of all-day events is exclusive.
+		loc := a.Location()
+		e.line("DTSTART;VALUE=DATE:" + a.StartTime.In(loc).Format(dateLayout))
+		e.line("DTEND;VALUE=DATE:" + a.EndTime.In(loc).AddDate(0, 0, 1).Format(dateLayout))
+	case a.Timezone != "":
+		// Zoned times let clients expand recurrences in the zone
+		// rather than in UTC.
+		loc := a.Location()
+		e.line("DTSTART;TZID=" + a.Timezone + ":" + a.StartTime.In(loc).Format(localLayout))
+		e.line("DTEND;TZID=" + a.Timezone + ":" + a.EndTime.In(loc).Format(localLayout))
+	default:
+		e.line("DTSTART:" + FormatUTC(a.StartTime))
+		e.line("DTEND:" + FormatUTC(a.EndTime))
+	}
+	if a.Recurrence != "" {
+		e.line("RRULE:" + strings.TrimPrefix(a.Recurrence, "RRULE:"))
+		if len(a.ExDates) > 0 {
+			e.exDates(a)
+		}
+	}
+	e.line("SUMMARY:" + EscapeText(a.Title))
+	if a.Description != "" {
+		e.line("DESCRIPTION:" + EscapeText(a.Description))
+	}
+	if a.Place != "" {
+		e.line("LOCATION:" + EscapeText(a.Place))
+	}
+	if a.Category != "" {
+		e.line("CATEGORIES:" + EscapeText(a.Category))
+	}
+	if a.ConferenceURL != "" {
+		e.line("CONFERENCE;VALUE=URI:" + a.ConferenceURL)
+	}
+	if a.Status != "" {
+		e.line("STATUS:" + strings.ToUpper(a.Status))
+	}
+	for _, at := range a.Attendees {
+		e.line("ATTENDEE;PARTSTAT=" + strings.ToUpper(at.ResponseStatus) + ":mailto:" + at.Email)
+	}
+	e.line("END:VEVENT")
+	return e.bw.Flush()
+}
+
+// Close terminates the calendar and flushes buffered output.
+func (e *Encoder) Close() error {
+	e.begin()
+	e.line("END:VCALENDAR")
+	return e.bw.Flush()
+}
+
+// line writes a content line, folded to at most 75 octets per physical line
+// and terminated by CRLF. Write errors are reported by the next flush.
+func (e *Encoder) line(s string) {
+	e.bw.WriteString(Fold(s))
+	e.bw.WriteString("\r\n")
+}
+
+// exDates writes the deleted occurrences of a series as an EXDATE, in the
+// same form as its DTSTART.
+func (e *Encoder) exDates(a *models.Appointment) {
+	loc := a.Location()
+	values := make([]string, len(a.ExDates))
+	for i, t := range a.ExDates {
+		switch {
+		case a.AllDay:
+			values[i] = t.In(loc).Format(dateLayout)
+		case a.Timezone != "":
+			values[i] = t.In(loc).Format(localLayout)
+		default:
+			values[i] = FormatUTC(t)
+		}
+	}
+	switch {
+	case a.AllDay:
+		e.line("EXDATE;VALUE=DATE:" + strings.Join(values, ","))
+	case a.Timezone != "":
+		e.line("EXDATE;TZID=" + a.Timezone + ":" + strings.Join(values, ","))
+	default:
+		e.line("EXDATE:" + strings.Join(values, ","))
+	}
+}
+
+// FormatUTC formats t as an iCalendar UTC date-time.
+func FormatUTC(t time.Time) string {
+	return t.UTC().Format(utcLayout)
+}
+
+// EscapeText escapes a TEXT property value.
+func EscapeText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	).Replace(s)
+}
+
+// Fold splits a content line into physical lines of at most 75 octets,
+// joined by CRLF followed by a space. Multi-octet UTF-8 sequences are never
+// split.
+func Fold(s string) string {
+	if len(s) <= maxLineOctets {
+		return s
+	}
+	var (
+		sb    strings.Builder
+		limit = maxLineOctets
+		n     int
+	)
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if n+size > limit {
+			sb.WriteString("\r\n ")
+			// Continuation lines start with a space, which counts
+			// towards the limit.
+			limit, n = maxLineOctets-1, 0
+		}
+		sb.WriteRune(r)
+		n += size
+	}
+	return sb.String()
+}
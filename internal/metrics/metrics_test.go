@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output returns the metrics of r in the text format.
+func output(r *Registry) string {
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("http_requests_total", "Requests served.", "method", "status")
+	c.Inc("POST", "201")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	r.NewCounterVec("idle_total", "Never incremented.")
+
+	want := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",status="200"} 2
+http_requests_total{method="POST",status="201"} 1
+# HELP idle_total Never incremented.
+# TYPE idle_total counter
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterVecWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("reminders_sent_total", "Reminders sent.")
+	c.Inc()
+	want := `# HELP reminders_sent_total Reminders sent.
+# TYPE reminders_sent_total counter
+reminders_sent_total 1
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("request_duration_seconds", "Request latencies.", []float64{0.1, 0.5, 1}, "route")
+	// Bounds are inclusive, and values above the last bound only count
+	// towards +Inf.
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(v, "/api/appointments")
+	}
+	h.Observe(0.75, "/health")
+
+	want := `# HELP request_duration_seconds Request latencies.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/api/appointments",le="0.1"} 2
+request_duration_seconds_bucket{route="/api/appointments",le="0.5"} 3
+request_duration_seconds_bucket{route="/api/appointments",le="1"} 3
+request_duration_seconds_bucket{route="/api/appointments",le="+Inf"} 4
+request_duration_seconds_sum{route="/api/appointments"} 2.45
+request_duration_seconds_count{route="/api/appointments"} 4
+request_duration_seconds_bucket{route="/health",le="0.1"} 0
+request_duration_seconds_bucket{route="/health",le="0.5"} 0
+request_duration_seconds_bucket{route="/health",le="1"} 1
+request_duration_seconds_bucket{route="/health",le="+Inf"} 1
+request_duration_seconds_sum{route="/health"} 0.75
+request_duration_seconds_count{route="/health"} 1
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	value := 0.25
+	r.NewGaugeFunc("cache_hit_ratio", "Share of cache hits.", func() float64 { return value })
+	value = 0.5
+	want := `# HELP cache_hit_ratio Share of cache hits.
+# TYPE cache_hit_ratio gauge
+cache_hit_ratio 0.5
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	var cases = []struct {
+		value, want string
+	}{
+		{"plain", `"plain"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\tmp`, `"C:\\tmp"`},
+		{"two\nlines", `"two\nlines"`},
+		// Everything else is taken literally.
+		{"tab\there", "\"tab\there\""},
+		{"Grüße", `"Grüße"`},
+	}
+	for _, c := range cases {
+		r := NewRegistry()
+		r.NewCounterVec("errors_total", "Errors by message.", "message").Inc(c.value)
+		want := "errors_total{message=" + c.want + "} 1\n"
+		if got := output(r); !strings.HasSuffix(got, want) {
+			t.Errorf("%q: got\n%s\nwant a line\n%s", c.value, got, want)
+		}
+	}
+
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "Whether the server\nis up, see C:\\docs.", func() float64 { return 1 })
+	want := `# HELP up Whether the server\nis up, see C:\\docs.` + "\n"
+	if got := output(r); !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%s\nwant a first line\n%s", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("hits_total", "Hits.").Inc()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("got content type %q, want %q", got, ContentType)
+	}
+	if got, want := rec.Body.String(), output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
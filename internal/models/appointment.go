@@ -2,31 +2,158 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/miku/cali/internal/recurrence"
 )
 
 // Custom errors for appointment validation
 var (
 	ErrEmptyTitle         = errors.New("title cannot be empty")
 	ErrInvalidTime        = errors.New("invalid time")
-	ErrEndTimeBeforeStart = errors.New("end time cannot be before start time")
+	ErrEndTimeBeforeStart = errors.New("end time must be after start time")
+	ErrEndDateBeforeStart = errors.New("end date must not be before start date")
+	ErrActualEndNotAfter  = errors.New("actual end time must be after actual start time")
+	ErrInvalidRecurrence  = errors.New("invalid recurrence rule")
+	ErrInvalidSlug        = errors.New("slug must be lowercase letters and digits separated by single hyphens")
+	ErrInvalidTimezone    = errors.New("unknown timezone")
+	ErrInvalidStatus      = errors.New("status must be confirmed, tentative or cancelled")
+	ErrInvalidAttendee    = errors.New("invalid attendee")
+	ErrInvalidConference  = errors.New("conference URL must be an absolute http or https URL")
+	ErrInvalidCategory    = fmt.Errorf("category must be at most %d characters", maxCategoryLength)
+	ErrInvalidColor       = errors.New("color must be a hex color like #3366cc")
 )
 
-type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	CreatedAt time.Time `json:"created_at"`
+// Appointment statuses. Cancelled appointments are kept for the record but
+// do not block time.
+const (
+	StatusConfirmed = "confirmed"
+	StatusTentative = "tentative"
+	StatusCancelled = "cancelled"
+)
+
+// ValidStatus reports whether s is a known appointment status.
+func ValidStatus(s string) bool {
+	return s == StatusConfirmed || s == StatusTentative || s == StatusCancelled
+}
+
+// Attendee response statuses, named after the iCalendar PARTSTAT values.
+const (
+	ResponseNeedsAction = "needs-action"
+	ResponseAccepted    = "accepted"
+	ResponseDeclined    = "declined"
+	ResponseTentative   = "tentative"
+)
+
+// ValidResponseStatus reports whether s is a known attendee response status.
+func ValidResponseStatus(s string) bool {
+	switch s {
+	case ResponseNeedsAction, ResponseAccepted, ResponseDeclined, ResponseTentative:
+		return true
+	}
+	return false
+}
+
+// Attendee is a participant of an appointment other than its owner.
+// Attendees invited as users of this server have a UserID and respond to
+// the invitation themselves; others are only known by email.
+type Attendee struct {
+	Email          string `json:"email"`
+	UserID         int64  `json:"user_id,omitempty"`
+	ResponseStatus string `json:"response_status"`
 }
 
 type Appointment struct {
-	ID          int64     `json:"id"`
-	UserID      int64     `json:"user_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description,omitempty"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Title  string `json:"title"`
+	// OriginalTitle keeps the title as submitted, if it was changed by
+	// normalization and keeping the original is configured.
+	OriginalTitle string `json:"original_title,omitempty"`
+	Slug          string `json:"slug,omitempty"`
+	// UID is the iCalendar UID given by a calendar client that created
+	// the appointment, see ICalUID.
+	UID         string `json:"uid,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Place is where the appointment happens, e.g. a room or an address.
+	// It is called location outside of Go, where Location is the
+	// timezone.
+	Place string `json:"location,omitempty"`
+	// ConferenceURL links to a video call, if the appointment has one.
+	ConferenceURL string `json:"conference_url,omitempty"`
+	// Category groups appointments, e.g. "work" or "travel", and Color
+	// is a hex color like "#3366cc" to show them in. Both are free-form.
+	Category  string    `json:"category,omitempty"`
+	Color     string    `json:"color,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// AllDay marks appointments without a clock time, like birthdays.
+	// StartTime and EndTime are then midnight of the first and last day,
+	// so a single-day appointment has equal start and end times.
+	AllDay bool `json:"all_day,omitempty"`
+	// Timezone is the IANA name of the zone the appointment was scheduled
+	// in, e.g. "Europe/Berlin". Times are rendered in it, and recurrences
+	// keep their wall clock time in it across DST changes.
+	Timezone string `json:"timezone,omitempty"`
+	// Recurrence is an iCalendar RRULE, e.g. "FREQ=WEEKLY;BYDAY=MO;COUNT=10".
+	// Occurrences expanded from it carry the ID of the stored appointment.
+	Recurrence string `json:"recurrence,omitempty"`
+	// ExDates are the starts of occurrences deleted from the series, like
+	// EXDATE in iCalendar. They are left out when the series is expanded.
+	ExDates []time.Time `json:"exdates,omitempty"`
+	// PrevOccurrence and NextOccurrence are the starts of the occurrences
+	// before and after an occurrence expanded from a recurring
+	// appointment. They are derived during expansion, not stored, and
+	// unset for the first and the last occurrence of a series.
+	PrevOccurrence *time.Time `json:"prev_occurrence,omitempty"`
+	NextOccurrence *time.Time `json:"next_occurrence,omitempty"`
+	// Status is one of StatusConfirmed, StatusTentative or
+	// StatusCancelled.
+	Status string `json:"status"`
+	// Attendees are the participants invited to the appointment.
+	Attendees []Attendee `json:"attendees,omitempty"`
+	// ActualStart and ActualEnd record when the appointment really took
+	// place, as opposed to when it was scheduled.
+	ActualStart *time.Time `json:"actual_start,omitempty"`
+	ActualEnd   *time.Time `json:"actual_end,omitempty"`
+	// SortOrder orders appointments with the same start time.
+	SortOrder int       `json:"sort_order"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Version is increased by every change of the appointment. It is sent
+	// as the entity tag, so clients can make changes conditional on it.
+	Version int64 `json:"version"`
+	// DeletedAt is set when the appointment was deleted. Deleted
+	// appointments are kept, so they can be restored, but are left out
+	// everywhere unless asked for explicitly.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// MergedInto is the ID of the appointment this one was merged into,
+	// which deleted it.
+	MergedInto int64 `json:"merged_into,omitempty"`
+	// MergedFrom lists the IDs of the appointments merged into this one.
+	MergedFrom []int64 `json:"merged_from,omitempty"`
+	// ReminderChannels, if set, are the channels the reminders of the
+	// appointment are sent by, e.g. ReminderEmail and ReminderDesktop,
+	// instead of each reminder's method and the user's email settings.
+	ReminderChannels []string `json:"reminder_channels,omitempty"`
+}
+
+// ICalUID returns the UID identifying the appointment in iCalendar data:
+// the one given by the client that created it, or one derived from the ID.
+func (a *Appointment) ICalUID() string {
+	if a.UID != "" {
+		return a.UID
+	}
+	return fmt.Sprintf("%d@cali", a.ID)
 }
 
 // Validate checks if the appointment data is valid
@@ -37,8 +164,302 @@ func (a *Appointment) Validate() error {
 	if a.StartTime.IsZero() || a.EndTime.IsZero() {
 		return ErrInvalidTime
 	}
-	if a.EndTime.Before(a.StartTime) {
+	if a.AllDay {
+		if a.EndTime.Before(a.StartTime) {
+			return ErrEndDateBeforeStart
+		}
+	} else if !a.EndTime.After(a.StartTime) {
 		return ErrEndTimeBeforeStart
 	}
+	if a.ActualStart != nil && a.ActualEnd != nil && !a.ActualEnd.After(*a.ActualStart) {
+		return ErrActualEndNotAfter
+	}
+	if !ValidStatus(a.Status) {
+		return ErrInvalidStatus
+	}
+	if err := validateAttendees(a.Attendees); err != nil {
+		return err
+	}
+	if a.Slug != "" && !ValidSlug(a.Slug) {
+		return ErrInvalidSlug
+	}
+	if utf8.RuneCountInString(a.Category) > maxCategoryLength {
+		return ErrInvalidCategory
+	}
+	if a.Color != "" && !colorPattern.MatchString(a.Color) {
+		return ErrInvalidColor
+	}
+	if a.ConferenceURL != "" {
+		u, err := url.Parse(a.ConferenceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidConference
+		}
+	}
+	if a.Timezone != "" {
+		if _, err := time.LoadLocation(a.Timezone); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidTimezone, a.Timezone)
+		}
+	}
+	if a.Recurrence != "" {
+		if _, err := recurrence.Parse(a.Recurrence); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+		}
+	}
+	for i, c := range a.ReminderChannels {
+		if !ValidReminderChannel(c) || slices.Contains(a.ReminderChannels[:i], c) {
+			return fmt.Errorf("%w: %q", ErrInvalidReminderChannel, c)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the attendee has a plain email address and a known
+// response status.
+func (at *Attendee) Validate() error {
+	addr, err := mail.ParseAddress(at.Email)
+	if err != nil || addr.Name != "" || addr.Address != at.Email {
+		return fmt.Errorf("%w: bad email address %q", ErrInvalidAttendee, at.Email)
+	}
+	if !ValidResponseStatus(at.ResponseStatus) {
+		return fmt.Errorf("%w: unknown response status %q for %s", ErrInvalidAttendee, at.ResponseStatus, at.Email)
+	}
 	return nil
 }
+
+// validateAttendees checks that attendees are valid and have distinct
+// email addresses, compared case-insensitively.
+func validateAttendees(attendees []Attendee) error {
+	seen := make(map[string]bool, len(attendees))
+	for _, at := range attendees {
+		if err := at.Validate(); err != nil {
+			return err
+		}
+		key := strings.ToLower(at.Email)
+		if seen[key] {
+			return fmt.Errorf("%w: %s is listed twice", ErrInvalidAttendee, at.Email)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// ConflictRange returns the range another appointment must overlap to
+// conflict with a. It is narrowed by tolerance at both ends, so
+// appointments may overlap at their edges. The tolerance is capped at half
+// the duration of a: the range of a short appointment shrinks to its
+// middle, which still conflicts with appointments covering it.
+func (a *Appointment) ConflictRange(tolerance time.Duration) (start, end time.Time) {
+	tolerance = min(tolerance, a.EndTime.Sub(a.StartTime)/2)
+	return a.StartTime.Add(tolerance), a.EndTime.Add(-tolerance)
+}
+
+// Location returns the timezone of the appointment, or UTC if it has none
+// or it is unknown.
+func (a *Appointment) Location() *time.Location {
+	if a.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(a.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// In returns a copy of the appointment with its scheduled and actual times
+// in loc. All-day appointments keep their own timezone, in which their
+// times are midnight.
+func (a *Appointment) In(loc *time.Location) *Appointment {
+	c := *a
+	if a.AllDay {
+		return &c
+	}
+	c.StartTime, c.EndTime = a.StartTime.In(loc), a.EndTime.In(loc)
+	if a.ActualStart != nil {
+		t := a.ActualStart.In(loc)
+		c.ActualStart = &t
+	}
+	if a.ActualEnd != nil {
+		t := a.ActualEnd.In(loc)
+		c.ActualEnd = &t
+	}
+	if a.PrevOccurrence != nil {
+		t := a.PrevOccurrence.In(loc)
+		c.PrevOccurrence = &t
+	}
+	if a.NextOccurrence != nil {
+		t := a.NextOccurrence.In(loc)
+		c.NextOccurrence = &t
+	}
+	if a.ExDates != nil {
+		c.ExDates = make([]time.Time, len(a.ExDates))
+		for i, t := range a.ExDates {
+			c.ExDates[i] = t.In(loc)
+		}
+	}
+	return &c
+}
+
+// LocalizeTimes converts the scheduled and actual times of the appointment
+// to its timezone. The instants in time are unchanged.
+func (a *Appointment) LocalizeTimes() {
+	loc := a.Location()
+	a.StartTime, a.EndTime = a.StartTime.In(loc), a.EndTime.In(loc)
+	if a.ActualStart != nil {
+		t := a.ActualStart.In(loc)
+		a.ActualStart = &t
+	}
+	if a.ActualEnd != nil {
+		t := a.ActualEnd.In(loc)
+		a.ActualEnd = &t
+	}
+}
+
+// ExpandRecurrences returns the occurrences of a that lie entirely within
+// [rangeStart, rangeEnd]. Each occurrence is a copy of a with shifted start
+// and end times and keeps the ID of a. An appointment without recurrence
+// yields itself, if it lies within the range.
+func ExpandRecurrences(a *Appointment, rangeStart, rangeEnd time.Time) ([]*Appointment, error) {
+	if a.Recurrence == "" {
+		if a.StartTime.Before(rangeStart) || a.EndTime.After(rangeEnd) {
+			return nil, nil
+		}
+		return []*Appointment{a}, nil
+	}
+	rule, err := recurrence.Parse(a.Recurrence)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	var occurrences []*Appointment
+	a.iterateOccurrences(rule, func(o *Appointment) bool {
+		if o.EndTime.After(rangeEnd) || len(occurrences) == recurrence.MaxOccurrences {
+			return false
+		}
+		if !o.StartTime.Before(rangeStart) {
+			occurrences = append(occurrences, o)
+		}
+		return true
+	})
+	return occurrences, nil
+}
+
+// iterateOccurrences calls fn with each occurrence of the recurring
+// appointment a, leaving out excluded ones, in order until fn returns false
+// or the series ends. Occurrences are passed with their PrevOccurrence set;
+// their NextOccurrence is set before fn is called with the next one.
+func (a *Appointment) iterateOccurrences(rule *recurrence.Rule, fn func(*Appointment) bool) {
+	duration := a.EndTime.Sub(a.StartTime)
+	var prev *Appointment
+	// Expand in the timezone of the appointment, so occurrences keep their
+	// wall clock time across DST changes.
+	rule.Iterate(a.StartTime.In(a.Location()), func(t time.Time) bool {
+		if a.excluded(t) {
+			return true
+		}
+		o := *a
+		o.StartTime, o.EndTime = t, t.Add(duration)
+		if prev != nil {
+			prevStart, next := prev.StartTime, t
+			o.PrevOccurrence, prev.NextOccurrence = &prevStart, &next
+		}
+		prev = &o
+		return fn(&o)
+	})
+}
+
+// NextOccurrences returns the first n occurrences of a recurring
+// appointment starting at or after from. A non-recurring appointment is
+// returned as is if it starts at or after from.
+func NextOccurrences(a *Appointment, from time.Time, n int) ([]*Appointment, error) {
+	if a.Recurrence == "" {
+		if a.StartTime.Before(from) || n < 1 {
+			return nil, nil
+		}
+		return []*Appointment{a}, nil
+	}
+	rule, err := recurrence.Parse(a.Recurrence)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	var occurrences []*Appointment
+	a.iterateOccurrences(rule, func(o *Appointment) bool {
+		if len(occurrences) >= n {
+			return false
+		}
+		if !o.StartTime.Before(from) {
+			occurrences = append(occurrences, o)
+		}
+		return true
+	})
+	return occurrences, nil
+}
+
+// NormalizeTitle trims surrounding whitespace and collapses internal runs of
+// whitespace to a single space. With titleCase, the first letter of each
+// word is upper-cased as well; other letters are left alone to preserve
+// acronyms like "OKR".
+func NormalizeTitle(title string, titleCase bool) string {
+	words := strings.Fields(title)
+	if titleCase {
+		for i, w := range words {
+			r, size := utf8.DecodeRuneInString(w)
+			words[i] = string(unicode.ToUpper(r)) + w[size:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// maxCategoryLength caps categories, which are meant as short labels.
+const maxCategoryLength = 64
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// MaxSlugLength caps slugs, so long titles still yield usable URLs.
+const MaxSlugLength = 64
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidSlug reports whether s is a lowercase, hyphenated slug.
+func ValidSlug(s string) bool {
+	return len(s) <= MaxSlugLength && slugPattern.MatchString(s)
+}
+
+// Slugify derives a slug from a title, e.g. "Q3 Budget Review!" becomes
+// "q3-budget-review". Titles without any usable characters yield
+// "appointment".
+func Slugify(title string) string {
+	var (
+		sb     strings.Builder
+		hyphen bool
+	)
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			hyphen = false
+			sb.WriteRune(r)
+		default:
+			hyphen = true
+		}
+	}
+	slug := sb.String()
+	if len(slug) > MaxSlugLength {
+		slug = strings.TrimRight(slug[:MaxSlugLength], "-")
+	}
+	if slug == "" {
+		return "appointment"
+	}
+	return slug
+}
+
+// SuffixSlug returns slug with the numeric suffix n, e.g. "standup-2",
+// shortening slug as needed for the result to fit MaxSlugLength.
+func SuffixSlug(slug string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	if len(slug)+len(suffix) > MaxSlugLength {
+		slug = strings.TrimRight(slug[:MaxSlugLength-len(suffix)], "-")
+	}
+	return slug + suffix
+}
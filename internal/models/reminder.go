@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reminder delivery methods.
+const (
+	// ReminderLog writes the reminder to the server log.
+	ReminderLog = "log"
+	// ReminderWebhook posts an "appointment.reminder" event to the
+	// configured webhook URLs.
+	ReminderWebhook = "webhook"
+	// ReminderDesktop pops up a notification on the server's desktop,
+	// which is useful when running the server locally.
+	ReminderDesktop = "desktop"
+	// ReminderEmail emails the reminder to the user. It is not a method
+	// of its own, but a channel appointments may choose in
+	// ReminderChannels.
+	ReminderEmail = "email"
+)
+
+// MaxReminderMinutes is the furthest ahead of an appointment a reminder may
+// be, one week.
+const MaxReminderMinutes = 7 * 24 * 60
+
+var (
+	ErrInvalidReminderOffset  = fmt.Errorf("minutes_before must be between 0 and %d", MaxReminderMinutes)
+	ErrInvalidReminderMethod  = errors.New("method must be log, webhook or desktop")
+	ErrInvalidReminderChannel = errors.New("reminder channels must be distinct and one of log, webhook, desktop or email")
+)
+
+// Reminder asks for a notification some minutes before an appointment, or
+// each occurrence of a recurring appointment, starts.
+type Reminder struct {
+	ID            int64  `json:"id"`
+	AppointmentID int64  `json:"appointment_id"`
+	MinutesBefore int    `json:"minutes_before"`
+	Method        string `json:"method"`
+	// SentAt is when the reminder was last sent, for a recurring
+	// appointment the reminder of its latest occurrence so far.
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// ValidReminderMethod reports whether s is a known reminder method.
+func ValidReminderMethod(s string) bool {
+	return s == ReminderLog || s == ReminderWebhook || s == ReminderDesktop
+}
+
+// ValidReminderChannel reports whether s is a reminder method or
+// ReminderEmail.
+func ValidReminderChannel(s string) bool {
+	return ValidReminderMethod(s) || s == ReminderEmail
+}
+
+// Validate checks the offset and method of the reminder.
+func (r *Reminder) Validate() error {
+	if r.MinutesBefore < 0 || r.MinutesBefore > MaxReminderMinutes {
+		return ErrInvalidReminderOffset
+	}
+	if !ValidReminderMethod(r.Method) {
+		return ErrInvalidReminderMethod
+	}
+	return nil
+}
+
+// Snooze is a window during which the reminders of a user are not sent.
+type Snooze struct {
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+// Covers reports whether a reminder due at t falls within (From, Until].
+func (s Snooze) Covers(t time.Time) bool {
+	return t.After(s.From) && !t.After(s.Until)
+}
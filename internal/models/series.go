@@ -0,0 +1,189 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/miku/cali/internal/recurrence"
+)
+
+var (
+	ErrNotRecurring = errors.New("appointment does not recur")
+	ErrNoOccurrence = errors.New("no occurrence of the series starts at this time")
+)
+
+// excluded reports whether the occurrence starting at t was deleted from
+// the series. ExDates are stored to the second, so fractions are ignored.
+func (a *Appointment) excluded(t time.Time) bool {
+	for _, x := range a.ExDates {
+		if sameSecond(x, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSecond(a, b time.Time) bool {
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
+
+// occurrence locates the occurrence of the series starting at t. It returns
+// whether t is the first remaining occurrence and, if so, the start of the
+// following one and the number of rule instances before that, including
+// deleted ones. It returns ErrNoOccurrence if no remaining occurrence
+// starts at t.
+func (a *Appointment) occurrence(rule *recurrence.Rule, t time.Time) (first bool, next time.Time, skipped int, err error) {
+	var (
+		found   bool
+		visible int
+	)
+	rule.Iterate(a.StartTime.In(a.Location()), func(o time.Time) bool {
+		if found {
+			if a.excluded(o) {
+				skipped++
+				return true
+			}
+			next = o
+			return false
+		}
+		if o.After(t) && !sameSecond(o, t) {
+			return false
+		}
+		skipped++
+		if a.excluded(o) {
+			return true
+		}
+		if sameSecond(o, t) {
+			found, first = true, visible == 0
+			// Only the removal of the first occurrence needs to know
+			// what follows.
+			return first
+		}
+		visible++
+		return true
+	})
+	if !found {
+		return false, time.Time{}, 0, ErrNoOccurrence
+	}
+	return first, next, skipped, nil
+}
+
+// rule parses the recurrence rule of a, which must be a series.
+func (a *Appointment) rule() (*recurrence.Rule, error) {
+	if a.Recurrence == "" {
+		return nil, ErrNotRecurring
+	}
+	rule, err := recurrence.Parse(a.Recurrence)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	return rule, nil
+}
+
+// RemoveOccurrence deletes the occurrence starting at t from the series a.
+// Later occurrences are added to ExDates. The first occurrence instead
+// moves the start of the series to the next one, counting down COUNT, so
+// no exception is left before the start. RemoveOccurrence returns false if
+// no occurrence would be left; the series should then be deleted as a
+// whole and a is unchanged.
+func (a *Appointment) RemoveOccurrence(t time.Time) (bool, error) {
+	rule, err := a.rule()
+	if err != nil {
+		return false, err
+	}
+	first, next, skipped, err := a.occurrence(rule, t)
+	if err != nil {
+		return false, err
+	}
+	if !first {
+		a.ExDates = append(a.ExDates, t.UTC().Truncate(time.Second))
+		sort.Slice(a.ExDates, func(i, j int) bool { return a.ExDates[i].Before(a.ExDates[j]) })
+		return true, nil
+	}
+	if next.IsZero() {
+		return false, nil
+	}
+	if rule.Count > 0 {
+		rule.Count -= skipped
+		a.Recurrence = rule.String()
+	}
+	duration := a.EndTime.Sub(a.StartTime)
+	a.StartTime, a.EndTime = next, next.Add(duration)
+	a.ExDates = exDatesFrom(a.ExDates, next)
+	return true, nil
+}
+
+// EndSeriesBefore deletes the occurrence starting at t and all following
+// ones from the series a, by ending its rule with an UNTIL just before t.
+// It returns false if t is the first occurrence, so no occurrence would be
+// left; the series should then be deleted as a whole and a is unchanged.
+func (a *Appointment) EndSeriesBefore(t time.Time) (bool, error) {
+	rule, err := a.rule()
+	if err != nil {
+		return false, err
+	}
+	first, _, _, err := a.occurrence(rule, t)
+	if err != nil {
+		return false, err
+	}
+	if first {
+		return false, nil
+	}
+	rule.Count = 0
+	rule.Until = t.Add(-time.Second)
+	a.Recurrence = rule.String()
+	a.ExDates = exDatesBefore(a.ExDates, t)
+	return true, nil
+}
+
+// exDatesFrom returns the dates at or after start.
+func exDatesFrom(dates []time.Time, start time.Time) []time.Time {
+	var kept []time.Time
+	for _, x := range dates {
+		if !x.Before(start.Truncate(time.Second)) {
+			kept = append(kept, x)
+		}
+	}
+	return kept
+}
+
+// exDatesBefore returns the dates before end.
+func exDatesBefore(dates []time.Time, end time.Time) []time.Time {
+	var kept []time.Time
+	for _, x := range dates {
+		if x.Before(end) {
+			kept = append(kept, x)
+		}
+	}
+	return kept
+}
+
+// Move shifts a to begin at start, keeping its duration. All-day
+// appointments move by whole days, to the date of start in their timezone.
+// Deleted occurrences of a series move along with it.
+func (a *Appointment) Move(start time.Time) {
+	if a.AllDay {
+		loc := a.Location()
+		from, to := a.StartTime.In(loc), start.In(loc)
+		days := int(date(to).Sub(date(from)).Hours() / 24)
+		a.StartTime = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, loc)
+		a.EndTime = a.EndTime.In(loc).AddDate(0, 0, days)
+		for i, x := range a.ExDates {
+			a.ExDates[i] = x.In(loc).AddDate(0, 0, days)
+		}
+		return
+	}
+	delta := start.Sub(a.StartTime)
+	a.StartTime, a.EndTime = start, a.EndTime.Add(delta)
+	for i, x := range a.ExDates {
+		a.ExDates[i] = x.Add(delta)
+	}
+}
+
+// date returns the date of t as midnight UTC, so dates can be subtracted
+// regardless of DST changes.
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
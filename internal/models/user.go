@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Kinds of email notifications users can opt in to.
+const (
+	EmailCreated   = "created"
+	EmailUpdated   = "updated"
+	EmailCancelled = "cancelled"
+	EmailReminder  = "reminder"
+)
+
+var (
+	ErrInvalidUsername          = errors.New("username must be 1 to 64 letters, digits, dots, hyphens or underscores")
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrInvalidEmailNotification = errors.New("email notifications must be created, updated, cancelled or reminder")
+	ErrEmailRequired            = errors.New("email notifications require an email address")
+)
+
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	// PasswordHash is the hash of the user's password, see
+	// auth.HashPassword. Users without one cannot log in.
+	PasswordHash string `json:"-"`
+	// Timezone is the IANA name of the user's default zone, used for new
+	// appointments without one and for requests without a tz parameter.
+	Timezone string `json:"timezone,omitempty"`
+	// Email is the address notifications are sent to, once it is
+	// verified.
+	Email string `json:"email,omitempty"`
+	// EmailVerified is set when the user proved to receive mail at
+	// Email, and cleared when Email changes.
+	EmailVerified bool `json:"email_verified"`
+	// EmailNotifications lists the kinds of notifications the user opted
+	// in to, e.g. EmailReminder. None are sent by default.
+	EmailNotifications []string  `json:"email_notifications,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Validate checks that the username is usable in URLs and logs as is, that
+// the timezone, if any, is known and that the email settings are usable.
+func (u *User) Validate() error {
+	if !usernamePattern.MatchString(u.Username) {
+		return ErrInvalidUsername
+	}
+	if u.Timezone != "" {
+		if _, err := time.LoadLocation(u.Timezone); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidTimezone, u.Timezone)
+		}
+	}
+	if u.Email != "" {
+		// Only bare addresses, as the address is used as the recipient
+		// as is.
+		addr, err := mail.ParseAddress(u.Email)
+		if err != nil || addr.Address != u.Email {
+			return fmt.Errorf("%w: %q", ErrInvalidEmail, u.Email)
+		}
+	}
+	for _, kind := range u.EmailNotifications {
+		if !ValidEmailNotification(kind) {
+			return fmt.Errorf("%w, got %q", ErrInvalidEmailNotification, kind)
+		}
+	}
+	if len(u.EmailNotifications) > 0 && u.Email == "" {
+		return ErrEmailRequired
+	}
+	return nil
+}
+
+// ValidEmailNotification reports whether s is a known kind of email
+// notification.
+func ValidEmailNotification(s string) bool {
+	return s == EmailCreated || s == EmailUpdated || s == EmailCancelled || s == EmailReminder
+}
+
+// WantsEmail reports whether the user opted in to email notifications of
+// the given kind.
+func (u *User) WantsEmail(kind string) bool {
+	return u.CanEmail() && slices.Contains(u.EmailNotifications, kind)
+}
+
+// CanEmail reports whether email notifications can be sent to the user.
+// Unverified addresses get none, so the server cannot be made to mail
+// anyone.
+func (u *User) CanEmail() bool {
+	return u.Email != "" && u.EmailVerified
+}
+
+// Location returns the user's default timezone, or nil if none is set.
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Webhook events, sent as the event of the payload.
+const (
+	EventCreated  = "appointment.created"
+	EventUpdated  = "appointment.updated"
+	EventDeleted  = "appointment.deleted"
+	EventReminder = "appointment.reminder"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to.
+var WebhookEvents = []string{EventCreated, EventUpdated, EventDeleted, EventReminder}
+
+var (
+	ErrInvalidWebhookURL   = errors.New("url must be an http or https URL")
+	ErrPrivateWebhookURL   = errors.New("url must not point to a loopback, private or link-local address")
+	ErrInvalidWebhookEvent = fmt.Errorf("events must be of %v", WebhookEvents)
+)
+
+// Webhook subscribes a URL to events of the appointments of a user.
+type Webhook struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	URL    string `json:"url"`
+	// Events lists the subscribed events, e.g. EventCreated.
+	Events []string `json:"events"`
+	// Secret signs the payloads, like the configured webhook secret. It
+	// is generated when the webhook is created and only shown then.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the URL and events of the webhook. URLs naming hosts
+// that are not public, by address or as localhost, are rejected; names
+// resolving to such addresses are rejected when connecting.
+func (h *Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateWebhookURL
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !PublicAddr(addr) {
+		return ErrPrivateWebhookURL
+	}
+	if len(h.Events) == 0 {
+		return ErrInvalidWebhookEvent
+	}
+	for _, e := range h.Events {
+		if !slices.Contains(WebhookEvents, e) {
+			return fmt.Errorf("%w, got %q", ErrInvalidWebhookEvent, e)
+		}
+	}
+	return nil
+}
+
+// PublicAddr reports whether addr is a public unicast address, one
+// webhooks of users may be posted to. Loopback, private, link-local,
+// unspecified, multicast and broadcast addresses are not, nor are those of
+// 0.0.0.0/8, which reach the local host on some systems.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !(addr.Is4() && addr.As4()[0] == 0)
+}
+
+// Subscribes reports whether the webhook subscribes to event.
+func (h *Webhook) Subscribes(event string) bool {
+	return slices.Contains(h.Events, event)
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	var cases = []struct {
+		url string
+		err error
+	}{
+		{"https://hooks.example.com/cali", nil},
+		{"http://93.184.216.34:8080/hook", nil},
+		{"ftp://example.com/hook", ErrInvalidWebhookURL},
+		{"https:///hook", ErrInvalidWebhookURL},
+		{"http://127.0.0.1:8080/hook", ErrPrivateWebhookURL},
+		{"http://localhost/hook", ErrPrivateWebhookURL},
+		{"http://api.localhost./hook", ErrPrivateWebhookURL},
+		{"http://169.254.169.254/latest/meta-data", ErrPrivateWebhookURL},
+		{"http://10.1.2.3/hook", ErrPrivateWebhookURL},
+		{"http://192.168.0.1/hook", ErrPrivateWebhookURL},
+		{"http://0.0.0.0:8080/hook", ErrPrivateWebhookURL},
+		{"http://[::1]/hook", ErrPrivateWebhookURL},
+		{"http://[fe80::1]/hook", ErrPrivateWebhookURL},
+		{"http://[fd00::1]/hook", ErrPrivateWebhookURL},
+		{"http://[::ffff:127.0.0.1]/hook", ErrPrivateWebhookURL},
+	}
+	for _, c := range cases {
+		h := &Webhook{URL: c.url, Events: []string{EventCreated}}
+		if err := h.Validate(); !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.url, err, c.err)
+		}
+	}
+}
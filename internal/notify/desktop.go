@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Desktop pops up notifications on the local desktop by shelling out to the
+// platform's notification tool: notify-send on Linux and the BSDs, osascript
+// on macOS and PowerShell toasts on Windows. On other platforms, or when the
+// tool is missing, notifications are dropped.
+type Desktop struct {
+	command func(ctx context.Context, title, message string) *exec.Cmd
+}
+
+// toastScript shows a Windows toast. Title and message are read from the
+// environment, see toastEnv, and added as text nodes, so they never become
+// part of the command text and need no escaping.
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:CALI_TOAST_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:CALI_TOAST_MESSAGE)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('Cali').Show($toast)`
+
+// toastEnv returns the environment of the toast script showing title and
+// message.
+func toastEnv(title, message string) []string {
+	return append(os.Environ(), "CALI_TOAST_TITLE="+title, "CALI_TOAST_MESSAGE="+message)
+}
+
+// NewDesktop detects the notification tool of the current platform. If none
+// is available, a warning is logged and the returned notifier is a no-op.
+func NewDesktop() *Desktop {
+	d := &Desktop{}
+	tool, command := desktopCommand(runtime.GOOS)
+	if command == nil {
+		log.Printf("desktop notifications are not supported on %s, disabling", runtime.GOOS)
+		return d
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		log.Printf("desktop notifications disabled: %s not found", tool)
+		return d
+	}
+	d.command = command
+	return d
+}
+
+// desktopCommand returns the notification tool of the platform goos and a
+// function making the command that shows a notification with it, or nil if
+// the platform has none. Texts are never interpreted as options or code.
+func desktopCommand(goos string) (string, func(ctx context.Context, title, message string) *exec.Cmd) {
+	switch goos {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return "notify-send", func(ctx context.Context, title, message string) *exec.Cmd {
+			return exec.CommandContext(ctx, "notify-send", "--app-name=Cali", "--", title, message)
+		}
+	case "darwin":
+		return "osascript", func(ctx context.Context, title, message string) *exec.Cmd {
+			// Pass the texts as arguments rather than interpolating them
+			// into the script, to avoid AppleScript quoting issues.
+			return exec.CommandContext(ctx, "osascript",
+				"-e", "on run argv",
+				"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+				"-e", "end run",
+				"--", title, message)
+		}
+	case "windows":
+		return "powershell", func(ctx context.Context, title, message string) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+			cmd.Env = toastEnv(title, message)
+			return cmd
+		}
+	}
+	return "", nil
+}
+
+// Enabled reports whether notifications are actually shown.
+func (d *Desktop) Enabled() bool {
+	return d.command != nil
+}
+
+// Notify shows a desktop notification. It is a no-op if desktop
+// notifications are not available.
+func (d *Desktop) Notify(ctx context.Context, title, message string) error {
+	if d.command == nil {
+		return nil
+	}
+	if out, err := d.command(ctx, title, message).CombinedOutput(); err != nil {
+		return fmt.Errorf("desktop notification failed: %w: %s", err, out)
+	}
+	return nil
+}
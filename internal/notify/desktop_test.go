@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDesktopCommand(t *testing.T) {
+	titles := []string{
+		`"; Start-Process calc; "`,
+		`$(Start-Process calc)`,
+		"--help",
+		`-u critical`,
+		`" & display dialog "hi`,
+	}
+	const message = "In 10 minutes"
+	var cases = []struct {
+		goos string
+		// before is the number of arguments before the title and message,
+		// or -1 if they must not be arguments.
+		before int
+	}{
+		{"linux", 2},
+		{"openbsd", 2},
+		{"darwin", 7},
+		{"windows", -1},
+	}
+	for _, c := range cases {
+		_, command := desktopCommand(c.goos)
+		for _, title := range titles {
+			cmd := command(context.Background(), title, message)
+			args := cmd.Args[1:]
+			if c.before < 0 {
+				for _, a := range args {
+					if strings.Contains(a, title) || strings.Contains(a, message) {
+						t.Errorf("%s, %q: texts passed in argument %q", c.goos, title, a)
+					}
+				}
+				if !slices.Contains(cmd.Env, "CALI_TOAST_TITLE="+title) || !slices.Contains(cmd.Env, "CALI_TOAST_MESSAGE="+message) {
+					t.Errorf("%s, %q: texts not in the environment", c.goos, title)
+				}
+				continue
+			}
+			if len(args) != c.before+2 || args[c.before] != title || args[c.before+1] != message {
+				t.Errorf("%s, %q: got arguments %q, want the texts after %d others", c.goos, title, args, c.before)
+				continue
+			}
+			if args[c.before-1] != "--" {
+				t.Errorf("%s, %q: got arguments %q, want the texts after --", c.goos, title, args)
+			}
+		}
+	}
+	if _, command := desktopCommand("plan9"); command != nil {
+		t.Error("plan9: got a command")
+	}
+}
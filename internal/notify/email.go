@@ -0,0 +1,245 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mailQueueSize is the number of messages that may be pending before
+// further ones are dropped.
+const mailQueueSize = 1000
+
+// mailBackoff lists the waits before retrying a message the server
+// temporarily rejected or that could not be sent for network errors; its
+// length is the number of retries.
+var mailBackoff = []time.Duration{10 * time.Second, time.Minute}
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email through an SMTP server in the background, one message
+// at a time. Messages that still fail after retrying are logged and
+// dropped.
+type Mailer struct {
+	host    string
+	addr    string
+	auth    smtp.Auth
+	from    *mail.Address
+	timeout time.Duration
+	queue   chan Message
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewMailer returns a mailer sending from the given address through the
+// SMTP server at host and port, each message bounded by timeout. If username
+// is set, it authenticates with PLAIN auth, which net/smtp only allows over
+// TLS or to localhost. Port 465 uses implicit TLS, other ports STARTTLS if
+// the server offers it. Without host, Send does nothing.
+func NewMailer(host string, port int, username, password, from string, timeout time.Duration) (*Mailer, error) {
+	m := &Mailer{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		timeout: timeout,
+		queue:   make(chan Message, mailQueueSize),
+		done:    make(chan struct{}),
+	}
+	if host == "" {
+		close(m.done)
+		return m, nil
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	m.from = addr
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	go func() {
+		defer close(m.done)
+		for msg := range m.queue {
+			m.deliver(msg)
+		}
+	}()
+	return m, nil
+}
+
+// Enabled reports whether messages are actually sent.
+func (m *Mailer) Enabled() bool {
+	return m.host != ""
+}
+
+// Send queues a message and returns without waiting for delivery.
+func (m *Mailer) Send(msg Message) {
+	if m.host == "" {
+		return
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.queue <- msg:
+	default:
+		log.Printf("Mail queue full, dropping %q to %s", msg.Subject, msg.To)
+	}
+}
+
+// Close stops accepting messages and waits for queued ones to be sent, or
+// for ctx to be done.
+func (m *Mailer) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed && m.host != "" {
+		close(m.queue)
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("emails still pending: %w", ctx.Err())
+	}
+}
+
+// deliver sends a message, retrying temporary failures.
+func (m *Mailer) deliver(msg Message) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = m.send(msg)
+		if err == nil {
+			return
+		}
+		if !temporary(err) || attempt == len(mailBackoff) {
+			break
+		}
+		time.Sleep(mailBackoff[attempt])
+	}
+	log.Printf("Sending %q to %s failed: %v", msg.Subject, msg.To, err)
+}
+
+// temporary reports whether a failure is worth retrying: network errors
+// and 4xx replies of the server.
+func temporary(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// send makes a single attempt at sending a message.
+func (m *Mailer) send(msg Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if strings.HasSuffix(m.addr, ":465") {
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: m.host}}
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.compose(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose formats a message with its headers. The body is encoded as
+// quoted-printable, so it may contain any UTF-8 text.
+func (m *Mailer) compose(msg Message) []byte {
+	// Collapsing whitespace keeps line breaks in titles from ending the
+	// header early.
+	subject := strings.Join(strings.Fields(msg.Subject), " ")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", (&mail.Address{Address: msg.To}).String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", m.messageID())
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(msg.Body))
+	qp.Close()
+	return buf.Bytes()
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func (m *Mailer) messageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	domain := m.host
+	if i := strings.LastIndex(m.from.Address, "@"); i >= 0 {
+		domain = m.from.Address[i+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
@@ -0,0 +1,10 @@
+// Package notify delivers notifications about appointments.
+package notify
+
+import "context"
+
+// Notifier delivers a notification consisting of a short title and a
+// message body.
+type Notifier interface {
+	Notify(ctx context.Context, title, message string) error
+}
@@ -0,0 +1,134 @@
+// Package pb encodes appointments in the protocol buffer wire format,
+// following the messages defined in appointment.proto. Only encoding is
+// needed, which is simple enough to do without generated code.
+package pb
+
+import (
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// ContentType is the media type of encoded messages.
+const ContentType = "application/protobuf"
+
+// Wire types used by the messages.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// buffer accumulates an encoded message.
+type buffer []byte
+
+func (b *buffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *buffer) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int64 encodes an int64 or int32 field. Negative values take ten bytes,
+// as in the reference implementation.
+func (b *buffer) int64(field int, v int64) {
+	if v != 0 {
+		b.tag(field, wireVarint)
+		b.varint(uint64(v))
+	}
+}
+
+func (b *buffer) bool(field int, v bool) {
+	if v {
+		b.tag(field, wireVarint)
+		b.varint(1)
+	}
+}
+
+func (b *buffer) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) string(field int, v string) {
+	if v != "" {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// timestamp encodes a google.protobuf.Timestamp field.
+func (b *buffer) timestamp(field int, t time.Time) {
+	var ts buffer
+	ts.int64(1, t.Unix())
+	ts.int64(2, int64(t.Nanosecond()))
+	b.bytes(field, ts)
+}
+
+// MarshalAppointment encodes an Appointment message.
+func MarshalAppointment(a *models.Appointment) []byte {
+	var b buffer
+	b.int64(1, a.ID)
+	b.int64(2, a.UserID)
+	b.string(3, a.Title)
+	b.string(4, a.OriginalTitle)
+	b.string(5, a.Slug)
+	b.string(6, a.Description)
+	b.timestamp(7, a.StartTime)
+	b.timestamp(8, a.EndTime)
+	b.bool(9, a.AllDay)
+	b.string(10, a.Timezone)
+	b.string(11, a.Recurrence)
+	b.string(12, a.Status)
+	for _, at := range a.Attendees {
+		var m buffer
+		m.string(1, at.Email)
+		m.string(2, at.ResponseStatus)
+		m.int64(3, at.UserID)
+		b.bytes(13, m)
+	}
+	if a.ActualStart != nil {
+		b.timestamp(14, *a.ActualStart)
+	}
+	if a.ActualEnd != nil {
+		b.timestamp(15, *a.ActualEnd)
+	}
+	b.int64(16, int64(a.SortOrder))
+	b.timestamp(17, a.CreatedAt)
+	b.timestamp(18, a.UpdatedAt)
+	b.string(19, a.Place)
+	b.string(20, a.ConferenceURL)
+	b.string(21, a.Category)
+	b.string(22, a.Color)
+	if a.PrevOccurrence != nil {
+		b.timestamp(23, *a.PrevOccurrence)
+	}
+	if a.NextOccurrence != nil {
+		b.timestamp(24, *a.NextOccurrence)
+	}
+	b.string(25, a.UID)
+	for _, t := range a.ExDates {
+		b.timestamp(26, t)
+	}
+	if a.DeletedAt != nil {
+		b.timestamp(27, *a.DeletedAt)
+	}
+	b.int64(28, a.Version)
+	return b
+}
+
+// MarshalAppointmentList encodes an AppointmentList message.
+func MarshalAppointmentList(appointments []*models.Appointment, total, limit, offset int) []byte {
+	var b buffer
+	for _, a := range appointments {
+		b.bytes(1, MarshalAppointment(a))
+	}
+	b.int64(2, int64(total))
+	b.int64(3, int64(limit))
+	b.int64(4, int64(offset))
+	return b
+}
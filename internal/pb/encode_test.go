@@ -0,0 +1,138 @@
+package pb
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// zeroAppointment is the encoding of an empty Appointment: all fields are
+// left out but the timestamps the JSON representation always includes,
+// which encode 0001-01-01T00:00:00Z as negative seconds.
+const zeroAppointment = "" +
+	"3a0b088092b8c398feffffff01" + // start_time
+	"420b088092b8c398feffffff01" + // end_time
+	"8a010b088092b8c398feffffff01" + // created_at
+	"92010b088092b8c398feffffff01" // updated_at
+
+func TestMarshalAppointment(t *testing.T) {
+	at := func(y int, m time.Month, d, hh, mm, ss, ns int) *time.Time {
+		t := time.Date(y, m, d, hh, mm, ss, ns, time.UTC)
+		return &t
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		about string
+		a     *models.Appointment
+		want  string
+	}{
+		{"zero values", &models.Appointment{}, zeroAppointment},
+		{
+			"all fields",
+			&models.Appointment{
+				ID:            42,
+				UserID:        7,
+				Title:         "Standup",
+				OriginalTitle: "standup!",
+				Slug:          "standup",
+				Description:   "Daily sync",
+				// Timestamps are instants, regardless of their zone.
+				StartTime:  at(2024, 3, 1, 9, 0, 30, 500000000).In(berlin),
+				EndTime:    *at(2024, 3, 1, 9, 15, 0, 0),
+				AllDay:     true,
+				Timezone:   "Europe/Berlin",
+				Recurrence: "FREQ=DAILY",
+				Status:     models.StatusTentative,
+				Attendees: []models.Attendee{
+					{Email: "bob@example.org", ResponseStatus: "accepted", UserID: 3},
+					{Email: "carol@example.org", ResponseStatus: "needs-action"},
+				},
+				// Before 1970, seconds are negative and nanoseconds
+				// positive.
+				ActualStart:    at(1969, 12, 31, 23, 59, 59, 250000000),
+				ActualEnd:      at(1970, 1, 1, 0, 0, 0, 0),
+				SortOrder:      -3,
+				CreatedAt:      *at(2024, 2, 1, 12, 0, 0, 0),
+				UpdatedAt:      *at(2024, 2, 2, 12, 0, 0, 1000000),
+				Place:          "Room 1",
+				ConferenceURL:  "https://meet.example.org/standup",
+				Category:       "work",
+				Color:          "#ff8800",
+				PrevOccurrence: at(2024, 2, 29, 9, 0, 30, 500000000),
+				NextOccurrence: at(2024, 3, 2, 9, 0, 30, 500000000),
+				UID:            "standup@example.org",
+				ExDates: []time.Time{
+					*at(2024, 3, 3, 9, 0, 30, 500000000),
+					*at(2024, 3, 4, 9, 0, 30, 500000000),
+				},
+				DeletedAt: at(2024, 3, 5, 0, 0, 0, 0),
+				Version:   5,
+			},
+			"" +
+				"082a" + // id
+				"1007" + // user_id
+				"1a075374616e647570" + // title
+				"22087374616e64757021" + // original_title
+				"2a077374616e647570" + // slug
+				"320a4461696c792073796e63" + // description
+				"3a0c08aeb286af061080cab5ee01" + // start_time
+				"42060894b986af06" + // end_time
+				"4801" + // all_day
+				"520d4575726f70652f4265726c696e" + // timezone
+				"5a0a465245513d4441494c59" + // recurrence
+				"620974656e746174697665" + // status
+				"6a1d0a0f626f62406578616d706c652e6f7267120861636365707465641803" + // attendees
+				"6a210a116361726f6c406578616d706c652e6f7267120c6e656564732d616374696f6e" +
+				"721008ffffffffffffffffff011080e59a77" + // actual_start
+				"7a00" + // actual_end, the epoch, with both fields left out
+				"8001fdffffffffffffffff01" + // sort_order
+				"8a010608c08feead06" + // created_at
+				"92010a08c0b2f3ad0610c0843d" + // updated_at
+				"9a0106526f6f6d2031" + // location
+				"a2012068747470733a2f2f6d6565742e6578616d706c652e6f72672f7374616e647570" + // conference_url
+				"aa0104776f726b" + // category
+				"b2010723666638383030" + // color
+				"ba010c08ae8f81af061080cab5ee01" + // prev_occurrence
+				"c2010c08aed58baf061080cab5ee01" + // next_occurrence
+				"ca01137374616e647570406578616d706c652e6f7267" + // uid
+				"d2010c08aef890af061080cab5ee01" + // exdates
+				"d2010c08ae9b96af061080cab5ee01" +
+				"da01060880c199af06" + // deleted_at
+				"e00105", // version
+		},
+	}
+	for _, c := range cases {
+		if got := hex.EncodeToString(MarshalAppointment(c.a)); got != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.about, got, c.want)
+		}
+	}
+}
+
+func TestMarshalAppointmentList(t *testing.T) {
+	var cases = []struct {
+		about                string
+		appointments         []*models.Appointment
+		total, limit, offset int
+		want                 string
+	}{
+		{"empty", nil, 0, 0, 0, ""},
+		{"past the end", nil, 5, 1, 5, "100518012005"},
+		{
+			"appointments",
+			[]*models.Appointment{{}, {}},
+			2, 100, 0,
+			"0a36" + zeroAppointment + "0a36" + zeroAppointment + "1002" + "1864",
+		},
+	}
+	for _, c := range cases {
+		got := hex.EncodeToString(MarshalAppointmentList(c.appointments, c.total, c.limit, c.offset))
+		if got != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.about, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package ratelimit limits the rate of events per key with token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter holds a token bucket per key, such as a client address. Each bucket
+// holds up to burst tokens and is refilled at rate tokens per second; every
+// allowed event takes one token.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing rate events per second and bursts of up to
+// burst events per key. A burst below one is raised to one.
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:      rate,
+		burst:     math.Max(float64(burst), 1),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow reports whether an event for key may happen now and takes a token
+// if so. Otherwise it returns how long to wait until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets buckets that have refilled completely, as a new bucket would
+// be the same, so the map does not grow with every client ever seen. It runs
+// at most once per refill period.
+func (l *Limiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < max(full, time.Minute) {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
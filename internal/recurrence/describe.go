@@ -0,0 +1,61 @@
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Describe returns an English sentence for a rule, e.g. "Every 2 weeks on
+// Monday and Wednesday until Dec 31, 2024."
+func Describe(rule string) (string, error) {
+	r, err := Parse(rule)
+	if err != nil {
+		return "", err
+	}
+	return r.Describe(), nil
+}
+
+// Describe returns an English sentence for the rule.
+func (r *Rule) Describe() string {
+	var sb strings.Builder
+	unit := map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month", Yearly: "year"}[r.Freq]
+	if r.Interval == 1 {
+		sb.WriteString("Every " + unit)
+	} else {
+		fmt.Fprintf(&sb, "Every %d %ss", r.Interval, unit)
+	}
+	if len(r.ByDay) > 0 {
+		sb.WriteString(" on " + r.describeDays())
+	}
+	switch {
+	case r.Count == 1:
+		sb.WriteString(", once")
+	case r.Count > 1:
+		fmt.Fprintf(&sb, ", %d times", r.Count)
+	case !r.Until.IsZero():
+		sb.WriteString(" until " + r.Until.Format("Jan 2, 2006"))
+	}
+	sb.WriteString(".")
+	return sb.String()
+}
+
+// describeDays lists the weekdays of the rule in week order, starting with
+// the rule's week start.
+func (r *Rule) describeDays() string {
+	days := append(r.ByDay[:0:0], r.ByDay...)
+	offset := func(i int) int { return (int(days[i]) - int(r.WeekStart) + 7) % 7 }
+	sort.Slice(days, func(i, j int) bool { return offset(i) < offset(j) })
+
+	var names []string
+	for i, d := range days {
+		if i > 0 && d == days[i-1] {
+			continue
+		}
+		names = append(names, d.String())
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
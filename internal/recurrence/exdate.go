@@ -0,0 +1,35 @@
+package recurrence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FormatDates formats times as a comma-separated list of UTC date-times,
+// like the value of an EXDATE property, e.g.
+// "20240108T090000Z,20240115T090000Z". Fractions of a second are dropped.
+func FormatDates(ts []time.Time) string {
+	values := make([]string, len(ts))
+	for i, t := range ts {
+		values[i] = t.UTC().Format(utcLayout)
+	}
+	return strings.Join(values, ",")
+}
+
+// ParseDates parses a list written by FormatDates. An empty string yields
+// no times.
+func ParseDates(s string) ([]time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ts []time.Time
+	for _, v := range strings.Split(s, ",") {
+		t, err := time.Parse(utcLayout, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", v)
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
@@ -0,0 +1,275 @@
+// Package recurrence parses and expands iCalendar recurrence rules (RRULE,
+// RFC 5545). Only the subset commonly produced by calendar clients is
+// supported: FREQ, INTERVAL, COUNT, UNTIL, BYDAY (weekly rules) and WKST.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the base unit of a recurrence rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+const (
+	// MaxOccurrences caps the number of occurrences returned by Between, so
+	// a wide range over an unbounded rule cannot exhaust memory.
+	MaxOccurrences = 10000
+	// maxPeriods caps the number of periods (days, weeks, months, years) a
+	// rule is iterated over, so unbounded rules always terminate.
+	maxPeriods = 100000
+)
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// Count limits the total number of occurrences, including the first.
+	// Zero means no limit.
+	Count int
+	// Until is the inclusive upper bound for occurrence starts. The zero
+	// value means no bound.
+	Until time.Time
+	// ByDay lists the weekdays of a weekly rule. If empty, the weekday of
+	// the first occurrence is used.
+	ByDay     []time.Weekday
+	WeekStart time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Parse parses a rule like "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10". An optional
+// "RRULE:" prefix is accepted. Unsupported rule parts are rejected rather
+// than ignored, so a rule never silently expands differently than intended.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("empty rule")
+	}
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch f := Frequency(strings.ToUpper(value)); f {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = f
+			default:
+				return nil, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid interval %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid count %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported weekday %q", day)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "WKST":
+			wd, ok := weekdays[strings.ToUpper(value)]
+			if !ok {
+				return nil, fmt.Errorf("invalid week start %q", value)
+			}
+			r.WeekStart = wd
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	if r.Freq == "" {
+		return nil, fmt.Errorf("missing FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL are mutually exclusive")
+	}
+	if len(r.ByDay) > 0 && r.Freq != Weekly {
+		return nil, fmt.Errorf("BYDAY is only supported for weekly rules")
+	}
+	return r, nil
+}
+
+// utcLayout is the layout of UTC date-times in rules and date lists.
+const utcLayout = "20060102T150405Z"
+
+// parseUntil parses UTC, floating and date-only UNTIL values. Floating times
+// are taken as UTC. A date includes the whole day.
+func parseUntil(v string) (time.Time, error) {
+	for _, layout := range []string{utcLayout, "20060102T150405"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse("20060102", v); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid until %q", v)
+}
+
+// Iterate calls fn with the start of each occurrence of a series whose first
+// occurrence starts at dtstart, in chronological order, until fn returns
+// false or the series ends. Occurrences keep the wall clock time of dtstart
+// in its location, including seconds and fractions of a second.
+func (r *Rule) Iterate(dtstart time.Time, fn func(time.Time) bool) {
+	n := 0
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		if !fn(t) {
+			return false
+		}
+		n++
+		return r.Count == 0 || n < r.Count
+	}
+
+	var (
+		y, m, d = dtstart.Date()
+		hh      = dtstart.Hour()
+		mm      = dtstart.Minute()
+		ss      = dtstart.Second()
+		ns      = dtstart.Nanosecond()
+		loc     = dtstart.Location()
+	)
+	switch r.Freq {
+	case Daily:
+		for i := 0; i < maxPeriods; i++ {
+			if !emit(time.Date(y, m, d+i*r.Interval, hh, mm, ss, ns, loc)) {
+				return
+			}
+		}
+	case Weekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		// Offsets of the rule's weekdays from the start of the week.
+		offsets := make([]int, 0, len(days))
+		for _, wd := range days {
+			offsets = append(offsets, (int(wd)-int(r.WeekStart)+7)%7)
+		}
+		sort.Ints(offsets)
+		weekStart := d - (int(dtstart.Weekday())-int(r.WeekStart)+7)%7
+		for i := 0; i < maxPeriods; i++ {
+			for j, off := range offsets {
+				if j > 0 && off == offsets[j-1] {
+					continue
+				}
+				t := time.Date(y, m, weekStart+i*7*r.Interval+off, hh, mm, ss, ns, loc)
+				if t.Before(dtstart) {
+					continue
+				}
+				if !emit(t) {
+					return
+				}
+			}
+		}
+	case Monthly:
+		for i := 0; i < maxPeriods; i++ {
+			t := time.Date(y, m+time.Month(i*r.Interval), d, hh, mm, ss, ns, loc)
+			if t.Day() != d {
+				// Months without this day, like the 31st in April, are
+				// skipped, as in RFC 5545.
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	case Yearly:
+		for i := 0; i < maxPeriods; i++ {
+			t := time.Date(y+i*r.Interval, m, d, hh, mm, ss, ns, loc)
+			if t.Day() != d {
+				// February 29 only occurs in leap years.
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the starts of all occurrences within [from, to], at most
+// MaxOccurrences.
+func (r *Rule) Between(dtstart, from, to time.Time) []time.Time {
+	var result []time.Time
+	r.Iterate(dtstart, func(t time.Time) bool {
+		if t.After(to) {
+			return false
+		}
+		if !t.Before(from) {
+			result = append(result, t)
+		}
+		return len(result) < MaxOccurrences
+	})
+	return result
+}
+
+// String formats the rule as an RRULE value like "FREQ=WEEKLY;BYDAY=MO,WE",
+// leaving out parts that have their default value. UNTIL is written in UTC.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(utcLayout))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = dayCode(wd)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+dayCode(r.WeekStart))
+	}
+	return strings.Join(parts, ";")
+}
+
+// dayCode returns the two letter code of a weekday, e.g. "MO".
+func dayCode(wd time.Weekday) string {
+	return strings.ToUpper(wd.String()[:2])
+}
@@ -0,0 +1,255 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+// occurrences returns the first n occurrences of rule from dtstart.
+func occurrences(t *testing.T, rule string, dtstart time.Time, n int) []time.Time {
+	t.Helper()
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rule, err)
+	}
+	var result []time.Time
+	r.Iterate(dtstart, func(o time.Time) bool {
+		result = append(result, o)
+		return len(result) < n
+	})
+	return result
+}
+
+func TestIterate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(y int, m time.Month, d, hh, mm int, loc *time.Location) time.Time {
+		return time.Date(y, m, d, hh, mm, 0, 0, loc)
+	}
+	var cases = []struct {
+		about   string
+		rule    string
+		dtstart time.Time
+		n       int
+		want    []time.Time
+	}{
+		{
+			"daily",
+			"FREQ=DAILY;COUNT=3",
+			date(2026, 1, 30, 9, 0, time.UTC),
+			10,
+			[]time.Time{
+				date(2026, 1, 30, 9, 0, time.UTC),
+				date(2026, 1, 31, 9, 0, time.UTC),
+				date(2026, 2, 1, 9, 0, time.UTC),
+			},
+		},
+		{
+			"daily with interval",
+			"FREQ=DAILY;INTERVAL=2",
+			date(2026, 2, 27, 9, 0, time.UTC),
+			3,
+			[]time.Time{
+				date(2026, 2, 27, 9, 0, time.UTC),
+				date(2026, 3, 1, 9, 0, time.UTC),
+				date(2026, 3, 3, 9, 0, time.UTC),
+			},
+		},
+		{
+			"daily across the start of DST keeps the wall clock time",
+			"FREQ=DAILY",
+			date(2026, 3, 28, 9, 0, berlin),
+			3,
+			[]time.Time{
+				date(2026, 3, 28, 9, 0, berlin),
+				date(2026, 3, 29, 9, 0, berlin),
+				date(2026, 3, 30, 9, 0, berlin),
+			},
+		},
+		{
+			"daily across the end of DST keeps the wall clock time",
+			"FREQ=DAILY",
+			date(2026, 10, 24, 9, 0, berlin),
+			3,
+			[]time.Time{
+				date(2026, 10, 24, 9, 0, berlin),
+				date(2026, 10, 25, 9, 0, berlin),
+				date(2026, 10, 26, 9, 0, berlin),
+			},
+		},
+		{
+			"daily until is inclusive",
+			"FREQ=DAILY;UNTIL=20260103T090000Z",
+			date(2026, 1, 1, 9, 0, time.UTC),
+			10,
+			[]time.Time{
+				date(2026, 1, 1, 9, 0, time.UTC),
+				date(2026, 1, 2, 9, 0, time.UTC),
+				date(2026, 1, 3, 9, 0, time.UTC),
+			},
+		},
+		{
+			"weekly on the weekday of the start",
+			"FREQ=WEEKLY;COUNT=3",
+			date(2026, 1, 7, 14, 0, time.UTC), // Wednesday
+			10,
+			[]time.Time{
+				date(2026, 1, 7, 14, 0, time.UTC),
+				date(2026, 1, 14, 14, 0, time.UTC),
+				date(2026, 1, 21, 14, 0, time.UTC),
+			},
+		},
+		{
+			"weekly by day skips days before the start",
+			"FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=5",
+			date(2026, 1, 7, 14, 0, time.UTC), // Wednesday
+			10,
+			[]time.Time{
+				date(2026, 1, 7, 14, 0, time.UTC),
+				date(2026, 1, 9, 14, 0, time.UTC),
+				date(2026, 1, 12, 14, 0, time.UTC),
+				date(2026, 1, 14, 14, 0, time.UTC),
+				date(2026, 1, 16, 14, 0, time.UTC),
+			},
+		},
+		{
+			"biweekly by day with the week starting on sunday",
+			"FREQ=WEEKLY;INTERVAL=2;BYDAY=SU,TU;WKST=SU",
+			date(2026, 1, 4, 10, 0, time.UTC), // Sunday
+			4,
+			[]time.Time{
+				date(2026, 1, 4, 10, 0, time.UTC),
+				date(2026, 1, 6, 10, 0, time.UTC),
+				date(2026, 1, 18, 10, 0, time.UTC),
+				date(2026, 1, 20, 10, 0, time.UTC),
+			},
+		},
+		{
+			"weekly by day across the start of DST",
+			"FREQ=WEEKLY;BYDAY=SA,SU",
+			date(2026, 3, 21, 8, 30, berlin),
+			4,
+			[]time.Time{
+				date(2026, 3, 21, 8, 30, berlin),
+				date(2026, 3, 22, 8, 30, berlin),
+				date(2026, 3, 28, 8, 30, berlin),
+				date(2026, 3, 29, 8, 30, berlin),
+			},
+		},
+		{
+			"monthly skips months without the day",
+			"FREQ=MONTHLY;COUNT=4",
+			date(2026, 1, 31, 9, 0, time.UTC),
+			10,
+			[]time.Time{
+				date(2026, 1, 31, 9, 0, time.UTC),
+				date(2026, 3, 31, 9, 0, time.UTC),
+				date(2026, 5, 31, 9, 0, time.UTC),
+				date(2026, 7, 31, 9, 0, time.UTC),
+			},
+		},
+		{
+			"quarterly",
+			"FREQ=MONTHLY;INTERVAL=3",
+			date(2026, 11, 15, 9, 0, time.UTC),
+			3,
+			[]time.Time{
+				date(2026, 11, 15, 9, 0, time.UTC),
+				date(2027, 2, 15, 9, 0, time.UTC),
+				date(2027, 5, 15, 9, 0, time.UTC),
+			},
+		},
+		{
+			"yearly",
+			"FREQ=YEARLY;COUNT=2",
+			date(2026, 6, 1, 12, 0, time.UTC),
+			10,
+			[]time.Time{
+				date(2026, 6, 1, 12, 0, time.UTC),
+				date(2027, 6, 1, 12, 0, time.UTC),
+			},
+		},
+		{
+			"yearly on february 29 only in leap years",
+			"FREQ=YEARLY",
+			date(2024, 2, 29, 12, 0, time.UTC),
+			3,
+			[]time.Time{
+				date(2024, 2, 29, 12, 0, time.UTC),
+				date(2028, 2, 29, 12, 0, time.UTC),
+				date(2032, 2, 29, 12, 0, time.UTC),
+			},
+		},
+	}
+	for _, c := range cases {
+		got := occurrences(t, c.rule, c.dtstart, c.n)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %d occurrences %v, want %d", c.about, len(got), got, len(c.want))
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(c.want[i]) || got[i].Location() != c.want[i].Location() {
+				t.Errorf("%s: occurrence %d is %v, want %v", c.about, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestIterateKeepsSeconds(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		rule    string
+		dtstart time.Time
+	}{
+		{"FREQ=DAILY", time.Date(2026, 3, 27, 9, 0, 30, 0, berlin)},
+		{"FREQ=DAILY;INTERVAL=3", time.Date(2026, 10, 20, 9, 0, 30, 0, berlin)},
+		{"FREQ=WEEKLY;BYDAY=MO,TH,SU", time.Date(2026, 3, 19, 9, 0, 30, 0, berlin)},
+		{"FREQ=MONTHLY", time.Date(2026, 1, 31, 9, 0, 30, 0, time.UTC)},
+		{"FREQ=YEARLY", time.Date(2024, 2, 29, 9, 0, 30, 0, time.UTC)},
+		{"FREQ=DAILY", time.Date(2026, 3, 27, 9, 0, 30, 250000000, berlin)},
+	}
+	for _, c := range cases {
+		got := occurrences(t, c.rule, c.dtstart, 20)
+		if len(got) != 20 {
+			t.Errorf("%s: got %d occurrences, want 20", c.rule, len(got))
+			continue
+		}
+		if !got[0].Equal(c.dtstart) {
+			t.Errorf("%s: first occurrence is %v, want the start %v", c.rule, got[0], c.dtstart)
+		}
+		for i, o := range got {
+			if o.Hour() != 9 || o.Minute() != 0 || o.Second() != 30 || o.Nanosecond() != c.dtstart.Nanosecond() {
+				t.Errorf("%s: occurrence %d is at %s, want 09:00:30.%09d",
+					c.rule, i, o.Format("15:04:05.000000000"), c.dtstart.Nanosecond())
+			}
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	r, err := Parse("FREQ=DAILY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtstart := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		from, to time.Time
+		want     int
+	}{
+		{dtstart, dtstart, 1},
+		{dtstart.Add(time.Second), dtstart.AddDate(0, 0, 1), 1},
+		{dtstart.AddDate(0, 0, -10), dtstart.AddDate(0, 0, 9), 10},
+		{dtstart.AddDate(0, 0, -10), dtstart.Add(-time.Second), 0},
+		{dtstart, dtstart.AddDate(100, 0, 0), MaxOccurrences},
+	}
+	for _, c := range cases {
+		if got := len(r.Between(dtstart, c.from, c.to)); got != c.want {
+			t.Errorf("between %v and %v: got %d occurrences, want %d", c.from, c.to, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package reminder sends reminders of upcoming appointments in the
+// background.
+package reminder
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/miku/cali/internal/db"
+)
+
+// Scheduler periodically looks for due reminders and hands them to a
+// function for delivery.
+type Scheduler struct {
+	db           db.ReminderStore
+	interval     time.Duration
+	tolerance    time.Duration
+	deferSnoozed bool
+	send         func(db.DueReminder)
+}
+
+// Options configure a Scheduler.
+type Options struct {
+	// Interval is how often due reminders are looked for.
+	Interval time.Duration
+	// Tolerance is how early a reminder may be sent, up to Interval:
+	// each scan includes the reminders coming due within Tolerance after
+	// it, which would otherwise be sent up to Interval late.
+	Tolerance time.Duration
+	// DeferSnoozed delivers the reminders that came due while their user
+	// snoozed reminders when the snooze ends, rather than skipping them.
+	DeferSnoozed bool
+}
+
+// NewScheduler returns a scheduler scanning for due reminders as configured
+// by opts and passing them to send.
+func NewScheduler(database db.ReminderStore, opts Options, send func(db.DueReminder)) *Scheduler {
+	return &Scheduler{
+		db:           database,
+		interval:     opts.Interval,
+		tolerance:    opts.Tolerance,
+		deferSnoozed: opts.DeferSnoozed,
+		send:         send,
+	}
+}
+
+// Run scans for reminders until ctx is done. Each scan covers the time from
+// the end of the previous one up to tolerance ahead, so every reminder is
+// sent once, up to tolerance early or interval minus tolerance late, and
+// marked as sent afterwards. Reminders that came due while the server was
+// not running are skipped rather than sent in a burst on startup: they
+// would arrive too late to be useful, possibly after the appointment
+// started.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			until := now.Add(s.tolerance)
+			err := s.scan(ctx, since, until)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// Try the same window again on the next tick.
+				log.Printf("Failed to scan for reminders: %v", err)
+				continue
+			}
+			since = until
+		}
+	}
+}
+
+// scan sends the reminders due within (from, to]. Reminders of users who
+// snoozed them are skipped. With deferSnoozed, the reminders skipped during
+// a snooze ending within the window are sent instead, unless the
+// appointment is over by the end of the snooze. They are sent first, as
+// sending later reminders marks earlier ones as sent.
+func (s *Scheduler) scan(ctx context.Context, from, to time.Time) error {
+	due, err := s.db.DueReminders(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	snoozes, err := s.db.ReminderSnoozes(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	if s.deferSnoozed {
+		for userID, sn := range snoozes {
+			if sn.Until.After(to) {
+				continue
+			}
+			deferred, err := s.db.UserDueReminders(ctx, userID, sn.From, sn.Until)
+			if err != nil {
+				return err
+			}
+			for _, d := range deferred {
+				if d.Appointment.EndTime.After(sn.Until) {
+					s.deliver(ctx, d)
+				}
+			}
+		}
+	}
+	for _, d := range due {
+		if sn, ok := snoozes[d.Appointment.UserID]; ok && sn.Covers(d.At) {
+			continue
+		}
+		s.deliver(ctx, d)
+	}
+	return nil
+}
+
+// deliver sends a reminder and marks it as sent.
+func (s *Scheduler) deliver(ctx context.Context, d db.DueReminder) {
+	s.send(d)
+	if err := s.db.MarkReminderSent(ctx, d.Reminder.ID, d.At); err != nil {
+		log.Printf("Reminder %d: %v", d.Reminder.ID, err)
+	}
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BookingHours restricts when appointments may take place, like the
+// opening hours of a business.
+type BookingHours struct {
+	// Open and Close bound the time of day as minutes after midnight. If
+	// both are zero, any time of day is allowed.
+	Open, Close int
+	// Days lists the weekdays appointments may fall on. If empty, all
+	// days are allowed.
+	Days []time.Weekday
+	// Location is the timezone the hours and days are evaluated in.
+	Location *time.Location
+}
+
+// Check returns an error describing why an appointment from start to end
+// lies outside the booking hours, or nil if it does not.
+func (b *BookingHours) Check(start, end time.Time) error {
+	start, end = start.In(b.Location), end.In(b.Location)
+	if err := b.checkDays(start, end); err != nil {
+		return err
+	}
+	if b.Open == 0 && b.Close == 0 {
+		return nil
+	}
+	y, m, d := start.Date()
+	opening := time.Date(y, m, d, 0, b.Open, 0, 0, b.Location)
+	closing := time.Date(y, m, d, 0, b.Close, 0, 0, b.Location)
+	if start.Before(opening) {
+		return fmt.Errorf("starts at %s, before opening at %s", start.Format("15:04"), opening.Format("15:04"))
+	}
+	if end.After(closing) {
+		at := end.Format("15:04")
+		if end.YearDay() != start.YearDay() || end.Year() != start.Year() {
+			at = end.Format("Jan 2 15:04")
+		}
+		return fmt.Errorf("ends at %s, after closing at %s", at, closing.Format("15:04"))
+	}
+	return nil
+}
+
+// CheckDates is like Check for an appointment lasting whole days, from the
+// date of first to the date of last. Only the weekdays are restricted.
+func (b *BookingHours) CheckDates(first, last time.Time) error {
+	y, m, d := last.Date()
+	return b.checkDays(first, time.Date(y, m, d+1, 0, 0, 0, 0, last.Location()))
+}
+
+// checkDays checks that all days from the date of start to the date of end
+// are allowed. An end at midnight does not include its day.
+func (b *BookingHours) checkDays(start, end time.Time) error {
+	if len(b.Days) == 0 {
+		return nil
+	}
+	y, m, d := start.Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, start.Location()); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if day.Equal(end) && day.After(start) {
+			break
+		}
+		if !b.allowed(day.Weekday()) {
+			return fmt.Errorf("falls on %s; allowed days are %s", day.Weekday(), b.dayList())
+		}
+	}
+	return nil
+}
+
+func (b *BookingHours) allowed(wd time.Weekday) bool {
+	for _, d := range b.Days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// dayList names the allowed weekdays.
+func (b *BookingHours) dayList() string {
+	names := make([]string, len(b.Days))
+	for i, d := range b.Days {
+		names[i] = d.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,92 @@
+// Package schedule implements calculations on time intervals, such as
+// finding free slots between appointments.
+package schedule
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval is the half-open time range [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of the interval.
+func (iv Interval) Duration() time.Duration {
+	return iv.End.Sub(iv.Start)
+}
+
+// Merge returns the union of the given intervals as a sorted list of
+// disjoint intervals. Overlapping and adjacent intervals are combined into
+// one; empty intervals are dropped. The input is not modified.
+func Merge(intervals []Interval) []Interval {
+	sorted := make([]Interval, 0, len(intervals))
+	for _, iv := range intervals {
+		if iv.End.After(iv.Start) {
+			sorted = append(sorted, iv)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	var merged []Interval
+	for _, iv := range sorted {
+		if n := len(merged); n > 0 && !iv.Start.After(merged[n-1].End) {
+			if iv.End.After(merged[n-1].End) {
+				merged[n-1].End = iv.End
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// Free returns the parts of window not covered by any of the busy intervals
+// that last at least minDuration. Busy intervals extending beyond the window
+// are clipped to it. The result is never nil.
+func Free(window Interval, busy []Interval, minDuration time.Duration) []Interval {
+	free := []Interval{}
+	cursor := window.Start
+	add := func(end time.Time) {
+		if iv := (Interval{Start: cursor, End: end}); iv.Duration() > 0 && iv.Duration() >= minDuration {
+			free = append(free, iv)
+		}
+	}
+	for _, b := range Merge(busy) {
+		if !b.End.After(cursor) {
+			continue
+		}
+		if !b.Start.Before(window.End) {
+			break
+		}
+		if b.Start.After(cursor) {
+			add(b.Start)
+		}
+		cursor = b.End
+	}
+	if cursor.Before(window.End) {
+		add(window.End)
+	}
+	return free
+}
+
+// SplitDays splits iv at each midnight in loc, returning one interval per
+// calendar day it touches. Days follow the wall clock in loc, so days with
+// DST transitions last 23 or 25 hours.
+func SplitDays(iv Interval, loc *time.Location) []Interval {
+	var days []Interval
+	for start := iv.Start.In(loc); start.Before(iv.End); {
+		y, m, d := start.Date()
+		end := time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		if end.After(iv.End) {
+			end = iv.End.In(loc)
+		}
+		days = append(days, Interval{Start: start, End: end})
+		start = end
+	}
+	return days
+}
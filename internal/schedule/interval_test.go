@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(m time.Month, d, hh, mm int, loc *time.Location) time.Time {
+		return time.Date(2026, m, d, hh, mm, 0, 0, loc)
+	}
+	var cases = []struct {
+		about string
+		iv    Interval
+		loc   *time.Location
+		want  []Interval
+	}{
+		{
+			"within a day",
+			Interval{at(1, 5, 9, 0, time.UTC), at(1, 5, 17, 0, time.UTC)},
+			time.UTC,
+			[]Interval{{at(1, 5, 9, 0, time.UTC), at(1, 5, 17, 0, time.UTC)}},
+		},
+		{
+			"empty",
+			Interval{at(1, 5, 9, 0, time.UTC), at(1, 5, 9, 0, time.UTC)},
+			time.UTC,
+			nil,
+		},
+		{
+			"across midnight",
+			Interval{at(1, 5, 22, 0, time.UTC), at(1, 6, 2, 0, time.UTC)},
+			time.UTC,
+			[]Interval{
+				{at(1, 5, 22, 0, time.UTC), at(1, 6, 0, 0, time.UTC)},
+				{at(1, 6, 0, 0, time.UTC), at(1, 6, 2, 0, time.UTC)},
+			},
+		},
+		{
+			"ending at midnight",
+			Interval{at(1, 5, 22, 0, time.UTC), at(1, 6, 0, 0, time.UTC)},
+			time.UTC,
+			[]Interval{{at(1, 5, 22, 0, time.UTC), at(1, 6, 0, 0, time.UTC)}},
+		},
+		{
+			"whole days",
+			Interval{at(1, 5, 0, 0, time.UTC), at(1, 8, 0, 0, time.UTC)},
+			time.UTC,
+			[]Interval{
+				{at(1, 5, 0, 0, time.UTC), at(1, 6, 0, 0, time.UTC)},
+				{at(1, 6, 0, 0, time.UTC), at(1, 7, 0, 0, time.UTC)},
+				{at(1, 7, 0, 0, time.UTC), at(1, 8, 0, 0, time.UTC)},
+			},
+		},
+		{
+			"midnight of another zone",
+			Interval{at(1, 5, 20, 0, time.UTC), at(1, 5, 23, 30, time.UTC)},
+			berlin,
+			[]Interval{
+				{at(1, 5, 21, 0, berlin), at(1, 6, 0, 0, berlin)},
+				{at(1, 6, 0, 0, berlin), at(1, 6, 0, 30, berlin)},
+			},
+		},
+		{
+			"day of the start of DST lasts 23 hours",
+			Interval{at(3, 29, 0, 0, berlin), at(3, 30, 0, 0, berlin)},
+			berlin,
+			[]Interval{{at(3, 29, 0, 0, berlin), at(3, 30, 0, 0, berlin)}},
+		},
+		{
+			"across the end of DST",
+			Interval{at(10, 24, 12, 0, berlin), at(10, 26, 12, 0, berlin)},
+			berlin,
+			[]Interval{
+				{at(10, 24, 12, 0, berlin), at(10, 25, 0, 0, berlin)},
+				{at(10, 25, 0, 0, berlin), at(10, 26, 0, 0, berlin)},
+				{at(10, 26, 0, 0, berlin), at(10, 26, 12, 0, berlin)},
+			},
+		},
+	}
+	for _, c := range cases {
+		got := SplitDays(c.iv, c.loc)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %d days %v, want %d", c.about, len(got), got, len(c.want))
+			continue
+		}
+		for i := range got {
+			if !got[i].Start.Equal(c.want[i].Start) || !got[i].End.Equal(c.want[i].End) {
+				t.Errorf("%s: day %d is %v to %v, want %v to %v", c.about, i,
+					got[i].Start, got[i].End, c.want[i].Start, c.want[i].End)
+			}
+			if got[i].Start.Location() != c.loc {
+				t.Errorf("%s: day %d starts in %v, want %v", c.about, i, got[i].Start.Location(), c.loc)
+			}
+		}
+	}
+
+	// Durations follow the wall clock.
+	var durations = []struct {
+		day  Interval
+		want time.Duration
+	}{
+		{Interval{at(3, 29, 0, 0, berlin), at(3, 30, 0, 0, berlin)}, 23 * time.Hour},
+		{Interval{at(10, 25, 0, 0, berlin), at(10, 26, 0, 0, berlin)}, 25 * time.Hour},
+	}
+	for _, d := range durations {
+		days := SplitDays(d.day, berlin)
+		if len(days) != 1 || days[0].Duration() != d.want {
+			t.Errorf("day %v: got %v, want a single day of %v", d.day.Start, days, d.want)
+		}
+	}
+}
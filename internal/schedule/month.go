@@ -0,0 +1,40 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseWeekday parses the English name of a weekday, like "monday" or
+// "Sun", ignoring case.
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || (len(s) >= 3 && strings.HasPrefix(name, s)) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// MonthGrid returns the days of a month calendar as rows of seven days,
+// each at midnight in loc. Weeks start on firstDay, and the first and last
+// week are filled with days of the adjacent months.
+func MonthGrid(year int, month time.Month, loc *time.Location, firstDay time.Weekday) [][]time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	lead := (int(first.Weekday()) - int(firstDay) + 7) % 7
+	day := first.AddDate(0, 0, -lead)
+
+	var weeks [][]time.Time
+	for len(weeks) == 0 || day.Month() == month {
+		week := make([]time.Time, 7)
+		for i := range week {
+			week[i] = day
+			day = day.AddDate(0, 0, 1)
+		}
+		weeks = append(weeks, week)
+	}
+	return weeks
+}
@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// MinSlotStep is the smallest step slots are searched with.
+	MinSlotStep = time.Minute
+	// MaxSlotCandidates bounds the slots ranked by Slots, so even many
+	// long free intervals take bounded time and memory to search.
+	MaxSlotCandidates = 50000
+)
+
+// Slots returns the intervals of the given duration within the free
+// intervals that start at a multiple of step after midnight, ranked for
+// booking. Slots that leave no gaps too short for another appointment of
+// the same duration come first, so the schedule stays compact; ties are
+// broken by start time. Only the earliest MaxSlotCandidates slots are
+// considered, and steps below MinSlotStep are raised to it. The result is
+// never nil.
+func Slots(free []Interval, duration, step time.Duration) []Interval {
+	step = max(step, MinSlotStep)
+	type candidate struct {
+		slot  Interval
+		waste time.Duration
+	}
+	// fragment returns the length of a gap if it is too short to be used.
+	fragment := func(gap time.Duration) time.Duration {
+		if gap < duration {
+			return gap
+		}
+		return 0
+	}
+
+	var candidates []candidate
+	for _, iv := range free {
+		for start := alignStep(iv.Start, step); !start.Add(duration).After(iv.End); start = start.Add(step) {
+			if len(candidates) == MaxSlotCandidates {
+				break
+			}
+			slot := Interval{Start: start, End: start.Add(duration)}
+			candidates = append(candidates, candidate{
+				slot:  slot,
+				waste: fragment(slot.Start.Sub(iv.Start)) + fragment(iv.End.Sub(slot.End)),
+			})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].waste != candidates[j].waste {
+			return candidates[i].waste < candidates[j].waste
+		}
+		return candidates[i].slot.Start.Before(candidates[j].slot.Start)
+	})
+
+	slots := make([]Interval, len(candidates))
+	for i, c := range candidates {
+		slots[i] = c.slot
+	}
+	return slots
+}
+
+// alignStep returns the earliest time not before t at a multiple of step
+// after midnight in the location of t.
+func alignStep(t time.Time, step time.Duration) time.Time {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if rem := offset % step; rem != 0 {
+		offset += step - rem
+	}
+	return midnight.Add(offset)
+}
@@ -0,0 +1,57 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlots(t *testing.T) {
+	at := func(d, hh, mm int) time.Time {
+		return time.Date(2026, 1, d, hh, mm, 0, 0, time.UTC)
+	}
+	var cases = []struct {
+		about    string
+		free     []Interval
+		duration time.Duration
+		step     time.Duration
+		// want are the first slots returned, count their number.
+		want  []Interval
+		count int
+	}{
+		{
+			"edges first",
+			[]Interval{{at(5, 9, 0), at(5, 10, 15)}},
+			30 * time.Minute,
+			15 * time.Minute,
+			[]Interval{{at(5, 9, 0), at(5, 9, 30)}, {at(5, 9, 45), at(5, 10, 15)}, {at(5, 9, 15), at(5, 9, 45)}},
+			4,
+		},
+		{
+			"step below the minimum",
+			[]Interval{{at(5, 9, 0), at(5, 9, 10)}},
+			5 * time.Minute,
+			time.Nanosecond,
+			[]Interval{{at(5, 9, 0), at(5, 9, 5)}, {at(5, 9, 5), at(5, 9, 10)}},
+			6,
+		},
+		{
+			"bounded candidates",
+			[]Interval{{at(1, 0, 0), at(40, 0, 0)}, {at(40, 0, 0), at(40, 1, 0)}},
+			time.Minute,
+			time.Minute,
+			[]Interval{{at(1, 0, 0), at(1, 0, 1)}},
+			MaxSlotCandidates,
+		},
+	}
+	for _, c := range cases {
+		got := Slots(c.free, c.duration, c.step)
+		if len(got) != c.count {
+			t.Errorf("%s: got %d slots, want %d", c.about, len(got), c.count)
+		}
+		for i, want := range c.want {
+			if i >= len(got) || !got[i].Start.Equal(want.Start) || !got[i].End.Equal(want.End) {
+				t.Errorf("%s: slot %d: got %v, want %v", c.about, i, got[min(i, len(got)-1)], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// View is the period covered by a calendar view.
+type View string
+
+const (
+	Day   View = "day"
+	Week  View = "week"
+	Month View = "month"
+)
+
+// ParseView parses the name of a view, ignoring case.
+func ParseView(s string) (View, error) {
+	switch v := View(strings.ToLower(strings.TrimSpace(s))); v {
+	case Day, Week, Month:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid view %q: expected day, week or month", s)
+}
+
+// Start returns the beginning of the period of the view containing t, in
+// the location of t. Weeks start on firstDay.
+func (v View) Start(t time.Time, firstDay time.Weekday) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch v {
+	case Week:
+		return day.AddDate(0, 0, -((int(day.Weekday()) - int(firstDay) + 7) % 7))
+	case Month:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// Next returns t advanced by one period of the view.
+func (v View) Next(t time.Time) time.Time {
+	switch v {
+	case Week:
+		return t.AddDate(0, 0, 7)
+	case Month:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
@@ -0,0 +1,324 @@
+// Package webhook notifies external services of appointment changes.
+//
+// Payloads posted to targets with a secret are signed. Each attempt carries
+// the current Unix time in seconds in the X-Cali-Timestamp header, and in
+// the X-Cali-Signature header "sha256=" followed by the hex encoded
+// HMAC-SHA256, keyed with the secret, of the timestamp, a dot and the raw
+// request body:
+//
+//	X-Cali-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Receivers verify a request by computing the same HMAC over the timestamp
+// header and the body exactly as received, comparing it to the signature in
+// constant time, and rejecting timestamps too far from their own clock, e.g.
+// by more than five minutes, so captured requests cannot be replayed later.
+// Verify implements this check. For example, with the secret "s3cret", the
+// timestamp 1700000000 and the body {"event":"appointment.created"} the
+// signature is
+//
+//	sha256=55b592ae064f67002ad94331dfe01d0bf605f6dfe7cc7e9deae40306cf5be6eb
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// queueSize is the number of deliveries that may be pending before further
+// ones are dropped.
+const queueSize = 1000
+
+// backoff lists the waits before retrying a failed delivery; its length is
+// the number of retries.
+var backoff = []time.Duration{time.Second, 4 * time.Second}
+
+const (
+	// SignatureHeader carries the signature of a payload, see Sign,
+	// prefixed with "sha256=".
+	SignatureHeader = "X-Cali-Signature"
+	// TimestampHeader carries the Unix time in seconds a payload was
+	// signed at.
+	TimestampHeader = "X-Cali-Timestamp"
+)
+
+var (
+	// ErrInvalidSignature is returned by Verify for a signature that does
+	// not match the payload.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpiredTimestamp is returned by Verify for a payload signed too
+	// long ago or too far in the future.
+	ErrExpiredTimestamp = errors.New("timestamp outside tolerance")
+)
+
+// Payload is the JSON body posted to webhook URLs.
+type Payload struct {
+	// Event is e.g. "appointment.created".
+	Event       string              `json:"event"`
+	Appointment *models.Appointment `json:"appointment"`
+}
+
+// Target is a URL payloads are posted to, signed with Secret if it is not
+// empty.
+type Target struct {
+	URL    string
+	Secret string
+}
+
+type delivery struct {
+	target Target
+	body   []byte
+	// restricted deliveries may only be made to public addresses.
+	restricted bool
+}
+
+// ErrPrivateAddress is returned when a delivery to a target given per
+// notification would connect to an address that is not public, see
+// models.PublicAddr.
+var ErrPrivateAddress = errors.New("address is not public")
+
+// Dispatcher posts payloads to a set of configured URLs, and to targets
+// given per notification, in the background, retrying failed deliveries
+// with backoff. Deliveries that still fail are logged and dropped. Each
+// target URL has its own queue, delivered in order, so a slow or failing
+// target only delays its own notifications.
+//
+// Targets given per notification are subscribed by users, so they are only
+// posted to if they resolve to public addresses. Redirects are never
+// followed.
+type Dispatcher struct {
+	targets []Target
+	// client posts to the configured URLs, restricted to targets given
+	// per notification.
+	client, restricted *http.Client
+	wg                 sync.WaitGroup
+
+	mu sync.Mutex
+	// queues holds the pending deliveries of each target URL, the first
+	// being the one in progress. A target has a goroutine delivering its
+	// queue exactly while the queue is not empty.
+	queues  map[string][]delivery
+	pending int
+	closed  bool
+}
+
+// NewDispatcher returns a dispatcher posting to urls, each attempt bounded
+// by timeout. Payloads to urls are signed if secret is not empty.
+func NewDispatcher(urls []string, secret string, timeout time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		client:     newClient(timeout, nil),
+		restricted: newClient(timeout, publicOnly),
+		queues:     make(map[string][]delivery),
+	}
+	for _, u := range urls {
+		d.targets = append(d.targets, Target{URL: u, Secret: secret})
+	}
+	return d
+}
+
+// newClient returns a client not following redirects, whose connections
+// are checked by control, if not nil. Such clients connect directly, as
+// the check would otherwise apply to the proxy.
+func newClient(timeout time.Duration, control func(network, address string, c syscall.RawConn) error) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if control != nil {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: control}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicOnly is a dialer control refusing connections to addresses that
+// are not public. It sees the resolved address, so host names resolving
+// to internal addresses are refused as well.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !models.PublicAddr(addr) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// Send queues a notification about appt for every configured URL and
+// every one of targets, and returns without waiting for delivery.
+func (d *Dispatcher) Send(event string, appt *models.Appointment, targets ...Target) {
+	if len(targets)+len(d.targets) == 0 {
+		return
+	}
+	body, err := json.Marshal(Payload{Event: event, Appointment: appt})
+	if err != nil {
+		log.Printf("Webhook %s for appointment %d: %v", event, appt.ID, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for _, t := range targets {
+		d.enqueue(delivery{target: t, body: body, restricted: true}, event, appt)
+	}
+	for _, t := range d.targets {
+		d.enqueue(delivery{target: t, body: body}, event, appt)
+	}
+}
+
+// enqueue adds dl to the queue of its target, starting to deliver the
+// queue if it was empty. d.mu must be held.
+func (d *Dispatcher) enqueue(dl delivery, event string, appt *models.Appointment) {
+	if d.pending == queueSize {
+		log.Printf("Webhook queue full, dropping %s for appointment %d to %s", event, appt.ID, dl.target.URL)
+		return
+	}
+	d.pending++
+	url := dl.target.URL
+	d.queues[url] = append(d.queues[url], dl)
+	if len(d.queues[url]) == 1 {
+		d.wg.Add(1)
+		go d.run(url)
+	}
+}
+
+// run delivers the queue of the target URL until it is empty.
+func (d *Dispatcher) run(url string) {
+	defer d.wg.Done()
+	d.mu.Lock()
+	for len(d.queues[url]) > 0 {
+		dl := d.queues[url][0]
+		d.mu.Unlock()
+		d.deliver(dl)
+		d.mu.Lock()
+		d.queues[url] = d.queues[url][1:]
+		d.pending--
+	}
+	delete(d.queues, url)
+	d.mu.Unlock()
+}
+
+// Close stops accepting notifications and waits for queued ones to be
+// delivered, or for ctx to be done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhooks still pending: %w", ctx.Err())
+	}
+}
+
+// deliver posts a payload, retrying on network errors, 429 and 5xx
+// responses. Deliveries refused by publicOnly are not retried.
+func (d *Dispatcher) deliver(dl delivery) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = d.post(dl)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == len(backoff) || errors.Is(err, ErrPrivateAddress) {
+			break
+		}
+		time.Sleep(backoff[attempt])
+	}
+	log.Printf("Webhook delivery to %s failed: %v", dl.target.URL, err)
+}
+
+// post makes a single delivery attempt and reports whether a failure is
+// worth retrying.
+func (d *Dispatcher) post(dl delivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, dl.target.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cali-webhook")
+	if dl.target.Secret != "" {
+		// Signed per attempt, so retries are not rejected as replays.
+		timestamp := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, "sha256="+Sign([]byte(dl.target.Secret), timestamp, dl.body))
+	}
+
+	client := d.client
+	if dl.restricted {
+		client = d.restricted
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 under secret of the decimal
+// timestamp, a dot and body, as sent in the signature header.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the values of the signature and timestamp headers of a
+// payload signed with secret, and that it was signed no more than
+// tolerance before or after now.
+func Verify(secret []byte, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrExpiredTimestamp
+	}
+	want := "sha256=" + Sign(secret, ts, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miku/cali/internal/models"
+)
+
+// The test vector documented in the package comment, computed
+// independently with:
+//
+//	printf '%s' '1700000000.{"event":"appointment.created"}' | openssl dgst -sha256 -hmac s3cret
+const (
+	vectorSecret    = "s3cret"
+	vectorTimestamp = 1700000000
+	vectorBody      = `{"event":"appointment.created"}`
+	vectorSignature = "55b592ae064f67002ad94331dfe01d0bf605f6dfe7cc7e9deae40306cf5be6eb"
+)
+
+func TestSign(t *testing.T) {
+	var cases = []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{vectorSecret, vectorTimestamp, vectorBody, vectorSignature},
+		{"", 0, "", "b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, c := range cases {
+		if got := Sign([]byte(c.secret), c.timestamp, []byte(c.body)); got != c.want {
+			t.Errorf("Sign(%q, %d, %q) = %s, want %s", c.secret, c.timestamp, c.body, got, c.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		signed = time.Unix(vectorTimestamp, 0)
+		sig    = "sha256=" + vectorSignature
+	)
+	var cases = []struct {
+		about     string
+		secret    string
+		signature string
+		timestamp string
+		body      string
+		now       time.Time
+		err       error
+	}{
+		{"valid", vectorSecret, sig, "1700000000", vectorBody, signed, nil},
+		{"valid within tolerance", vectorSecret, sig, "1700000000", vectorBody, signed.Add(5 * time.Minute), nil},
+		{"clock behind sender", vectorSecret, sig, "1700000000", vectorBody, signed.Add(-time.Minute), nil},
+		{"replayed later", vectorSecret, sig, "1700000000", vectorBody, signed.Add(6 * time.Minute), ErrExpiredTimestamp},
+		{"wrong secret", "other", sig, "1700000000", vectorBody, signed, ErrInvalidSignature},
+		{"tampered body", vectorSecret, sig, "1700000000", `{"event":"appointment.deleted"}`, signed, ErrInvalidSignature},
+		{"tampered timestamp", vectorSecret, sig, "1700000001", vectorBody, signed, ErrInvalidSignature},
+		{"missing prefix", vectorSecret, vectorSignature, "1700000000", vectorBody, signed, ErrInvalidSignature},
+	}
+	for _, c := range cases {
+		err := Verify([]byte(c.secret), c.signature, c.timestamp, []byte(c.body), 5*time.Minute, c.now)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.about, err, c.err)
+		}
+	}
+	if err := Verify([]byte(vectorSecret), sig, "soon", []byte(vectorBody), 5*time.Minute, signed); err == nil {
+		t.Errorf("malformed timestamp: got nil error")
+	}
+}
+
+// hits counts the requests to each path of a test server.
+type hits struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (h *hits) get(path string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paths[path]
+}
+
+// newTestTarget returns a server answering /redirect with a redirect to
+// /ok, /fail with 500 and anything else with 204, counting the requests.
+func newTestTarget(t *testing.T) (*httptest.Server, *hits) {
+	h := &hits{paths: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.paths[r.URL.Path]++
+		h.mu.Unlock()
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, h
+}
+
+func TestDispatcherTargets(t *testing.T) {
+	srv, h := newTestTarget(t)
+	d := NewDispatcher([]string{srv.URL + "/configured", srv.URL + "/redirect"}, "", 5*time.Second)
+	// The test server listens on a loopback address, which only
+	// configured URLs may be posted to.
+	d.Send(models.EventCreated, &models.Appointment{ID: 1}, Target{URL: srv.URL + "/subscribed"})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		path string
+		want int
+	}{
+		{"/configured", 1},
+		{"/subscribed", 0},
+		{"/redirect", 1},
+		{"/ok", 0},
+	}
+	for _, c := range cases {
+		if got := h.get(c.path); got != c.want {
+			t.Errorf("%s: got %d requests, want %d", c.path, got, c.want)
+		}
+	}
+}
+
+func TestDispatcherFailingTarget(t *testing.T) {
+	defer func(b []time.Duration) { backoff = b }(backoff)
+	backoff = []time.Duration{300 * time.Millisecond}
+
+	srv, h := newTestTarget(t)
+	d := NewDispatcher([]string{srv.URL + "/fail", srv.URL + "/configured"}, "", 5*time.Second)
+	const n = 5
+	for i := 0; i < n; i++ {
+		d.Send(models.EventCreated, &models.Appointment{ID: int64(i)})
+	}
+	// The retries of the failing target do not hold up the other.
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for h.get("/configured") < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := h.get("/configured"); got != n {
+		t.Errorf("got %d deliveries within the deadline, want %d", got, n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.get("/fail"), n*(len(backoff)+1); got != want {
+		t.Errorf("got %d attempts to the failing target, want %d", got, want)
+	}
+}
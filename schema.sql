@@ -1,6 +1,13 @@
 CREATE TABLE IF NOT EXISTS users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     username TEXT UNIQUE NOT NULL,
+    password_hash TEXT,
+    timezone TEXT,
+    email TEXT,
+    email_notifications TEXT,
+    email_verified_at TIMESTAMP,
+    reminders_snoozed_from TIMESTAMP,
+    reminders_snoozed_until TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
 
@@ -8,12 +15,106 @@ CREATE TABLE IF NOT EXISTS appointments (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     user_id INTEGER NOT NULL,
     title TEXT NOT NULL,
+    original_title TEXT,
+    slug TEXT,
+    uid TEXT,
     description TEXT,
+    location TEXT,
+    conference_url TEXT,
+    category TEXT,
+    color TEXT,
     start_time TIMESTAMP NOT NULL,
     end_time TIMESTAMP NOT NULL,
+    all_day BOOLEAN NOT NULL DEFAULT 0,
+    timezone TEXT,
+    recurrence TEXT,
+    exdates TEXT,
+    status TEXT NOT NULL DEFAULT 'confirmed'
+        CHECK (status IN ('confirmed', 'tentative', 'cancelled')),
+    actual_start TIMESTAMP,
+    actual_end TIMESTAMP,
+    sort_order INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+    merged_into INTEGER REFERENCES appointments(id),
+    merged_from TEXT,
+    reminder_channels TEXT,
     FOREIGN KEY (user_id) REFERENCES users(id),
-    CHECK (end_time > start_time)
+    CHECK (end_time > start_time OR (all_day AND end_time >= start_time))
     );
 
+CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_slug
+    ON appointments (user_id, slug);
+
+CREATE INDEX IF NOT EXISTS idx_appointments_category
+    ON appointments (user_id, category);
+
+CREATE INDEX IF NOT EXISTS idx_appointments_updated
+    ON appointments (user_id, updated_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_uid
+    ON appointments (user_id, uid) WHERE uid IS NOT NULL AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS appointment_attendees (
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    email TEXT NOT NULL COLLATE NOCASE,
+    response_status TEXT NOT NULL DEFAULT 'needs-action'
+        CHECK (response_status IN ('needs-action', 'accepted', 'declined', 'tentative')),
+    user_id INTEGER REFERENCES users(id),
+    PRIMARY KEY (appointment_id, email)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_attendees_user ON appointment_attendees (user_id) WHERE user_id IS NOT NULL;
+
+CREATE TRIGGER IF NOT EXISTS appointment_attendees_cascade AFTER DELETE ON appointments
+BEGIN
+    DELETE FROM appointment_attendees WHERE appointment_id = OLD.id;
+END;
+
+
+-- Optional, enabled with database.uniqueappointments
+-- CREATE UNIQUE INDEX IF NOT EXISTS idx_appointments_unique
+--     ON appointments (user_id, title, start_time) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS reminders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    minutes_before INTEGER NOT NULL CHECK (minutes_before >= 0),
+    method TEXT NOT NULL CHECK (method IN ('log', 'webhook', 'desktop')),
+    sent_at TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_reminders_appointment ON reminders (appointment_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, key)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys (created_at);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    events TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks (user_id);